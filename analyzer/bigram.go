@@ -0,0 +1,74 @@
+package analyzer
+
+import (
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pickjunk/wuneng/types"
+)
+
+// BigramAnalyzer 中日韩文字按相邻两字切分（二元切分），不需要词典
+//
+// 连续的中日韩文字输出全部相邻的两个字，只有一个字时输出这个字；
+// 其他字母和数字按连续的一段作为一个关键词；标点和空白作为分隔符。关键词不做大小写转换
+type BigramAnalyzer struct{}
+
+// Analyze 分词
+func (BigramAnalyzer) Analyze(text string) []types.AnalyzedToken {
+	var tokens []types.AnalyzedToken
+
+	// 当前一段连续字符的起点，以及其中各中日韩文字的起点
+	start := -1
+	var cjk []int
+	flushCJK := func(end int) {
+		if len(cjk) == 1 {
+			tokens = append(tokens, types.AnalyzedToken{Text: text[cjk[0]:end], Start: cjk[0], End: end})
+		}
+		for i := 0; i+1 < len(cjk); i++ {
+			bigramEnd := end
+			if i+2 < len(cjk) {
+				bigramEnd = cjk[i+2]
+			}
+			tokens = append(tokens, types.AnalyzedToken{Text: text[cjk[i]:bigramEnd], Start: cjk[i], End: bigramEnd})
+		}
+		cjk = cjk[:0]
+	}
+	flushWord := func(end int) {
+		if start >= 0 {
+			tokens = append(tokens, types.AnalyzedToken{Text: text[start:end], Start: start, End: end})
+			start = -1
+		}
+	}
+
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		switch {
+		case isCJK(r):
+			flushWord(i)
+			cjk = append(cjk, i)
+		case isSeparator(r):
+			flushWord(i)
+			flushCJK(i)
+		default:
+			flushCJK(i)
+			if start < 0 {
+				start = i
+			}
+		}
+		i += size
+	}
+	flushWord(len(text))
+	flushCJK(len(text))
+	return tokens
+}
+
+// 不是字母或数字的字符（含无效的UTF-8字节）作为分隔符
+func isSeparator(r rune) bool {
+	return r == utf8.RuneError || !(unicode.IsLetter(r) || unicode.IsNumber(r))
+}
+
+// 中日韩文字：汉字、平假名、片假名和谚文
+func isCJK(r rune) bool {
+	return unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) ||
+		unicode.Is(unicode.Katakana, r) || unicode.Is(unicode.Hangul, r)
+}
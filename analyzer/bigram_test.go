@@ -0,0 +1,16 @@
+package analyzer
+
+import (
+	"testing"
+
+	"github.com/pickjunk/wuneng/utils"
+)
+
+func TestBigramAnalyzer(t *testing.T) {
+	var analyzer BigramAnalyzer
+	utils.Expect(t, "[{中国 0 6} {国人 3 9} {人口 6 12}]", analyzer.Analyze("中国人口"))
+	utils.Expect(t, "[{百度 0 6} {度收 3 9} {收购 6 12} {91 12 14} {无线 14 20}]", analyzer.Analyze("百度收购91无线"))
+	utils.Expect(t, "[{iPhone 0 6} {手 7 10} {ab 13 15}]", analyzer.Analyze("iPhone 手，ab"))
+	utils.Expect(t, "[{にほ 0 6} {ほん 3 9}]", analyzer.Analyze("にほん"))
+	utils.Expect(t, "[]", analyzer.Analyze("，。 "))
+}
@@ -0,0 +1,127 @@
+package analyzer
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pickjunk/wuneng/types"
+)
+
+// FilteredAnalyzer 先用Analyzer切分，再依次经过各过滤器
+type FilteredAnalyzer struct {
+	Analyzer types.Analyzer
+	Filters  []types.TokenFilter
+}
+
+// Analyze 分词并过滤
+func (analyzer *FilteredAnalyzer) Analyze(text string) []types.AnalyzedToken {
+	tokens := analyzer.Analyzer.Analyze(text)
+	for _, filter := range analyzer.Filters {
+		tokens = filter.Filter(tokens)
+	}
+	return tokens
+}
+
+// 对每个关键词的Text做转换，转换为空串的关键词被去掉
+func mapTokens(tokens []types.AnalyzedToken, convert func(string) string) []types.AnalyzedToken {
+	filtered := tokens[:0]
+	for _, token := range tokens {
+		if token.Text = convert(token.Text); token.Text != "" {
+			filtered = append(filtered, token)
+		}
+	}
+	return filtered
+}
+
+// LowercaseFilter 字母转为小写
+type LowercaseFilter struct{}
+
+// Filter 过滤
+func (LowercaseFilter) Filter(tokens []types.AnalyzedToken) []types.AnalyzedToken {
+	return mapTokens(tokens, strings.ToLower)
+}
+
+// WidthFilter 全角字母、数字、标点和空格转为半角
+// 过滤器在切分之后作用，连续的全角字母须被分析器切为一个关键词（如WhitespaceAnalyzer和BigramAnalyzer）
+type WidthFilter struct{}
+
+// Filter 过滤
+func (WidthFilter) Filter(tokens []types.AnalyzedToken) []types.AnalyzedToken {
+	return mapTokens(tokens, func(text string) string {
+		return strings.Map(func(r rune) rune {
+			switch {
+			case r == '　':
+				return ' '
+			case r >= '！' && r <= '～':
+				return r - 0xfee0
+			}
+			return r
+		}, text)
+	})
+}
+
+// MinLengthFilter 去掉字符数少于MinLength的关键词
+type MinLengthFilter struct {
+	MinLength int
+}
+
+// Filter 过滤
+func (filter MinLengthFilter) Filter(tokens []types.AnalyzedToken) []types.AnalyzedToken {
+	return mapTokens(tokens, func(text string) string {
+		if utf8.RuneCountInString(text) < filter.MinLength {
+			return ""
+		}
+		return text
+	})
+}
+
+// PunctuationFilter 去掉只由标点、符号和空白组成的关键词
+type PunctuationFilter struct{}
+
+// Filter 过滤
+func (PunctuationFilter) Filter(tokens []types.AnalyzedToken) []types.AnalyzedToken {
+	return mapTokens(tokens, func(text string) string {
+		for _, r := range text {
+			if unicode.IsLetter(r) || unicode.IsNumber(r) {
+				return text
+			}
+		}
+		return ""
+	})
+}
+
+// StopFilter 去掉停用词，如"的"、"了"
+type StopFilter struct {
+	Words map[string]bool
+}
+
+// LoadStopFilter 从文件载入停用词，每行一个词，首尾的空白被忽略
+func LoadStopFilter(file string) (*StopFilter, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	filter := &StopFilter{Words: make(map[string]bool)}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if word := strings.TrimSpace(scanner.Text()); word != "" {
+			filter.Words[word] = true
+		}
+	}
+	return filter, scanner.Err()
+}
+
+// Filter 过滤
+func (filter *StopFilter) Filter(tokens []types.AnalyzedToken) []types.AnalyzedToken {
+	return mapTokens(tokens, func(text string) string {
+		if filter.Words[text] {
+			return ""
+		}
+		return text
+	})
+}
@@ -0,0 +1,28 @@
+package analyzer
+
+import (
+	"testing"
+
+	"github.com/pickjunk/wuneng/types"
+	"github.com/pickjunk/wuneng/utils"
+)
+
+func TestFilters(t *testing.T) {
+	analyze := func(text string, filters ...types.TokenFilter) []types.AnalyzedToken {
+		analyzer := FilteredAnalyzer{Analyzer: WhitespaceAnalyzer{}, Filters: filters}
+		return analyzer.Analyze(text)
+	}
+	utils.Expect(t, "[{iphone 0 6} {中国 7 13}]", analyze("iPhone 中国", LowercaseFilter{}))
+	utils.Expect(t, "[{iPhone11 0 24} {a,b 25 32}]", analyze("ｉＰｈｏｎｅ１１ ａ，b", WidthFilter{}))
+	utils.Expect(t, "[{iphone11 0 24}]", analyze("ｉＰｈｏｎｅ１１", WidthFilter{}, LowercaseFilter{}))
+	utils.Expect(t, "[{中国 4 10}]", analyze("中 中国 a", MinLengthFilter{MinLength: 2}))
+	utils.Expect(t, "[{a,b 7 10} {１ 15 18}]", analyze("，。 a,b ... １", PunctuationFilter{}))
+
+	stop, err := LoadStopFilter("../test/test_stop_tokens.txt")
+	utils.Expect(t, "<nil>", err)
+	utils.Expect(t, "[{中国 0 6} {人口 11 17}]", analyze("中国 的 人口 ，", stop))
+	utils.Expect(t, "[{中国 4 10}]", analyze("The 中国", LowercaseFilter{}, stop))
+
+	_, err = LoadStopFilter("../test/no_such_file.txt")
+	utils.Expect(t, "false", err == nil)
+}
@@ -0,0 +1,34 @@
+package analyzer
+
+import (
+	"github.com/pickjunk/sego"
+	"github.com/pickjunk/wuneng/types"
+)
+
+// SegoAnalyzer 用sego分词器切分文本，Segmenter须已载入词典
+type SegoAnalyzer struct {
+	Segmenter *sego.Segmenter
+
+	// 为true时使用全切分（FullSegment），长词的各个子词也作为关键词，适合索引；
+	// 否则使用普通切分（Segment），适合搜索
+	Full bool
+}
+
+// Analyze 分词
+func (analyzer *SegoAnalyzer) Analyze(text string) []types.AnalyzedToken {
+	var segments []sego.Segment
+	if analyzer.Full {
+		segments = analyzer.Segmenter.FullSegment([]byte(text))
+	} else {
+		segments = analyzer.Segmenter.Segment([]byte(text))
+	}
+	tokens := make([]types.AnalyzedToken, len(segments))
+	for i, segment := range segments {
+		tokens[i] = types.AnalyzedToken{
+			Text:  segment.Token().Text(),
+			Start: segment.Start(),
+			End:   segment.End(),
+		}
+	}
+	return tokens
+}
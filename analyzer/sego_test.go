@@ -0,0 +1,19 @@
+package analyzer
+
+import (
+	"testing"
+
+	"github.com/pickjunk/sego"
+	"github.com/pickjunk/wuneng/utils"
+)
+
+func TestSegoAnalyzer(t *testing.T) {
+	var segmenter sego.Segmenter
+	segmenter.LoadDictionary("../test/test_dict.txt")
+
+	analyzer := SegoAnalyzer{Segmenter: &segmenter}
+	utils.Expect(t, "[{中国 0 6} {有 6 9} {十三亿 9 18}]", analyzer.Analyze("中国有十三亿"))
+
+	analyzer.Full = true
+	utils.Expect(t, "[{中 0 3} {国 3 6} {中国 0 6} {有 6 9}]", analyzer.Analyze("中国有"))
+}
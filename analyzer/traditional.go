@@ -0,0 +1,72 @@
+package analyzer
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pickjunk/wuneng/types"
+	"github.com/pickjunk/wuneng/utils"
+)
+
+// TraditionalFilter 繁体转为简体
+type TraditionalFilter struct {
+	// 繁体到简体的对照，键可以是单字或词，词优先，longest为其中最长的键的字数
+	table   map[string]string
+	longest int
+}
+
+// LoadTraditionalFilter 从文件载入繁简对照表
+// 每行为繁体字（或词）及对应的简体，以空白分隔，如"國 国"、"頭髮 头发"，一个繁体字对应多个简体字时只用第一个
+func LoadTraditionalFilter(file string) (*TraditionalFilter, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	filter := &TraditionalFilter{table: make(map[string]string)}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		if _, found := filter.table[fields[0]]; found {
+			continue
+		}
+		filter.table[fields[0]] = fields[1]
+		if length := utf8.RuneCountInString(fields[0]); length > filter.longest {
+			filter.longest = length
+		}
+	}
+	return filter, scanner.Err()
+}
+
+// Filter 过滤
+func (filter *TraditionalFilter) Filter(tokens []types.AnalyzedToken) []types.AnalyzedToken {
+	return mapTokens(tokens, filter.convert)
+}
+
+// 从左到右按最长匹配替换
+func (filter *TraditionalFilter) convert(text string) string {
+	runes := []rune(text)
+	var builder strings.Builder
+	for i := 0; i < len(runes); {
+		matched := false
+		for length := utils.MinInt(filter.longest, len(runes)-i); length > 0; length-- {
+			if simplified, found := filter.table[string(runes[i:i+length])]; found {
+				builder.WriteString(simplified)
+				i += length
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			builder.WriteRune(runes[i])
+			i++
+		}
+	}
+	return builder.String()
+}
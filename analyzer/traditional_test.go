@@ -0,0 +1,19 @@
+package analyzer
+
+import (
+	"testing"
+
+	"github.com/pickjunk/wuneng/types"
+	"github.com/pickjunk/wuneng/utils"
+)
+
+func TestTraditionalFilter(t *testing.T) {
+	filter, err := LoadTraditionalFilter("../test/test_traditional.txt")
+	utils.Expect(t, "<nil>", err)
+	utils.Expect(t, "中国", filter.convert("中國"))
+	utils.Expect(t, "头发", filter.convert("頭髮"))
+	utils.Expect(t, "干净", filter.convert("乾净"))
+	utils.Expect(t, "乾隆", filter.convert("乾隆"))
+	utils.Expect(t, "[{中国 0 6} {头发 7 13}]",
+		filter.Filter([]types.AnalyzedToken{{Text: "中國", Start: 0, End: 6}, {Text: "頭髮", Start: 7, End: 13}}))
+}
@@ -0,0 +1,32 @@
+package analyzer
+
+import (
+	"unicode"
+
+	"github.com/pickjunk/wuneng/types"
+)
+
+// WhitespaceAnalyzer 以空白字符切分文本，关键词原样保留，适合已分好词或以空格分隔的文本
+type WhitespaceAnalyzer struct{}
+
+// Analyze 分词
+func (WhitespaceAnalyzer) Analyze(text string) []types.AnalyzedToken {
+	var tokens []types.AnalyzedToken
+	start := -1
+	for i, r := range text {
+		if unicode.IsSpace(r) {
+			if start >= 0 {
+				tokens = append(tokens, types.AnalyzedToken{Text: text[start:i], Start: start, End: i})
+				start = -1
+			}
+			continue
+		}
+		if start < 0 {
+			start = i
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, types.AnalyzedToken{Text: text[start:], Start: start, End: len(text)})
+	}
+	return tokens
+}
@@ -0,0 +1,13 @@
+package analyzer
+
+import (
+	"testing"
+
+	"github.com/pickjunk/wuneng/utils"
+)
+
+func TestWhitespaceAnalyzer(t *testing.T) {
+	var analyzer WhitespaceAnalyzer
+	utils.Expect(t, "[{Hello 0 5} {世界 6 12} {a,b 14 17}]", analyzer.Analyze("Hello 世界\t a,b\n"))
+	utils.Expect(t, "[]", analyzer.Analyze(" \n "))
+}
@@ -0,0 +1,100 @@
+package core
+
+import (
+	"github.com/pickjunk/wuneng/types"
+)
+
+// 计算文档在各搜索键上的BM25F，dfs为各搜索键的文档数，调用者须持有tableLock读锁
+//
+// 各字段的词频按字段长度归一化并乘以字段权重后合并为一个词频，再代入BM25的饱和函数：
+//
+//	tf = Sum(Boost_f * tf_f / (1 - B_f + B_f * l_f / avgl_f))
+//	BM25F = Sum(idf * tf * (K1 + 1) / (tf + K1))
+//
+// 限定字段的搜索键（FieldKeyword）只计该字段的词频，boosts不为nil时各搜索键的得分乘以其中的系数
+func (indexer *Indexer) documentBM25F(seg *segment, docID uint64, tokens []string, dfs []int, boosts []float32) float32 {
+	parameters := indexer.initOptions.BM25FParameters
+	if parameters == nil || indexer.numDocuments == 0 {
+		return 0
+	}
+
+	bm25f := float32(0)
+	for i, token := range tokens {
+		if dfs[i] == 0 {
+			continue
+		}
+		var frequency float32
+		if field, found := indexer.tokenField(token); found {
+			frequency = indexer.segmentFrequency(seg, token, docID) * indexer.fieldWeight(field, docID)
+		} else {
+			// 未列出字段的词频为总词频减去各字段的词频
+			rest := indexer.segmentFrequency(seg, token, docID)
+			for field := range parameters.Fields {
+				fieldFrequency := indexer.segmentFrequency(seg, types.FieldKeyword(field, token), docID)
+				rest -= fieldFrequency
+				frequency += fieldFrequency * indexer.fieldWeight(field, docID)
+			}
+			if rest > 0 {
+				frequency += rest * indexer.fieldWeight("", docID)
+			}
+		}
+		if frequency > 0 {
+			score := indexer.idf(dfs[i]) * frequency * (parameters.K1 + 1) / (frequency + parameters.K1)
+			if boosts != nil {
+				score *= boosts[i]
+			}
+			bm25f += score
+		}
+	}
+	return bm25f
+}
+
+// 限定在BM25FParameters.Fields中某字段的搜索键（FieldKeyword）返回该字段名，标签等其他搜索键不属于任何字段
+func (indexer *Indexer) tokenField(token string) (string, bool) {
+	field, _, ok := types.SplitFieldKeyword(token)
+	if !ok {
+		return "", false
+	}
+	_, found := indexer.initOptions.BM25FParameters.Fields[field]
+	return field, found
+}
+
+// 字段中一次出现折合的词频，即权重除以长度归一化因子
+// field为空时表示不属于任何列出字段的部分
+func (indexer *Indexer) fieldWeight(field string, docID uint64) float32 {
+	parameters := indexer.initOptions.BM25FParameters
+	boost, b := float32(1), parameters.B
+	var length, totalLength float32
+	if field != "" {
+		boost, b = parameters.Fields[field].Boost, parameters.Fields[field].B
+		length, totalLength = indexer.docFieldLengths[docID][field], indexer.fieldTokenLengths[field]
+	} else {
+		length, totalLength = indexer.docTokenLengths[docID], indexer.totalTokenLength
+		for field := range parameters.Fields {
+			length -= indexer.docFieldLengths[docID][field]
+			totalLength -= indexer.fieldTokenLengths[field]
+		}
+	}
+	avgLength := totalLength / float32(indexer.numDocuments)
+	if avgLength <= 0 {
+		return boost
+	}
+	return boost / (1 - b + b*length/avgLength)
+}
+
+// 文档在段中某搜索键上的词频，不包含该搜索键时为0
+func (indexer *Indexer) segmentFrequency(seg *segment, keyword string, docID uint64) float32 {
+	indices, found := seg.table[keyword]
+	if !found {
+		return 0
+	}
+	cursor := newPostingCursor(indices)
+	position, found := indexer.searchIndex(cursor, 0, indexer.getIndexLength(indices)-1, docID)
+	if !found {
+		return 0
+	}
+	if indexer.initOptions.IndexType == types.LocationsIndex {
+		return float32(len(indexer.getLocations(cursor, position)))
+	}
+	return indexer.getFrequency(cursor, position)
+}
@@ -0,0 +1,126 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/pickjunk/wuneng/types"
+	"github.com/pickjunk/wuneng/utils"
+)
+
+func TestBM25F(t *testing.T) {
+	options := types.IndexerInitOptions{
+		IndexType:      types.FrequenciesIndex,
+		BM25Parameters: &types.BM25Parameters{K1: 2, B: 0.75},
+		BM25FParameters: &types.BM25FParameters{K1: 2, B: 0.75, Fields: map[string]types.FieldParameters{
+			"title": {Boost: 3, B: 0.5},
+		}},
+	}
+	var indexer Indexer
+	indexer.Init(options)
+	// doc1 标题 = "a x"，正文 = "b b"
+	indexer.AddDocumentToCache(&types.DocumentIndex{
+		DocID:        1,
+		TokenLength:  4,
+		FieldLengths: map[string]float32{"title": 2},
+		Keywords: []types.KeywordIndex{
+			{Text: "a", Frequency: 1},
+			{Text: types.FieldKeyword("title", "a"), Frequency: 1},
+			{Text: "x", Frequency: 1},
+			{Text: types.FieldKeyword("title", "x"), Frequency: 1},
+			{Text: "b", Frequency: 2},
+		},
+	}, false)
+	// doc2 标题 = "b b"，正文 = "a x"
+	indexer.AddDocumentToCache(&types.DocumentIndex{
+		DocID:        2,
+		TokenLength:  4,
+		FieldLengths: map[string]float32{"title": 2},
+		Keywords: []types.KeywordIndex{
+			{Text: "b", Frequency: 2},
+			{Text: types.FieldKeyword("title", "b"), Frequency: 2},
+			{Text: "a", Frequency: 1},
+			{Text: "x", Frequency: 1},
+		},
+	}, true)
+
+	// BM25相同，BM25F中标题的权重更高
+	docs, _ := indexer.Lookup([]string{"a"}, nil, nil, false)
+	utils.Expect(t, "2", len(docs))
+	utils.Expect(t, fmt.Sprint(docs[0].BM25), docs[1].BM25)
+	utils.Expect(t, "1", docs[0].BM25F)
+	utils.Expect(t, "1.8", docs[1].BM25F)
+
+	docs, _ = indexer.LookupQuery(&types.Query{Token: "a"}, nil, false)
+	utils.Expect(t, "1", docs[0].BM25F)
+	utils.Expect(t, "1.8", docs[1].BM25F)
+
+	// 限定字段
+	docs, _ = indexer.LookupQuery(&types.Query{Token: "a", Field: "title"}, nil, false)
+	utils.Expect(t, "1", len(docs))
+	utils.Expect(t, "1", docs[0].DocID)
+	docs, _ = indexer.LookupQuery(&types.Query{Phrase: []string{"b", "a"}, Field: "title"}, nil, false)
+	utils.Expect(t, "0", len(docs))
+
+	// 字段长度随快照保存
+	var buf bytes.Buffer
+	utils.Expect(t, "<nil>", indexer.Snapshot(&buf))
+	var restored Indexer
+	restored.Init(options)
+	utils.Expect(t, "<nil>", restored.Restore(&buf))
+	docs, _ = restored.Lookup([]string{"a"}, nil, nil, false)
+	utils.Expect(t, "1.8", docs[1].BM25F)
+
+	// 形如"字段名:值"的标签不属于字段
+	_, found := indexer.tokenField("title:a")
+	utils.Expect(t, "false", found)
+	field, _ := indexer.tokenField(types.FieldKeyword("title", "a"))
+	utils.Expect(t, "title", field)
+
+	// 删除文档后字段长度随之更新
+	indexer.RemoveDocumentToCache(2, true)
+	utils.Expect(t, "map[title:2]", indexer.fieldTokenLengths)
+}
+
+func TestBM25FDefaultK1(t *testing.T) {
+	lookup := func(parameters *types.BM25FParameters) []types.IndexedDocument {
+		var indexer Indexer
+		indexer.Init(types.IndexerInitOptions{
+			IndexType:       types.FrequenciesIndex,
+			BM25Parameters:  &types.BM25Parameters{K1: 2, B: 0.75},
+			BM25FParameters: parameters,
+		})
+		indexer.AddDocumentToCache(&types.DocumentIndex{
+			DocID:        1,
+			TokenLength:  4,
+			FieldLengths: map[string]float32{"title": 2},
+			Keywords: []types.KeywordIndex{
+				{Text: "a", Frequency: 1},
+				{Text: types.FieldKeyword("title", "a"), Frequency: 1},
+				{Text: "b", Frequency: 3},
+			},
+		}, false)
+		indexer.AddDocumentToCache(&types.DocumentIndex{
+			DocID:       2,
+			TokenLength: 4,
+			Keywords: []types.KeywordIndex{
+				{Text: "a", Frequency: 3},
+				{Text: "b", Frequency: 1},
+			},
+		}, true)
+		docs, _ := indexer.Lookup([]string{"a"}, nil, nil, false)
+		return docs
+	}
+	fields := map[string]types.FieldParameters{"title": {Boost: 3, B: 0.5}}
+
+	// K1为0时使用默认值，而不是退化为idf之和，用户的参数不被修改
+	parameters := &types.BM25FParameters{B: 0.75, Fields: fields}
+	docs := lookup(parameters)
+	expected := lookup(&types.BM25FParameters{K1: 2, B: 0.75, Fields: fields})
+	utils.Expect(t, "2", len(docs))
+	utils.Expect(t, fmt.Sprint(expected[0].BM25F), docs[0].BM25F)
+	utils.Expect(t, fmt.Sprint(expected[1].BM25F), docs[1].BM25F)
+	utils.Expect(t, "false", docs[0].BM25F == docs[1].BM25F)
+	utils.Expect(t, "0", parameters.K1)
+}
@@ -0,0 +1,13 @@
+package core
+
+import (
+	"errors"
+)
+
+var (
+	// ErrAlreadyInitialized 索引器或排序器重复初始化
+	ErrAlreadyInitialized = errors.New("wuneng: 不能初始化两次")
+
+	// ErrNotInitialized 索引器或排序器尚未初始化
+	ErrNotInitialized = errors.New("wuneng: 索引器或排序器尚未初始化")
+)
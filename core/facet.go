@@ -0,0 +1,60 @@
+package core
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pickjunk/wuneng/types"
+)
+
+// CountFacets 统计docs中带有各标签的文档数，只统计以prefixes之一开头的标签
+// docs通常为Lookup的输出，返回值中不包含计数为0的标签。索引器未初始化时返回ErrNotInitialized
+func (indexer *Indexer) CountFacets(docs []types.IndexedDocument, prefixes []string) (map[string]int, error) {
+	if indexer.initialized == false {
+		return nil, ErrNotInitialized
+	}
+
+	facets := make(map[string]int)
+	if len(docs) == 0 || len(prefixes) == 0 {
+		return facets, nil
+	}
+
+	docIDs := make([]uint64, len(docs))
+	for i, doc := range docs {
+		docIDs[i] = doc.DocID
+	}
+	sort.Sort(types.DocumentsID(docIDs))
+
+	// 去掉被其它前缀覆盖的前缀，使各前缀在有序搜索键中的范围互不重叠
+	sorted := append([]string(nil), prefixes...)
+	sort.Strings(sorted)
+	prefixes = sorted[:0]
+	for _, prefix := range sorted {
+		if len(prefixes) == 0 || !strings.HasPrefix(prefix, prefixes[len(prefixes)-1]) {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+
+	indexer.tableLock.RLock()
+	defer indexer.tableLock.RUnlock()
+	for _, seg := range indexer.tableLock.segments {
+		for _, prefix := range prefixes {
+			// 只遍历有序搜索键中以prefix开头的一段
+			for i := sort.SearchStrings(seg.terms, prefix); i < len(seg.terms) &&
+				strings.HasPrefix(seg.terms[i], prefix); i++ {
+				keyword := seg.terms[i]
+				// 同一文档可能在旧的段中留有已删除的索引项
+				n := 0
+				for _, docID := range indexer.matchPostings(docIDs, seg.table[keyword], true) {
+					if !seg.deleted[docID] {
+						n++
+					}
+				}
+				if n > 0 {
+					facets[keyword] += n
+				}
+			}
+		}
+	}
+	return facets, nil
+}
@@ -0,0 +1,51 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/pickjunk/wuneng/types"
+	"github.com/pickjunk/wuneng/utils"
+)
+
+func TestCountFacets(t *testing.T) {
+	var indexer Indexer
+	indexer.Init(types.IndexerInitOptions{IndexType: types.DocIDsIndex})
+	indexer.AddDocumentToCache(&types.DocumentIndex{
+		DocID: 1,
+		Keywords: []types.KeywordIndex{
+			{Text: "token1"}, {Text: "category:a"}, {Text: "brand:x"},
+		},
+	}, false)
+	indexer.AddDocumentToCache(&types.DocumentIndex{
+		DocID: 2,
+		Keywords: []types.KeywordIndex{
+			{Text: "token1"}, {Text: "category:b"}, {Text: "brand:x"},
+		},
+	}, false)
+	indexer.AddDocumentToCache(&types.DocumentIndex{
+		DocID: 3,
+		Keywords: []types.KeywordIndex{
+			{Text: "token1"}, {Text: "category:a"},
+		},
+	}, false)
+	indexer.AddDocumentToCache(&types.DocumentIndex{
+		DocID: 4,
+		Keywords: []types.KeywordIndex{
+			{Text: "token2"}, {Text: "category:b"},
+		},
+	}, true)
+
+	docs, _ := indexer.Lookup([]string{"token1"}, []string{}, nil, false)
+	countFacets := func(docs []types.IndexedDocument, prefixes []string) map[string]int {
+		facets, _ := indexer.CountFacets(docs, prefixes)
+		return facets
+	}
+	utils.Expect(t, "map[category:a:2 category:b:1]", countFacets(docs, []string{"category:"}))
+	utils.Expect(t, "map[brand:x:2 category:a:2 category:b:1]",
+		countFacets(docs, []string{"category:", "brand:"}))
+	// 前缀互相覆盖时每个标签只计一次
+	utils.Expect(t, "map[category:a:2 category:b:1]",
+		countFacets(docs, []string{"category:a", "category:", "cat"}))
+	utils.Expect(t, "map[]", countFacets(docs, []string{"color:"}))
+	utils.Expect(t, "map[]", countFacets(nil, []string{"category:"}))
+}
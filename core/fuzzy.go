@@ -0,0 +1,162 @@
+package core
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pickjunk/wuneng/types"
+	"github.com/pickjunk/wuneng/utils"
+)
+
+// Levenshtein自动机，状态为目标串与已读入字符串的编辑距离行，
+// row[i]为已读入部分与target前i个字符的编辑距离，超过maxEdits的值记为maxEdits+1
+type levenshteinAutomaton struct {
+	target   []rune
+	maxEdits int
+}
+
+func (automaton *levenshteinAutomaton) start() []int {
+	row := make([]int, len(automaton.target)+1)
+	for i := range row {
+		row[i] = utils.MinInt(i, automaton.maxEdits+1)
+	}
+	return row
+}
+
+// 读入字符r后的状态
+func (automaton *levenshteinAutomaton) step(row []int, r rune) []int {
+	next := make([]int, len(row))
+	next[0] = utils.MinInt(row[0]+1, automaton.maxEdits+1)
+	for i := 1; i < len(row); i++ {
+		cost := 1
+		if automaton.target[i-1] == r {
+			cost = 0
+		}
+		next[i] = utils.MinInt(utils.MinInt(row[i]+1, next[i-1]+1), row[i-1]+cost)
+		next[i] = utils.MinInt(next[i], automaton.maxEdits+1)
+	}
+	return next
+}
+
+// 再读入任何字符后都不可能接受时返回false
+func (automaton *levenshteinAutomaton) canMatch(row []int) bool {
+	for _, distance := range row {
+		if distance <= automaton.maxEdits {
+			return true
+		}
+	}
+	return false
+}
+
+// 已读入的字符串与目标串的编辑距离，不被接受时返回-1
+func (automaton *levenshteinAutomaton) distance(row []int) int {
+	if distance := row[len(row)-1]; distance <= automaton.maxEdits {
+		return distance
+	}
+	return -1
+}
+
+// FuzzyTerms 返回与token的编辑距离不超过maxEdits的搜索键（不含token本身）及其文档数，
+// 按编辑距离从小到大、文档数从大到小排列，最多n个
+//
+// 只考虑以prefix开头的搜索键，prefix之后的部分与token比较，前prefixLength个字符须与token相同。
+// 文档数为0的搜索键不返回，文档数和不返回的拼音、字段搜索键同Suggest。索引器未初始化时返回ErrNotInitialized
+func (indexer *Indexer) FuzzyTerms(prefix string, token string, maxEdits int, prefixLength int, n int) (
+	[]types.FuzzyTerm, error) {
+	if indexer.initialized == false {
+		return nil, ErrNotInitialized
+	}
+	if maxEdits <= 0 || n <= 0 {
+		return nil, nil
+	}
+
+	// 必须相同的开头部分
+	exact := prefix
+	target := token
+	for i := 0; i < prefixLength && target != ""; i++ {
+		_, size := utf8.DecodeRuneInString(target)
+		exact += target[:size]
+		target = target[size:]
+	}
+	automaton := levenshteinAutomaton{target: []rune(target), maxEdits: maxEdits}
+
+	indexer.tableLock.RLock()
+	defer indexer.tableLock.RUnlock()
+
+	distances := make(map[string]int)
+	counts := make(map[string]int)
+	for _, seg := range indexer.tableLock.segments {
+		for term, distance := range automaton.match(seg.terms, exact) {
+			if term == prefix+token || hiddenTerm(prefix+token, term) {
+				continue
+			}
+			count := indexer.segmentTermCount(seg, term, nil)
+			if count == 0 {
+				continue
+			}
+			if previous, found := distances[term]; !found || distance < previous {
+				distances[term] = distance
+			}
+			counts[term] += count
+		}
+	}
+
+	terms := make([]types.FuzzyTerm, 0, len(distances))
+	for term, distance := range distances {
+		terms = append(terms, types.FuzzyTerm{Text: term, Distance: distance, Frequency: counts[term]})
+	}
+	types.SortFuzzyTerms(terms)
+	if len(terms) > n {
+		terms = terms[:n]
+	}
+	return terms, nil
+}
+
+// 在升序排列的terms中找出以exact开头且其余部分被自动机接受的搜索键
+//
+// 相邻的搜索键共享的前缀只计算一次，某个前缀不可能被接受时跳过以它开头的全部搜索键
+func (automaton *levenshteinAutomaton) match(terms []string, exact string) map[string]int {
+	matches := make(map[string]int)
+	i := sort.SearchStrings(terms, exact)
+
+	// rows[k]为读入前k个字符后的状态，offsets[k]为这些字符的字节数
+	rows := [][]int{automaton.start()}
+	offsets := []int{0}
+	previous := ""
+	for i < len(terms) && strings.HasPrefix(terms[i], exact) {
+		suffix := terms[i][len(exact):]
+
+		// 回退到与上一个搜索键共同的前缀
+		common := 0
+		for common < len(suffix) && common < len(previous) && suffix[common] == previous[common] {
+			common++
+		}
+		for offsets[len(offsets)-1] > common {
+			rows, offsets = rows[:len(rows)-1], offsets[:len(offsets)-1]
+		}
+		previous = suffix
+
+		skipped := false
+		for offset := offsets[len(offsets)-1]; offset < len(suffix); {
+			r, size := utf8.DecodeRuneInString(suffix[offset:])
+			offset += size
+			row := automaton.step(rows[len(rows)-1], r)
+			rows, offsets = append(rows, row), append(offsets, offset)
+			if !automaton.canMatch(row) {
+				// 以此为前缀的搜索键都不会被接受，0xff不出现在UTF-8中，因此大于其后的任何字节
+				i = sort.SearchStrings(terms, exact+suffix[:offset]+"\xff")
+				skipped = true
+				break
+			}
+		}
+		if skipped {
+			continue
+		}
+		if distance := automaton.distance(rows[len(rows)-1]); distance >= 0 {
+			matches[terms[i]] = distance
+		}
+		i++
+	}
+	return matches
+}
@@ -0,0 +1,74 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/pickjunk/wuneng/types"
+	"github.com/pickjunk/wuneng/utils"
+)
+
+func TestLevenshteinAutomaton(t *testing.T) {
+	automaton := levenshteinAutomaton{target: []rune("baidu"), maxEdits: 1}
+	distance := func(text string) int {
+		row := automaton.start()
+		for _, r := range text {
+			row = automaton.step(row, r)
+		}
+		return automaton.distance(row)
+	}
+	utils.Expect(t, "0", distance("baidu"))
+	utils.Expect(t, "1", distance("baidou"))
+	utils.Expect(t, "1", distance("badu"))
+	utils.Expect(t, "1", distance("baidi"))
+	utils.Expect(t, "-1", distance("bidou"))
+	utils.Expect(t, "-1", distance("google"))
+
+	terms := []string{"baidi", "baidou", "baidu", "bbbbbb", "bidou", "c", "百度"}
+	utils.Expect(t, "map[baidi:1 baidou:1 baidu:0]", automaton.match(terms, ""))
+	suffix := levenshteinAutomaton{target: []rune("idu"), maxEdits: 1}
+	utils.Expect(t, "map[baidi:1 baidou:1 baidu:0]", suffix.match(terms, "ba"))
+
+	chinese := levenshteinAutomaton{target: []rune("百度一下"), maxEdits: 1}
+	utils.Expect(t, "map[百度:-1 百度一下:0 百度下:1]", func() map[string]int {
+		matches := chinese.match([]string{"百度下", "百度一下", "谷歌一下"}, "")
+		matches["百度"] = -1
+		return matches
+	}())
+}
+
+func TestFuzzyTerms(t *testing.T) {
+	var indexer Indexer
+	indexer.Init(types.IndexerInitOptions{IndexType: types.DocIDsIndex})
+	indexer.AddDocumentToCache(&types.DocumentIndex{
+		DocID:    1,
+		Keywords: []types.KeywordIndex{{Text: "baidu"}, {Text: types.FieldKeyword("title", "baidu")}},
+	}, false)
+	indexer.AddDocumentToCache(&types.DocumentIndex{
+		DocID:    2,
+		Keywords: []types.KeywordIndex{{Text: "baidu"}, {Text: "badu"}},
+	}, true)
+	indexer.AddDocumentToCache(&types.DocumentIndex{
+		DocID:    3,
+		Keywords: []types.KeywordIndex{{Text: "baidou"}, {Text: "bidu"}, {Text: types.PinyinKeyword("bd")}},
+	}, true)
+
+	fuzzyTerms := func(prefix string, token string, edits int, prefixLength int, n int) []types.FuzzyTerm {
+		terms, _ := indexer.FuzzyTerms(prefix, token, edits, prefixLength, n)
+		return terms
+	}
+	utils.Expect(t, "[{baidu 1 2} {baidou 1 1} {badu 2 1} {bidu 2 1}]",
+		fuzzyTerms("", "baido", 2, 0, 10))
+	utils.Expect(t, "[{baidu 1 2} {baidou 1 1}]", fuzzyTerms("", "baido", 2, 0, 2))
+	utils.Expect(t, "[{baidu 1 2} {baidou 1 1} {badu 2 1}]", fuzzyTerms("", "baido", 2, 2, 10))
+	utils.Expect(t, "[{badu 1 1} {baidou 1 1} {bidu 1 1}]", fuzzyTerms("", "baidu", 1, 0, 10))
+	utils.Expect(t, "[{field#title:baidu 1 1}]", fuzzyTerms(types.FieldKeyword("title", ""), "baidou", 1, 0, 10))
+	utils.Expect(t, "[]", fuzzyTerms("", "baido", 0, 0, 10))
+
+	// 不限定字段时不匹配拼音和字段的搜索键
+	utils.Expect(t, "[]", fuzzyTerms("", "pinyinbd", 1, 0, 10))
+	utils.Expect(t, "[{pinyin#bd 1 1}]", fuzzyTerms("", types.PinyinKeyword("b"), 1, 0, 10))
+
+	// 已删除的文档不计入
+	indexer.RemoveDocumentToCache(3, true)
+	utils.Expect(t, "[{badu 1 1}]", fuzzyTerms("", "baidu", 1, 0, 10))
+}
@@ -1,6 +1,7 @@
 package core
 
 import (
+	"context"
 	"math"
 	"sort"
 	"sync"
@@ -9,14 +10,20 @@ import (
 	"github.com/pickjunk/wuneng/utils"
 )
 
+// 查找和排序时每处理这么多文档检查一次ctx是否已取消
+const contextCheckInterval = 1024
+
 // Indexer 索引器
 type Indexer struct {
 	// 从搜索键到文档列表的反向索引
 	// 加了读写锁以保证读写安全
 	tableLock struct {
 		sync.RWMutex
-		table     map[string]*KeywordIndices
+		segments  []*segment     // 索引段，从旧到新排列，见segment
 		docsState map[uint64]int // nil: 表示无状态记录，0: 存在于索引中，1: 等待删除，2: 等待加入
+
+		// 数值属性，属性名 -> DocID -> 属性值
+		attributes map[string]map[uint64]float64
 	}
 	addCacheLock struct {
 		sync.RWMutex
@@ -32,6 +39,10 @@ type Indexer struct {
 	initOptions types.IndexerInitOptions
 	initialized bool
 
+	// 同一时间只进行一次段合并，merging标明是否有后台合并
+	mergeLock sync.Mutex
+	merging   int32
+
 	// 这实际上是总文档数的一个近似
 	numDocuments uint64
 
@@ -40,46 +51,168 @@ type Indexer struct {
 
 	// 每个文档的关键词长度
 	docTokenLengths map[uint64]float32
+
+	// 每个文档中全部关键词的起始位置，升序且不重复，仅LocationsIndex，用于按关键词个数计算短语约束
+	docTokenStarts map[uint64][]int
+
+	// 各具名文本字段的总关键词数，以及每个文档中各字段的关键词长度，用于计算BM25F
+	fieldTokenLengths map[string]float32
+	docFieldLengths   map[uint64]map[string]float32
 }
 
 // KeywordIndices 反向索引表的一行，收集了一个搜索键出现的所有文档，按照DocID从小到大排序。
 type KeywordIndices struct {
 	// 下面的切片是否为空，取决于初始化时IndexType的值
+	// 压缩倒排表时索引项保存在packed中，下面的切片为空
 	docIDs      []uint64  // 全部类型都有
 	frequencies []float32 // IndexType == FrequenciesIndex
 	locations   [][]int   // IndexType == LocationsIndex
+	ends        [][]int   // IndexType == LocationsIndex，各位置的结束位置，见types.KeywordIndex.Ends，全部为空时为nil
+	packed      *packedPostings
+
+	// 各文档中的最大词频和最短文档关键词长度，用于估计BM25上界
+	// 删除文档时不更新，因此总是上界
+	maxFrequency float32
+	minDocLength float32
 }
 
-// Init 初始化索引器
+// Init 初始化索引器，重复初始化时panic
 func (indexer *Indexer) Init(options types.IndexerInitOptions) {
+	if err := indexer.TryInit(options); err != nil {
+		log.Panic().Err(err).Msg("索引器不能初始化两次")
+	}
+}
+
+// TryInit 初始化索引器，重复初始化时返回ErrAlreadyInitialized
+func (indexer *Indexer) TryInit(options types.IndexerInitOptions) error {
 	if indexer.initialized == true {
-		log.Panic().Msg("索引器不能初始化两次")
+		return ErrAlreadyInitialized
 	}
 	options.Init()
 	indexer.initOptions = options
 	indexer.initialized = true
 
-	indexer.tableLock.table = make(map[string]*KeywordIndices)
 	indexer.tableLock.docsState = make(map[uint64]int)
+	indexer.tableLock.attributes = make(map[string]map[uint64]float64)
 	indexer.addCacheLock.addCache = make([]*types.DocumentIndex, indexer.initOptions.DocCacheSize)
 	indexer.removeCacheLock.removeCache = make([]uint64, indexer.initOptions.DocCacheSize*2)
 	indexer.docTokenLengths = make(map[uint64]float32)
+	indexer.docTokenStarts = make(map[uint64][]int)
+	indexer.fieldTokenLengths = make(map[string]float32)
+	indexer.docFieldLengths = make(map[uint64]map[string]float32)
+	return nil
 }
 
 // 从KeywordIndices中得到第i个文档的DocID
-func (indexer *Indexer) getDocID(ti *KeywordIndices, i int) uint64 {
+func (indexer *Indexer) getDocID(ti *postingCursor, i int) uint64 {
+	if ti.packed != nil {
+		return ti.packedBlock(i / postingBlockSize).docIDs[i%postingBlockSize]
+	}
 	return ti.docIDs[i]
 }
 
 // 得到KeywordIndices中文档总数
 func (indexer *Indexer) getIndexLength(ti *KeywordIndices) int {
+	if ti.packed != nil {
+		return ti.packed.length
+	}
 	return len(ti.docIDs)
 }
 
-// AddDocumentToCache 向 ADDCACHE 中加入一个文档
-func (indexer *Indexer) AddDocumentToCache(document *types.DocumentIndex, forceUpdate bool) {
+// 从KeywordIndices中得到第i个文档的词频，IndexType == FrequenciesIndex
+func (indexer *Indexer) getFrequency(ti *postingCursor, i int) float32 {
+	if ti.packed != nil {
+		return ti.packedBlock(i / postingBlockSize).frequencies[i%postingBlockSize]
+	}
+	return ti.frequencies[i]
+}
+
+// 从KeywordIndices中得到第i个文档中搜索键的位置，IndexType == LocationsIndex
+func (indexer *Indexer) getLocations(ti *postingCursor, i int) []int {
+	if ti.packed != nil {
+		return ti.packedBlock(i / postingBlockSize).locations[i%postingBlockSize]
+	}
+	return ti.locations[i]
+}
+
+// 从KeywordIndices中得到第i个文档中搜索键的结束位置，为空时结束位置为起始位置加搜索键长度
+func (indexer *Indexer) getEnds(ti *postingCursor, i int) []int {
+	if ti.packed != nil {
+		return ti.packedBlock(i / postingBlockSize).ends[i%postingBlockSize]
+	}
+	if ti.ends == nil {
+		return nil
+	}
+	return ti.ends[i]
+}
+
+// 得到KeywordIndices中全部文档的DocID，调用者不可修改
+// 压缩时逐块只解码DocID，只需其中部分文档时应使用matchPostings
+func (indexer *Indexer) getDocIDs(ti *KeywordIndices) []uint64 {
+	if ti.packed != nil {
+		return ti.packed.docIDs()
+	}
+	return ti.docIDs
+}
+
+// 返回升序的docIDs中包含（contained为true）或不包含（contained为false）在倒排表中的文档
+// 压缩的倒排表通过跳表查找，只解码docIDs所在的块
+func (indexer *Indexer) matchPostings(docIDs []uint64, ti *KeywordIndices, contained bool) []uint64 {
+	if ti == nil {
+		if contained {
+			return nil
+		}
+		return docIDs
+	}
+	if ti.packed == nil {
+		if contained {
+			return intersectDocIDs(docIDs, ti.docIDs)
+		}
+		return differenceDocIDs(docIDs, ti.docIDs)
+	}
+
+	cursor := newDocIDCursor(ti)
+	result := make([]uint64, 0, len(docIDs))
+	position, last := 0, ti.packed.length-1
+	for i, docID := range docIDs {
+		if position > last {
+			// 其余文档都比倒排表中的大
+			if !contained {
+				result = append(result, docIDs[i:]...)
+			}
+			break
+		}
+		var found bool
+		position, found = cursor.searchPacked(position, last, docID)
+		if found == contained {
+			result = append(result, docID)
+		}
+	}
+	return result
+}
+
+// 建立或合并段后整理索引项：没有任何结束位置时去掉ends，开启CompressPostings时压缩
+func (indexer *Indexer) packIndices(ti *KeywordIndices) {
+	hasEnds := false
+	for _, ends := range ti.ends {
+		if len(ends) > 0 {
+			hasEnds = true
+			break
+		}
+	}
+	if !hasEnds {
+		ti.ends = nil
+	}
+	if indexer.initOptions.CompressPostings && len(ti.docIDs) > 0 {
+		ti.packed = packPostings(indexer.initOptions.IndexType, ti.docIDs, ti.frequencies, ti.locations, ti.ends)
+		ti.docIDs, ti.frequencies, ti.locations, ti.ends = nil, nil, nil, nil
+	}
+}
+
+// AddDocumentToCache 向 ADDCACHE 中加入一个文档，索引器未初始化时返回ErrNotInitialized
+func (indexer *Indexer) AddDocumentToCache(document *types.DocumentIndex, forceUpdate bool) error {
 	if indexer.initialized == false {
-		log.Panic().Msg("索引器尚未初始化")
+		return ErrNotInitialized
 	}
 
 	indexer.addCacheLock.Lock()
@@ -115,7 +248,7 @@ func (indexer *Indexer) AddDocumentToCache(document *types.DocumentIndex, forceU
 		}
 
 		indexer.tableLock.Unlock()
-		if indexer.RemoveDocumentToCache(0, forceUpdate) {
+		if removed, _ := indexer.RemoveDocumentToCache(0, forceUpdate); removed {
 			// 只有当存在于索引表中的文档已被删除，其才可以重新加入到索引表中
 			position = 0
 		}
@@ -124,31 +257,39 @@ func (indexer *Indexer) AddDocumentToCache(document *types.DocumentIndex, forceU
 		indexer.addCacheLock.addCachePointer = position
 		indexer.addCacheLock.Unlock()
 		sort.Sort(addCachedDocuments)
-		indexer.AddDocuments(&addCachedDocuments)
-	} else {
-		indexer.addCacheLock.Unlock()
+		return indexer.AddDocuments(&addCachedDocuments)
 	}
+	indexer.addCacheLock.Unlock()
+	return nil
 }
 
-// AddDocuments 向反向索引表中加入 ADDCACHE 中所有文档
-func (indexer *Indexer) AddDocuments(documents *types.DocumentsIndex) {
+// AddDocuments 用 ADDCACHE 中所有文档建立一个新的索引段
+// 新段在锁外建立，只在加入段列表时短暂持有写锁，不阻塞查找。索引器未初始化时返回ErrNotInitialized
+func (indexer *Indexer) AddDocuments(documents *types.DocumentsIndex) error {
 	if indexer.initialized == false {
-		log.Panic().Msg("索引器尚未初始化")
+		return ErrNotInitialized
 	}
 
-	indexer.tableLock.Lock()
-	defer indexer.tableLock.Unlock()
-	indexPointers := make(map[string]int, len(indexer.tableLock.table))
-
-	// DocID 递增顺序遍历插入文档保证索引移动次数最少
+	batch := make([]*types.DocumentIndex, 0, len(*documents))
 	for i, document := range *documents {
 		if i < len(*documents)-1 && (*documents)[i].DocID == (*documents)[i+1].DocID {
 			// 如果有重复文档加入，因为稳定排序，只加入最后一个
 			continue
 		}
+		batch = append(batch, document)
+	}
+	if len(batch) == 0 {
+		return nil
+	}
+	seg := indexer.buildSegment(batch)
+
+	indexer.tableLock.Lock()
+	defer indexer.tableLock.Unlock()
+	for _, document := range batch {
 		if docState, ok := indexer.tableLock.docsState[document.DocID]; ok && docState == 1 {
 			// 如果此时 docState 仍为 1，说明该文档需被删除
 			// docState 合法状态为 nil & 2，保证一定不会插入已经在索引表中的文档
+			seg.tombstone(document.DocID)
 			continue
 		}
 
@@ -157,56 +298,40 @@ func (indexer *Indexer) AddDocuments(documents *types.DocumentsIndex) {
 			indexer.docTokenLengths[document.DocID] = float32(document.TokenLength)
 			indexer.totalTokenLength += document.TokenLength
 		}
-
-		docIDIsNew := true
-		for _, keyword := range document.Keywords {
-			indices, foundKeyword := indexer.tableLock.table[keyword.Text]
-			if !foundKeyword {
-				// 如果没找到该搜索键则加入
-				ti := KeywordIndices{}
-				switch indexer.initOptions.IndexType {
-				case types.LocationsIndex:
-					ti.locations = [][]int{keyword.Starts}
-				case types.FrequenciesIndex:
-					ti.frequencies = []float32{keyword.Frequency}
-				}
-				ti.docIDs = []uint64{document.DocID}
-				indexer.tableLock.table[keyword.Text] = &ti
-				continue
+		if indexer.initOptions.IndexType == types.LocationsIndex {
+			indexer.docTokenStarts[document.DocID] = tokenStarts(document.Keywords)
+		}
+		if len(document.FieldLengths) > 0 {
+			indexer.docFieldLengths[document.DocID] = document.FieldLengths
+			for field, length := range document.FieldLengths {
+				indexer.fieldTokenLengths[field] += length
 			}
+		}
 
-			// 查找应该插入的位置，且索引一定不存在
-			position, _ := indexer.searchIndex(
-				indices, indexPointers[keyword.Text], indexer.getIndexLength(indices)-1, document.DocID)
-			indexPointers[keyword.Text] = position
-			switch indexer.initOptions.IndexType {
-			case types.LocationsIndex:
-				indices.locations = append(indices.locations, []int{})
-				copy(indices.locations[position+1:], indices.locations[position:])
-				indices.locations[position] = keyword.Starts
-			case types.FrequenciesIndex:
-				indices.frequencies = append(indices.frequencies, float32(0))
-				copy(indices.frequencies[position+1:], indices.frequencies[position:])
-				indices.frequencies[position] = keyword.Frequency
+		// 更新数值属性
+		for name, value := range document.Attributes {
+			column, found := indexer.tableLock.attributes[name]
+			if !found {
+				column = make(map[uint64]float64)
+				indexer.tableLock.attributes[name] = column
 			}
-			indices.docIDs = append(indices.docIDs, 0)
-			copy(indices.docIDs[position+1:], indices.docIDs[position:])
-			indices.docIDs[position] = document.DocID
+			column[document.DocID] = value
 		}
 
 		// 更新文章状态和总数
-		if docIDIsNew {
-			indexer.tableLock.docsState[document.DocID] = 0
-			indexer.numDocuments++
-		}
+		indexer.tableLock.docsState[document.DocID] = 0
+		indexer.numDocuments++
 	}
+	indexer.tableLock.segments = append(indexer.tableLock.segments, seg)
+	indexer.scheduleMerge()
+	return nil
 }
 
 // RemoveDocumentToCache 向 REMOVECACHE 中加入一个待删除文档
-// 返回值表示文档是否在索引表中被删除
-func (indexer *Indexer) RemoveDocumentToCache(docID uint64, forceUpdate bool) bool {
+// 返回值表示文档是否在索引表中被删除，索引器未初始化时返回ErrNotInitialized
+func (indexer *Indexer) RemoveDocumentToCache(docID uint64, forceUpdate bool) (bool, error) {
 	if indexer.initialized == false {
-		log.Panic().Msg("索引器尚未初始化")
+		return false, ErrNotInitialized
 	}
 
 	indexer.removeCacheLock.Lock()
@@ -233,17 +358,16 @@ func (indexer *Indexer) RemoveDocumentToCache(docID uint64, forceUpdate bool) bo
 		indexer.removeCacheLock.removeCachePointer = 0
 		indexer.removeCacheLock.Unlock()
 		sort.Sort(removeCachedDocuments)
-		indexer.RemoveDocuments(&removeCachedDocuments)
-		return true
+		return true, indexer.RemoveDocuments(&removeCachedDocuments)
 	}
 	indexer.removeCacheLock.Unlock()
-	return false
+	return false, nil
 }
 
-// RemoveDocuments 向反向索引表中删除 REMOVECACHE 中所有文档
-func (indexer *Indexer) RemoveDocuments(documents *types.DocumentsID) {
+// RemoveDocuments 从反向索引表中删除 REMOVECACHE 中所有文档，索引器未初始化时返回ErrNotInitialized
+func (indexer *Indexer) RemoveDocuments(documents *types.DocumentsID) error {
 	if indexer.initialized == false {
-		log.Panic().Msg("索引器尚未初始化")
+		return ErrNotInitialized
 	}
 
 	indexer.tableLock.Lock()
@@ -253,60 +377,49 @@ func (indexer *Indexer) RemoveDocuments(documents *types.DocumentsID) {
 	for _, docID := range *documents {
 		indexer.totalTokenLength -= indexer.docTokenLengths[docID]
 		delete(indexer.docTokenLengths, docID)
+		delete(indexer.docTokenStarts, docID)
+		for field, length := range indexer.docFieldLengths[docID] {
+			indexer.fieldTokenLengths[field] -= length
+		}
+		delete(indexer.docFieldLengths, docID)
 		delete(indexer.tableLock.docsState, docID)
+		for _, column := range indexer.tableLock.attributes {
+			delete(column, docID)
+		}
 	}
 
-	for keyword, indices := range indexer.tableLock.table {
-		indicesTop, indicesPointer := 0, 0
-		documentsPointer := sort.Search(
-			len(*documents), func(i int) bool { return (*documents)[i] >= indices.docIDs[0] })
-		// 双指针扫描，进行批量删除操作
-		for documentsPointer < len(*documents) && indicesPointer < indexer.getIndexLength(indices) {
-			if indices.docIDs[indicesPointer] < (*documents)[documentsPointer] {
-				if indicesTop != indicesPointer {
-					switch indexer.initOptions.IndexType {
-					case types.LocationsIndex:
-						indices.locations[indicesTop] = indices.locations[indicesPointer]
-					case types.FrequenciesIndex:
-						indices.frequencies[indicesTop] = indices.frequencies[indicesPointer]
-					}
-					indices.docIDs[indicesTop] = indices.docIDs[indicesPointer]
-				}
-				indicesTop++
-				indicesPointer++
-			} else if indices.docIDs[indicesPointer] == (*documents)[documentsPointer] {
-				indicesPointer++
-				documentsPointer++
-			} else {
-				documentsPointer++
-			}
-		}
-		if indicesTop != indicesPointer {
-			switch indexer.initOptions.IndexType {
-			case types.LocationsIndex:
-				indices.locations = append(
-					indices.locations[:indicesTop], indices.locations[indicesPointer:]...)
-			case types.FrequenciesIndex:
-				indices.frequencies = append(
-					indices.frequencies[:indicesTop], indices.frequencies[indicesPointer:]...)
-			}
-			indices.docIDs = append(
-				indices.docIDs[:indicesTop], indices.docIDs[indicesPointer:]...)
-		}
-		if len(indices.docIDs) == 0 {
-			delete(indexer.tableLock.table, keyword)
+	// 只在各段上记录墓碑，索引项在合并段时去掉
+	for _, seg := range indexer.tableLock.segments {
+		for _, docID := range *documents {
+			seg.tombstone(docID)
 		}
 	}
+	indexer.scheduleMerge()
+	return nil
 }
 
 // Lookup 查找包含全部搜索键(AND操作)的文档
 // 当docIDs不为nil时仅从docIDs指定的文档中查找
 func (indexer *Indexer) Lookup(
 	tokens []string, labels []string, docIDs map[uint64]bool, countDocsOnly bool) (docs []types.IndexedDocument, numDocs int) {
+	docs, numDocs, _ = indexer.LookupContext(context.Background(), tokens, labels,
+		types.LookupOptions{DocIDs: docIDs, CountDocsOnly: countDocsOnly})
+	return
+}
+
+// LookupContext 与Lookup相同，但按options查找，且ctx取消时提前结束，返回已找到的部分文档和ctx.Err()，
+// 索引器未初始化时返回ErrNotInitialized
+func (indexer *Indexer) LookupContext(ctx context.Context,
+	tokens []string, labels []string, options types.LookupOptions) (
+	docs []types.IndexedDocument, numDocs int, err error) {
 	if indexer.initialized == false {
-		log.Panic().Msg("索引器尚未初始化")
+		err = ErrNotInitialized
+		return
 	}
 
+	// numDocuments和totalTokenLength在tableLock写锁下更新
+	indexer.tableLock.RLock()
+	defer indexer.tableLock.RUnlock()
 	if indexer.numDocuments == 0 {
 		return
 	}
@@ -317,37 +430,78 @@ func (indexer *Indexer) Lookup(
 	copy(keywords, tokens)
 	copy(keywords[len(tokens):], labels)
 
-	indexer.tableLock.RLock()
-	defer indexer.tableLock.RUnlock()
-	table := make([]*KeywordIndices, len(keywords))
+	// 当没有搜索键或反向索引表中无某个搜索键时直接返回
+	if len(keywords) == 0 {
+		return
+	}
+	dfs := make([]int, len(keywords))
 	for i, keyword := range keywords {
-		indices, found := indexer.tableLock.table[keyword]
-		if !found {
-			// 当反向索引表中无此搜索键时直接返回
+		if dfs[i] = indexer.documentFrequency(keyword); dfs[i] == 0 {
 			return
 		}
-		// 否则加入反向表中
-		table[i] = indices
 	}
 
-	// 当没有找到时直接返回
-	if len(table) == 0 {
-		return
+	// 平均文本关键词长度，用于计算BM25
+	avgDocLength := indexer.totalTokenLength / float32(indexer.numDocuments)
+	pruner := indexer.newPruner(options)
+
+	// 逐段查找，同一文档只在一个段中有效，各段的结果按DocID从大到小归并
+	var segmentDocs [][]types.IndexedDocument
+	for _, seg := range indexer.tableLock.segments {
+		found, n, segmentErr := indexer.lookupSegment(ctx, seg, tokens, keywords, dfs, &options,
+			pruner, avgDocLength)
+		segmentDocs = append(segmentDocs, found)
+		numDocs += n
+		if err = segmentErr; err != nil {
+			break
+		}
+	}
+	docs = mergeIndexedDocuments(segmentDocs)
+	return
+}
+
+// 在一个段中查找包含全部搜索键的文档，按DocID从大到小输出
+func (indexer *Indexer) lookupSegment(ctx context.Context, seg *segment,
+	tokens []string, keywords []string, dfs []int, options *types.LookupOptions,
+	pruner *bm25Pruner, avgDocLength float32) (
+	docs []types.IndexedDocument, numDocs int, err error) {
+	table := make([]*postingCursor, len(keywords))
+	for i, keyword := range keywords {
+		indices, found := seg.table[keyword]
+		if !found {
+			// 当本段中无此搜索键时直接返回
+			return
+		}
+		table[i] = newPostingCursor(indices)
 	}
 
 	// 归并查找各个搜索键出现文档的交集
 	// 从后向前查保证先输出DocID较大文档
 	indexPointers := make([]int, len(table))
 	for iTable := 0; iTable < len(table); iTable++ {
-		indexPointers[iTable] = indexer.getIndexLength(table[iTable]) - 1
+		indexPointers[iTable] = indexer.getIndexLength(table[iTable].KeywordIndices) - 1
 	}
-	// 平均文本关键词长度，用于计算BM25
-	avgDocLength := indexer.totalTokenLength / float32(indexer.numDocuments)
-	for ; indexPointers[0] >= 0; indexPointers[0]-- {
+	scoreBM25 := indexer.initOptions.IndexType == types.LocationsIndex ||
+		indexer.initOptions.IndexType == types.FrequenciesIndex
+	if pruner != nil {
+		bounds := make([]float32, len(tokens))
+		for i, t := range table[:len(tokens)] {
+			bounds[i] = indexer.keywordBM25Bound(t.KeywordIndices, dfs[i], avgDocLength)
+		}
+		pruner.setBounds(bounds)
+	}
+	for iteration := 1; indexPointers[0] >= 0; indexPointers[0]-- {
+		if iteration%contextCheckInterval == 0 {
+			if err = ctx.Err(); err != nil {
+				return
+			}
+		}
+		iteration++
+
 		// 以第一个搜索键出现的文档作为基准，并遍历其他搜索键搜索同一文档
 		baseDocID := indexer.getDocID(table[0], indexPointers[0])
-		if docIDs != nil {
-			if _, found := docIDs[baseDocID]; !found {
+		if options.DocIDs != nil {
+			if _, found := options.DocIDs[baseDocID]; !found {
 				continue
 			}
 		}
@@ -376,85 +530,213 @@ func (indexer *Indexer) Lookup(
 		}
 
 		if found {
-			if docState, ok := indexer.tableLock.docsState[baseDocID]; !ok || docState != 0 {
+			if docState, ok := indexer.tableLock.docsState[baseDocID]; !ok || docState != 0 || seg.deleted[baseDocID] {
+				continue
+			}
+			if !indexer.matchFilters(baseDocID, options.Filters) {
 				continue
 			}
 			indexedDoc := types.IndexedDocument{}
 
+			// 当为LocationsIndex或者FrequenciesIndex时计算BM25
+			// 剪枝时在紧邻距离之前计算，以便跳过不可能进入前topK的文档
+			if scoreBM25 {
+				bm25 := float32(0)
+				pruned := false
+				d := indexer.docTokenLengths[baseDocID]
+				for i, t := range table[:len(tokens)] {
+					if pruner != nil && pruner.prunable(bm25, i) {
+						pruned = true
+						break
+					}
+					var frequency float32
+					if indexer.initOptions.IndexType == types.LocationsIndex {
+						frequency = float32(len(indexer.getLocations(t, indexPointers[i])))
+					} else {
+						frequency = indexer.getFrequency(t, indexPointers[i])
+					}
+
+					bm25 += indexer.keywordBM25(dfs[i], frequency, d, avgDocLength)
+				}
+				if pruned || (pruner != nil && pruner.prunable(bm25, len(tokens))) {
+					if pruner.counts(baseDocID) {
+						numDocs++
+					}
+					continue
+				}
+				indexedDoc.BM25 = float32(bm25)
+				indexedDoc.BM25F = indexer.documentBM25F(seg, baseDocID, tokens, dfs, nil)
+			}
+
 			// 当为LocationsIndex时计算关键词紧邻距离
 			if indexer.initOptions.IndexType == types.LocationsIndex {
 				// 计算有多少关键词是带有距离信息的
 				numTokensWithLocations := 0
 				for i, t := range table[:len(tokens)] {
-					if len(t.locations[indexPointers[i]]) > 0 {
+					if len(indexer.getLocations(t, indexPointers[i])) > 0 {
 						numTokensWithLocations++
 					}
 				}
 				if numTokensWithLocations != len(tokens) {
-					if !countDocsOnly {
+					// 这类文档不计BM25
+					if pruner != nil {
+						if pruner.prunable(0, len(tokens)) {
+							if pruner.counts(baseDocID) {
+								numDocs++
+							}
+							continue
+						}
+						pruner.add(baseDocID, 0)
+					}
+					if !options.CountDocsOnly {
 						docs = append(docs, types.IndexedDocument{
 							DocID: baseDocID,
 						})
 					}
-					numDocs++
+					if pruner.counts(baseDocID) {
+						numDocs++
+					}
 					//当某个关键字对应多个文档且有lable关键字存在时，若直接break,将会丢失相当一部分搜索结果
 					continue
 				}
 
-				// 计算搜索键在文档中的紧邻距离
-				tokenProximity, tokenLocations := computeTokenProximity(table[:len(tokens)], indexPointers, tokens)
-				indexedDoc.TokenProximity = int32(tokenProximity)
-				indexedDoc.TokenSnippetLocations = tokenLocations
-
-				// 添加TokenLocations
+				// 添加TokenLocations和TokenEnds
 				indexedDoc.TokenLocations = make([][]int, len(tokens))
+				indexedDoc.TokenEnds = make([][]int, len(tokens))
 				for i, t := range table[:len(tokens)] {
-					indexedDoc.TokenLocations[i] = t.locations[indexPointers[i]]
+					indexedDoc.TokenLocations[i] = indexer.getLocations(t, indexPointers[i])
+					indexedDoc.TokenEnds[i] = indexer.getEnds(t, indexPointers[i])
 				}
-			}
 
-			// 当为LocationsIndex或者FrequenciesIndex时计算BM25
-			if indexer.initOptions.IndexType == types.LocationsIndex ||
-				indexer.initOptions.IndexType == types.FrequenciesIndex {
-				bm25 := float32(0)
-				d := indexer.docTokenLengths[baseDocID]
-				for i, t := range table[:len(tokens)] {
-					var frequency float32
-					if indexer.initOptions.IndexType == types.LocationsIndex {
-						frequency = float32(len(t.locations[indexPointers[i]]))
-					} else {
-						frequency = t.frequencies[indexPointers[i]]
-					}
-
-					// 计算BM25
-					if len(t.docIDs) > 0 && frequency > 0 && indexer.initOptions.BM25Parameters != nil && avgDocLength != 0 {
-						// 带平滑的idf
-						idf := float32(math.Log2(float64(indexer.numDocuments)/float64(len(t.docIDs)) + 1))
-						k1 := indexer.initOptions.BM25Parameters.K1
-						b := indexer.initOptions.BM25Parameters.B
-						bm25 += idf * frequency * (k1 + 1) / (frequency + k1*(1-b+b*d/avgDocLength))
-					}
-				}
-				indexedDoc.BM25 = float32(bm25)
+				// 计算搜索键在文档中的紧邻距离
+				tokenProximity, tokenLocations := computeTokenProximity(
+					indexedDoc.TokenLocations, indexedDoc.TokenEnds, tokens)
+				indexedDoc.TokenProximity = int32(tokenProximity)
+				indexedDoc.TokenSnippetLocations = tokenLocations
 			}
 
+			if pruner != nil {
+				pruner.add(baseDocID, indexedDoc.BM25)
+			}
 			indexedDoc.DocID = baseDocID
-			if !countDocsOnly {
+			if !options.CountDocsOnly {
 				docs = append(docs, indexedDoc)
 			}
-			numDocs++
+			if pruner.counts(baseDocID) {
+				numDocs++
+			}
+		}
+	}
+	return
+}
+
+// 文档中全部关键词的起始位置，升序且不重复
+// 限定字段和拼音的搜索键与原关键词位置相同，不会重复计数
+func tokenStarts(keywords []types.KeywordIndex) []int {
+	var starts []int
+	for _, keyword := range keywords {
+		starts = append(starts, keyword.Starts...)
+	}
+	sort.Ints(starts)
+	unique := starts[:0]
+	for i, start := range starts {
+		if i == 0 || start != starts[i-1] {
+			unique = append(unique, start)
+		}
+	}
+	return unique
+}
+
+// 归并各段按DocID从大到小排列的查找结果
+func mergeIndexedDocuments(lists [][]types.IndexedDocument) (docs []types.IndexedDocument) {
+	for _, list := range lists {
+		if len(docs) == 0 {
+			docs = list
+			continue
+		}
+		if len(list) == 0 {
+			continue
+		}
+		merged := make([]types.IndexedDocument, 0, len(docs)+len(list))
+		i, j := 0, 0
+		for i < len(docs) && j < len(list) {
+			if docs[i].DocID > list[j].DocID {
+				merged = append(merged, docs[i])
+				i++
+			} else {
+				merged = append(merged, list[j])
+				j++
+			}
 		}
+		merged = append(merged, docs[i:]...)
+		docs = append(merged, list[j:]...)
 	}
 	return
 }
 
+// 索引项的词频，与Lookup计算BM25时一致
+func (indexer *Indexer) keywordFrequency(keyword types.KeywordIndex) float32 {
+	if indexer.initOptions.IndexType == types.LocationsIndex {
+		return float32(len(keyword.Starts))
+	}
+	return keyword.Frequency
+}
+
+// 加入一个文档时更新BM25上界
+func (indices *KeywordIndices) updateBounds(frequency float32, docLength float32) {
+	if frequency > indices.maxFrequency {
+		indices.maxFrequency = frequency
+	}
+	if docLength < indices.minDocLength {
+		indices.minDocLength = docLength
+	}
+}
+
+// 一个搜索键对任意文档BM25贡献的上界，BM25随词频递增、随文档长度递减
+func (indexer *Indexer) keywordBM25Bound(indices *KeywordIndices, df int, avgDocLength float32) float32 {
+	if indices == nil {
+		return 0
+	}
+	return indexer.keywordBM25(df, indices.maxFrequency, indices.minDocLength, avgDocLength)
+}
+
+// 计算一个搜索键对文档BM25的贡献，df为包含该搜索键的文档数，d为文档关键词长度
+func (indexer *Indexer) keywordBM25(df int, frequency float32, d float32, avgDocLength float32) float32 {
+	if df == 0 || frequency <= 0 || indexer.initOptions.BM25Parameters == nil || avgDocLength == 0 {
+		return 0
+	}
+	k1 := indexer.initOptions.BM25Parameters.K1
+	b := indexer.initOptions.BM25Parameters.B
+	return indexer.idf(df) * frequency * (k1 + 1) / (frequency + k1*(1-b+b*d/avgDocLength))
+}
+
+// 带平滑的idf，BM25和BM25F共用
+func (indexer *Indexer) idf(df int) float32 {
+	return float32(math.Log2(float64(indexer.numDocuments)/float64(df) + 1))
+}
+
+// 判断文档是否满足全部范围过滤条件，调用者须持有tableLock读锁
+func (indexer *Indexer) matchFilters(docID uint64, filters []types.RangeFilter) bool {
+	for _, filter := range filters {
+		value, found := indexer.tableLock.attributes[filter.Attribute][docID]
+		if !found || !filter.Match(value) {
+			return false
+		}
+	}
+	return true
+}
+
 // 二分法查找indices中某文档的索引项
 // 第一个返回参数为找到的位置或需要插入的位置
 // 第二个返回参数标明是否找到
 func (indexer *Indexer) searchIndex(
-	indices *KeywordIndices, start int, end int, docID uint64) (int, bool) {
+	indices *postingCursor, start int, end int, docID uint64) (int, bool) {
+	if indices.packed != nil {
+		return indices.searchPacked(start, end, docID)
+	}
+
 	// 特殊情况
-	if indexer.getIndexLength(indices) == start {
+	if indexer.getIndexLength(indices.KeywordIndices) == start {
 		return start, false
 	}
 	if docID < indexer.getDocID(indices, start) {
@@ -485,14 +767,14 @@ func (indexer *Indexer) searchIndex(
 
 // 计算搜索键在文本中的紧邻距离
 //
-// 假定第 i 个搜索键首字节出现在文本中的位置为 P_i，长度 L_i
+// 假定第 i 个搜索键首字节出现在文本中的位置为 P_i，结束位置为 E_i（通常为 P_i 加搜索键长度，见tokenEnd）
 // 紧邻距离计算公式为
 //
-// 	ArgMin(Sum(Abs(P_(i+1) - P_i - L_i)))
+// 	ArgMin(Sum(Abs(P_(i+1) - E_i)))
 //
 // 具体由动态规划实现，依次计算前 i 个 token 在每个出现位置的最优值。
-// 选定的 P_i 通过 tokenLocations 参数传回。
-func computeTokenProximity(table []*KeywordIndices, indexPointers []int, tokens []string) (
+// locations[i] 为第 i 个搜索键的全部出现位置，选定的 P_i 通过 tokenLocations 参数传回。
+func computeTokenProximity(locations [][]int, ends [][]int, tokens []string) (
 	minTokenProximity int, tokenLocations []int) {
 	minTokenProximity = -1
 	tokenLocations = make([]int, len(tokens))
@@ -506,14 +788,14 @@ func computeTokenProximity(table []*KeywordIndices, indexPointers []int, tokens
 	// 初始化路径数组
 	path = make([][]int, len(tokens))
 	for i := 1; i < len(path); i++ {
-		path[i] = make([]int, len(table[i].locations[indexPointers[i]]))
+		path[i] = make([]int, len(locations[i]))
 	}
 
 	// 动态规划
-	currentLocations = table[0].locations[indexPointers[0]]
+	currentLocations = locations[0]
 	currentMinValues = make([]int, len(currentLocations))
 	for i := 1; i < len(tokens); i++ {
-		nextLocations = table[i].locations[indexPointers[i]]
+		nextLocations = locations[i]
 		nextMinValues = make([]int, len(nextLocations))
 		for j := range nextMinValues {
 			nextMinValues[j] = -1
@@ -532,7 +814,7 @@ func computeTokenProximity(table []*KeywordIndices, indexPointers []int, tokens
 				if to >= len(nextLocations) {
 					return
 				}
-				value := currentMinValues[from] + utils.AbsInt(nextLocations[to]-currentLocations[from]-len(tokens[i-1]))
+				value := currentMinValues[from] + utils.AbsInt(nextLocations[to]-tokenEnd(locations, ends, tokens, i-1, from))
 				if nextMinValues[to] == -1 || value < nextMinValues[to] {
 					nextMinValues[to] = value
 					path[i][to] = from
@@ -565,7 +847,7 @@ func computeTokenProximity(table []*KeywordIndices, indexPointers []int, tokens
 		if i != len(tokens)-1 {
 			cursor = path[i+1][cursor]
 		}
-		tokenLocations[i] = table[i].locations[indexPointers[i]][cursor]
+		tokenLocations[i] = locations[i][cursor]
 	}
 	return
 }
@@ -1,6 +1,7 @@
 package core
 
 import (
+	"context"
 	"testing"
 
 	"github.com/pickjunk/wuneng/types"
@@ -461,3 +462,113 @@ func TestLookupWithLocations(t *testing.T) {
 	docs, _ := indexer.Lookup([]string{"token2", "token3"}, []string{}, nil, false)
 	utils.Expect(t, "[[0 21] [28]]", docs[0].TokenLocations)
 }
+
+func TestIndexerInitTwice(t *testing.T) {
+	var indexer Indexer
+	utils.Expect(t, "<nil>", indexer.TryInit(types.IndexerInitOptions{}))
+	utils.Expect(t, ErrAlreadyInitialized.Error(), indexer.TryInit(types.IndexerInitOptions{}))
+
+	var ranker Ranker
+	utils.Expect(t, "<nil>", ranker.TryInit())
+	utils.Expect(t, ErrAlreadyInitialized.Error(), ranker.TryInit())
+}
+
+func TestNotInitialized(t *testing.T) {
+	var indexer Indexer
+	utils.Expect(t, ErrNotInitialized.Error(), indexer.AddDocumentToCache(&types.DocumentIndex{DocID: 1}, true))
+	_, err := indexer.RemoveDocumentToCache(1, true)
+	utils.Expect(t, ErrNotInitialized.Error(), err)
+	_, _, err = indexer.LookupContext(context.Background(), []string{"a"}, nil, types.LookupOptions{})
+	utils.Expect(t, ErrNotInitialized.Error(), err)
+	_, err = indexer.Suggest("a", nil, 10)
+	utils.Expect(t, ErrNotInitialized.Error(), err)
+	utils.Expect(t, ErrNotInitialized.Error(), indexer.MergeSegments())
+
+	var ranker Ranker
+	utils.Expect(t, ErrNotInitialized.Error(), ranker.AddDoc(1, nil))
+	_, _, err = ranker.RankContext(context.Background(), nil, types.RankOptions{}, false)
+	utils.Expect(t, ErrNotInitialized.Error(), err)
+}
+
+func TestLookupContext(t *testing.T) {
+	var indexer Indexer
+	indexer.Init(types.IndexerInitOptions{IndexType: types.FrequenciesIndex})
+	numDocs := 3 * contextCheckInterval
+	for docID := 1; docID <= numDocs; docID++ {
+		indexer.AddDocumentToCache(&types.DocumentIndex{
+			DocID:    uint64(docID),
+			Keywords: []types.KeywordIndex{{Text: "token", Frequency: 1}},
+		}, docID == numDocs)
+	}
+
+	allDocs, n, err := indexer.LookupContext(context.Background(), []string{"token"}, nil, types.LookupOptions{})
+	docs := allDocs
+	utils.Expect(t, "<nil>", err)
+	utils.Expect(t, "3072", n)
+	utils.Expect(t, "3072", len(docs))
+
+	// 已取消的ctx只返回第一批检查前找到的文档
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	docs, n, err = indexer.LookupContext(ctx, []string{"token"}, nil, types.LookupOptions{})
+	utils.Expect(t, context.Canceled.Error(), err)
+	utils.Expect(t, "1023", n)
+	utils.Expect(t, "1023", len(docs))
+
+	query := types.Query{Token: "token"}
+	_, n, err = indexer.LookupQueryContext(ctx, &query, types.LookupOptions{CountDocsOnly: true})
+	utils.Expect(t, context.Canceled.Error(), err)
+	utils.Expect(t, "1023", n)
+
+	var ranker Ranker
+	ranker.Init()
+	for _, doc := range allDocs {
+		ranker.AddDoc(doc.DocID, nil)
+	}
+	scoredDocs, n, err := ranker.RankContext(ctx, allDocs, types.RankOptions{ScoringCriteria: types.RankByBM25{}}, false)
+	utils.Expect(t, context.Canceled.Error(), err)
+	utils.Expect(t, "1023", n)
+	utils.Expect(t, "1023", len(scoredDocs))
+}
+
+func TestLookupWithFilters(t *testing.T) {
+	var indexer Indexer
+	indexer.Init(types.IndexerInitOptions{IndexType: types.DocIDsIndex})
+	for docID, price := range []float64{5, 10, 50, 100, 200} {
+		indexer.AddDocumentToCache(&types.DocumentIndex{
+			DocID:      uint64(docID + 1),
+			Keywords:   []types.KeywordIndex{{Text: "token"}},
+			Attributes: map[string]float64{"price": price, "stock": float64(docID % 2)},
+		}, false)
+	}
+	indexer.AddDocumentToCache(&types.DocumentIndex{
+		DocID:    6,
+		Keywords: []types.KeywordIndex{{Text: "token"}},
+	}, true)
+
+	ctx := context.Background()
+	docs, _, _ := indexer.LookupContext(ctx, []string{"token"}, nil,
+		types.LookupOptions{Filters: []types.RangeFilter{types.Between("price", 10, 100)}})
+	utils.Expect(t, "[4 0 []] [3 0 []] [2 0 []] ", indexedDocsToString(docs, 0))
+
+	docs, _, _ = indexer.LookupContext(ctx, []string{"token"}, nil,
+		types.LookupOptions{Filters: []types.RangeFilter{types.GreaterThan("price", 10), types.Equal("stock", 0)}})
+	utils.Expect(t, "[5 0 []] [3 0 []] ", indexedDocsToString(docs, 0))
+
+	query := types.Query{Token: "token"}
+	_, n, _ := indexer.LookupQueryContext(ctx, &query,
+		types.LookupOptions{Filters: []types.RangeFilter{types.LessThan("price", 100)}, CountDocsOnly: true})
+	utils.Expect(t, "3", n)
+
+	// 删除后属性随之删除，重新加入时使用新的属性
+	indexer.RemoveDocumentToCache(3, true)
+	indexer.AddDocumentToCache(&types.DocumentIndex{
+		DocID:      2,
+		Keywords:   []types.KeywordIndex{{Text: "token"}},
+		Attributes: map[string]float64{"price": 1000},
+	}, true)
+	docs, _, _ = indexer.LookupContext(ctx, []string{"token"}, nil,
+		types.LookupOptions{Filters: []types.RangeFilter{types.AtMost("price", 100)}})
+	utils.Expect(t, "[4 0 []] [1 0 []] ", indexedDocsToString(docs, 0))
+	utils.Expect(t, "map[1:5 2:1000 4:100 5:200]", indexer.tableLock.attributes["price"])
+}
@@ -0,0 +1,270 @@
+package core
+
+import (
+	"encoding/binary"
+	"math"
+	"sort"
+
+	"github.com/pickjunk/wuneng/types"
+	"github.com/pickjunk/wuneng/utils"
+)
+
+// 压缩倒排表每块的索引项数
+const postingBlockSize = 128
+
+// 压缩的倒排表，IndexerInitOptions.CompressPostings为true时使用
+//
+// 索引项按DocID分块，每块依次存放各索引项的DocID差值（uvarint），
+// FrequenciesIndex时加上词频，LocationsIndex时加上位置个数和各位置的差值（varint），
+// 以及结束位置个数（没有结束位置时为0）和各结束位置与起始位置的差值（uvarint）。
+// 每块的首个DocID和数据起始位置作为跳表，查找时只需解码一块，见postingCursor
+type packedPostings struct {
+	indexType int
+	length    int
+
+	firstDocIDs []uint64
+	offsets     []uint32
+	data        []byte
+}
+
+// 解码后的一块索引项
+type postingBlock struct {
+	index       int
+	docIDs      []uint64
+	frequencies []float32
+	locations   [][]int
+	ends        [][]int
+}
+
+func packPostings(indexType int, docIDs []uint64, frequencies []float32, locations [][]int, ends [][]int) *packedPostings {
+	p := &packedPostings{indexType: indexType, length: len(docIDs)}
+	numBlocks := (len(docIDs) + postingBlockSize - 1) / postingBlockSize
+	p.firstDocIDs = make([]uint64, 0, numBlocks)
+	p.offsets = make([]uint32, 0, numBlocks)
+
+	var buf [binary.MaxVarintLen64]byte
+	var previous uint64
+	for i, docID := range docIDs {
+		if i%postingBlockSize == 0 {
+			p.firstDocIDs = append(p.firstDocIDs, docID)
+			p.offsets = append(p.offsets, uint32(len(p.data)))
+			previous = docID
+		}
+		n := binary.PutUvarint(buf[:], docID-previous)
+		p.data = append(p.data, buf[:n]...)
+		previous = docID
+
+		switch indexType {
+		case types.FrequenciesIndex:
+			n = binary.PutUvarint(buf[:], encodeFrequency(frequencies[i]))
+			p.data = append(p.data, buf[:n]...)
+		case types.LocationsIndex:
+			n = binary.PutUvarint(buf[:], uint64(len(locations[i])))
+			p.data = append(p.data, buf[:n]...)
+			last := 0
+			for _, location := range locations[i] {
+				n = binary.PutVarint(buf[:], int64(location-last))
+				p.data = append(p.data, buf[:n]...)
+				last = location
+			}
+			var end []int
+			if ends != nil {
+				end = ends[i]
+			}
+			n = binary.PutUvarint(buf[:], uint64(len(end)))
+			p.data = append(p.data, buf[:n]...)
+			for j, e := range end {
+				n = binary.PutUvarint(buf[:], uint64(e-locations[i][j]))
+				p.data = append(p.data, buf[:n]...)
+			}
+		}
+	}
+	// 去掉append多分配的容量
+	p.data = append([]byte(nil), p.data...)
+	return p
+}
+
+// 词频通常为整数，此时最低位为0，其余情况保存float32的全部位
+func encodeFrequency(frequency float32) uint64 {
+	if frequency >= 0 && frequency < 1<<24 && frequency == float32(uint32(frequency)) {
+		return uint64(frequency) << 1
+	}
+	return uint64(math.Float32bits(frequency))<<1 | 1
+}
+
+func decodeFrequency(x uint64) float32 {
+	if x&1 == 0 {
+		return float32(x >> 1)
+	}
+	return math.Float32frombits(uint32(x >> 1))
+}
+
+// 倒排表的读取游标，按位置读取索引项和查找文档
+//
+// 压缩的倒排表按块解码，游标保存最近解码的一块，按DocID顺序读取时每块只解码一次。
+// 游标只在一次查找中使用，不可被并发的查找共享
+type postingCursor struct {
+	*KeywordIndices
+
+	// 只解码DocID，不可读取词频和位置
+	docIDsOnly bool
+
+	block *postingBlock
+}
+
+// indices为nil时返回nil
+func newPostingCursor(indices *KeywordIndices) *postingCursor {
+	if indices == nil {
+		return nil
+	}
+	return &postingCursor{KeywordIndices: indices}
+}
+
+// 只用于查找文档的游标
+func newDocIDCursor(indices *KeywordIndices) *postingCursor {
+	return &postingCursor{KeywordIndices: indices, docIDsOnly: true}
+}
+
+// 返回压缩倒排表的第b块
+func (cursor *postingCursor) packedBlock(b int) *postingBlock {
+	if cursor.block == nil || cursor.block.index != b {
+		cursor.block = cursor.packed.decodeBlock(b, cursor.docIDsOnly)
+	}
+	return cursor.block
+}
+
+// 解码第b块，docIDsOnly为true时跳过词频和位置
+func (p *packedPostings) decodeBlock(b int, docIDsOnly bool) *postingBlock {
+	n := postingBlockSize
+	if b == len(p.firstDocIDs)-1 {
+		n = p.length - b*postingBlockSize
+	}
+	block := &postingBlock{index: b, docIDs: make([]uint64, n)}
+	if docIDsOnly {
+		p.decodeDocIDs(b, block.docIDs)
+		return block
+	}
+	switch p.indexType {
+	case types.FrequenciesIndex:
+		block.frequencies = make([]float32, n)
+	case types.LocationsIndex:
+		block.locations = make([][]int, n)
+		block.ends = make([][]int, n)
+	}
+
+	data := p.data[p.offsets[b]:]
+	docID := p.firstDocIDs[b]
+	for i := 0; i < n; i++ {
+		delta, m := binary.Uvarint(data)
+		data = data[m:]
+		docID += delta
+		block.docIDs[i] = docID
+
+		switch p.indexType {
+		case types.FrequenciesIndex:
+			x, m := binary.Uvarint(data)
+			data = data[m:]
+			block.frequencies[i] = decodeFrequency(x)
+		case types.LocationsIndex:
+			length, m := binary.Uvarint(data)
+			data = data[m:]
+			locations := make([]int, length)
+			last := 0
+			for j := range locations {
+				delta, m := binary.Varint(data)
+				data = data[m:]
+				last += int(delta)
+				locations[j] = last
+			}
+			block.locations[i] = locations
+
+			length, m = binary.Uvarint(data)
+			data = data[m:]
+			if length > 0 {
+				ends := make([]int, length)
+				for j := range ends {
+					delta, m := binary.Uvarint(data)
+					data = data[m:]
+					ends[j] = locations[j] + int(delta)
+				}
+				block.ends[i] = ends
+			}
+		}
+	}
+	return block
+}
+
+// 把第b块的DocID解码到docIDs中，跳过词频和位置而不分配内存
+func (p *packedPostings) decodeDocIDs(b int, docIDs []uint64) {
+	skip := func(data []byte, n uint64) []byte {
+		for ; n > 0; n-- {
+			_, m := binary.Uvarint(data)
+			data = data[m:]
+		}
+		return data
+	}
+	data := p.data[p.offsets[b]:]
+	docID := p.firstDocIDs[b]
+	for i := range docIDs {
+		delta, m := binary.Uvarint(data)
+		data = data[m:]
+		docID += delta
+		docIDs[i] = docID
+
+		switch p.indexType {
+		case types.FrequenciesIndex:
+			data = skip(data, 1)
+		case types.LocationsIndex:
+			// 位置为varint，与uvarint的字节数规则相同
+			length, m := binary.Uvarint(data)
+			data = skip(data[m:], length)
+			length, m = binary.Uvarint(data)
+			data = skip(data[m:], length)
+		}
+	}
+}
+
+// 解码全部DocID
+func (p *packedPostings) docIDs() []uint64 {
+	docIDs := make([]uint64, p.length)
+	for b := range p.firstDocIDs {
+		p.decodeDocIDs(b, docIDs[b*postingBlockSize:utils.MinInt((b+1)*postingBlockSize, p.length)])
+	}
+	return docIDs
+}
+
+// 解码全部索引项，只用于合并段和写入快照
+func (p *packedPostings) unpack() (docIDs []uint64, frequencies []float32, locations [][]int, ends [][]int) {
+	docIDs = make([]uint64, 0, p.length)
+	for b := range p.firstDocIDs {
+		block := p.decodeBlock(b, false)
+		docIDs = append(docIDs, block.docIDs...)
+		frequencies = append(frequencies, block.frequencies...)
+		locations = append(locations, block.locations...)
+		ends = append(ends, block.ends...)
+	}
+	return
+}
+
+// 在压缩的倒排表中查找，与Indexer.searchIndex的语义相同，先在跳表中找到所在的块，再在块内二分查找
+func (cursor *postingCursor) searchPacked(start int, end int, docID uint64) (int, bool) {
+	p := cursor.packed
+	if start == p.length {
+		return start, false
+	}
+	position, found := 0, false
+	b := sort.Search(len(p.firstDocIDs), func(i int) bool { return p.firstDocIDs[i] > docID }) - 1
+	if b >= 0 {
+		block := cursor.packedBlock(b)
+		i := sort.Search(len(block.docIDs), func(i int) bool { return block.docIDs[i] >= docID })
+		position = b*postingBlockSize + i
+		found = i < len(block.docIDs) && block.docIDs[i] == docID
+	}
+	if position < start {
+		return start, false
+	}
+	if position > end {
+		return end + 1, false
+	}
+	return position, found
+}
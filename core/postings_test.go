@@ -0,0 +1,153 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/pickjunk/wuneng/types"
+	"github.com/pickjunk/wuneng/utils"
+)
+
+func TestPackPostings(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	var plain KeywordIndices
+	docID := uint64(0)
+	for i := 0; i < 1000; i++ {
+		docID += uint64(1 + r.Intn(1000))
+		plain.docIDs = append(plain.docIDs, docID)
+		plain.frequencies = append(plain.frequencies, float32(r.Intn(10)))
+		plain.locations = append(plain.locations, []int{r.Intn(100), 100 + r.Intn(100)})
+	}
+	plain.frequencies[3] = 0.5
+	plain.locations[5] = nil
+	plain.ends = make([][]int, len(plain.locations))
+	plain.ends[7] = []int{plain.locations[7][0] + 9, plain.locations[7][1] + 3}
+
+	var indexer Indexer
+	for _, indexType := range []int{types.DocIDsIndex, types.FrequenciesIndex, types.LocationsIndex} {
+		packed := packPostings(indexType, plain.docIDs, plain.frequencies, plain.locations, plain.ends)
+		docIDs, frequencies, locations, ends := packed.unpack()
+		utils.Expect(t, fmt.Sprint(plain.docIDs), docIDs)
+		switch indexType {
+		case types.FrequenciesIndex:
+			utils.Expect(t, fmt.Sprint(plain.frequencies), frequencies)
+		case types.LocationsIndex:
+			utils.Expect(t, fmt.Sprint(plain.locations), locations)
+			utils.Expect(t, fmt.Sprint(plain.ends), ends)
+		}
+
+		utils.Expect(t, fmt.Sprint(plain.docIDs), packed.docIDs())
+
+		// 查找结果与未压缩时相同
+		ti := KeywordIndices{packed: packed}
+		plainCursor, cursor, docIDCursor := newPostingCursor(&plain), newPostingCursor(&ti), newDocIDCursor(&ti)
+		for i := 0; i < 1000; i++ {
+			start := r.Intn(len(plain.docIDs))
+			end := start + r.Intn(len(plain.docIDs)-start)
+			target := uint64(r.Int63n(int64(docID + 10)))
+			if i%2 == 0 {
+				target = plain.docIDs[r.Intn(len(plain.docIDs))]
+			}
+			expectedPosition, expectedFound := indexer.searchIndex(plainCursor, start, end, target)
+			position, found := indexer.searchIndex(cursor, start, end, target)
+			utils.Expect(t, fmt.Sprint(expectedPosition, expectedFound), fmt.Sprint(position, found))
+			position, found = indexer.searchIndex(docIDCursor, start, end, target)
+			utils.Expect(t, fmt.Sprint(expectedPosition, expectedFound), fmt.Sprint(position, found))
+		}
+		utils.Expect(t, fmt.Sprint(plain.docIDs[777]), indexer.getDocID(cursor, 777))
+
+		// 在部分文档中查找与未压缩时相同
+		var candidates []uint64
+		for docID := uint64(1); docID < plain.docIDs[len(plain.docIDs)-1]+10; docID += uint64(1 + r.Intn(100)) {
+			candidates = append(candidates, docID)
+		}
+		candidates = unionDocIDs(candidates, plain.docIDs[100:300])
+		for _, contained := range []bool{true, false} {
+			utils.Expect(t, fmt.Sprint(indexer.matchPostings(candidates, &plain, contained)),
+				indexer.matchPostings(candidates, &ti, contained))
+		}
+	}
+}
+
+// 用相同的文档分别建立压缩和未压缩的索引器
+func randomIndexers(indexType int, n int) (*Indexer, *Indexer) {
+	var compressed, plain Indexer
+	compressed.Init(types.IndexerInitOptions{IndexType: indexType, CompressPostings: true, DocCacheSize: 100})
+	plain.Init(types.IndexerInitOptions{IndexType: indexType, DocCacheSize: 100})
+
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < n; i++ {
+		docID := uint64(1 + r.Intn(n*2))
+		var keywords []types.KeywordIndex
+		for _, token := range []string{"a", "b", "c", "label:x", "label:y"} {
+			if r.Intn(3) == 0 {
+				continue
+			}
+			starts := []int{r.Intn(50), 50 + r.Intn(50)}
+			keyword := types.KeywordIndex{Text: token, Frequency: float32(len(starts)), Starts: starts}
+			if token == "b" && r.Intn(2) == 0 {
+				keyword.Ends = []int{starts[0] + 3, starts[1] + 3}
+			}
+			keywords = append(keywords, keyword)
+		}
+		document := types.DocumentIndex{DocID: docID, TokenLength: float32(10 + r.Intn(10)), Keywords: keywords}
+		compressed.AddDocumentToCache(&document, false)
+		plain.AddDocumentToCache(&document, false)
+		if r.Intn(5) == 0 {
+			compressed.AddDocumentToCache(nil, true)
+			plain.AddDocumentToCache(nil, true)
+			docID := uint64(1 + r.Intn(n*2))
+			compressed.RemoveDocumentToCache(docID, true)
+			plain.RemoveDocumentToCache(docID, true)
+		}
+	}
+	compressed.AddDocumentToCache(nil, true)
+	plain.AddDocumentToCache(nil, true)
+	return &compressed, &plain
+}
+
+func TestCompressedIndexer(t *testing.T) {
+	ctx := context.Background()
+	for _, indexType := range []int{types.DocIDsIndex, types.FrequenciesIndex, types.LocationsIndex} {
+		compressed, plain := randomIndexers(indexType, 2000)
+		utils.Expect(t, indicesToString(plain, "a"), indicesToString(compressed, "a"))
+
+		expected, n, _ := plain.LookupContext(ctx, []string{"a", "b"}, []string{"label:x"},
+			types.LookupOptions{TopK: 10})
+		docs, m, _ := compressed.LookupContext(ctx, []string{"a", "b"}, []string{"label:x"},
+			types.LookupOptions{TopK: 10})
+		utils.Expect(t, fmt.Sprint(n), m)
+		utils.Expect(t, fmt.Sprint(expected), docs)
+
+		query := types.Query{
+			Should:  []types.Query{{Token: "a"}, {Phrase: []string{"b", "c"}, Window: 60}},
+			MustNot: []types.Query{{Token: "label:y"}},
+		}
+		expected, _ = plain.LookupQuery(&query, nil, false)
+		docs, _ = compressed.LookupQuery(&query, nil, false)
+		utils.Expect(t, fmt.Sprint(expected), docs)
+
+		expectedFacets, _ := plain.CountFacets(expected, []string{"label:"})
+		facets, _ := compressed.CountFacets(docs, []string{"label:"})
+		utils.Expect(t, fmt.Sprint(expectedFacets), facets)
+
+		// 合并段后仍是压缩的
+		compressed.MergeSegments()
+		utils.Expect(t, "true", isPacked(compressed, "a"))
+		docs, _ = compressed.LookupQuery(&query, nil, false)
+		utils.Expect(t, fmt.Sprint(expected), docs)
+
+		// 快照与是否压缩无关
+		var buf bytes.Buffer
+		utils.Expect(t, "<nil>", compressed.Snapshot(&buf))
+		var restored Indexer
+		restored.Init(types.IndexerInitOptions{IndexType: indexType, CompressPostings: true})
+		utils.Expect(t, "<nil>", restored.Restore(&buf))
+		utils.Expect(t, "true", isPacked(&restored, "a"))
+		docs, _ = restored.LookupQuery(&query, nil, false)
+		utils.Expect(t, fmt.Sprint(expected), docs)
+	}
+}
@@ -0,0 +1,94 @@
+package core
+
+import (
+	"container/heap"
+
+	"github.com/pickjunk/wuneng/types"
+)
+
+// 浮点累加顺序不同带来的舍入误差，剪枝时留出余量
+const pruneMargin = 1e-5
+
+// MaxScore剪枝：保留已输出文档中最高的k个BM25，
+// 文档已计算的BM25加上其余搜索键的上界低于第k高的分值时，该文档不可能进入前k。
+// 只跳过上界严格低于第k高分值的文档，因此与文档的查找顺序无关，可以跨段共用
+type bm25Pruner struct {
+	k      int
+	scores float32Heap
+
+	// rest[i]为第i个及之后的搜索键的BM25上界之和
+	rest []float32
+
+	// 不为nil时只有返回true的文档计入前k，见LookupOptions.Ranked
+	ranked func(docID uint64) bool
+}
+
+// k不大于0时返回nil
+func newBM25Pruner(k int) *bm25Pruner {
+	if k <= 0 {
+		return nil
+	}
+	return &bm25Pruner{k: k}
+}
+
+// 按查找选项创建剪枝器，只统计文档数或索引类型不计算BM25时返回nil
+func (indexer *Indexer) newPruner(options types.LookupOptions) *bm25Pruner {
+	if options.CountDocsOnly || (indexer.initOptions.IndexType != types.LocationsIndex &&
+		indexer.initOptions.IndexType != types.FrequenciesIndex) {
+		return nil
+	}
+	pruner := newBM25Pruner(options.TopK)
+	if pruner != nil {
+		pruner.ranked = options.Ranked
+	}
+	return pruner
+}
+
+// 文档是否计入numDocs，剪枝时排序器不再统计文档数，须与排序器一样剔除Ranked返回false的文档
+// pruner为nil时全部计入，由排序器统计
+func (pruner *bm25Pruner) counts(docID uint64) bool {
+	return pruner == nil || pruner.ranked == nil || pruner.ranked(docID)
+}
+
+// 设置各搜索键的BM25上界，上界随段不同
+func (pruner *bm25Pruner) setBounds(bounds []float32) {
+	pruner.rest = make([]float32, len(bounds)+1)
+	for i := len(bounds) - 1; i >= 0; i-- {
+		pruner.rest[i] = pruner.rest[i+1] + bounds[i]
+	}
+}
+
+// 已计算到第i个搜索键、部分BM25为partial的文档是否可以跳过
+func (pruner *bm25Pruner) prunable(partial float32, i int) bool {
+	if len(pruner.scores) < pruner.k {
+		return false
+	}
+	return (partial+pruner.rest[i])*(1+pruneMargin) < pruner.scores[0]
+}
+
+// 记录一个已输出文档的BM25
+func (pruner *bm25Pruner) add(docID uint64, score float32) {
+	if pruner.ranked != nil && !pruner.ranked(docID) {
+		return
+	}
+	if len(pruner.scores) < pruner.k {
+		heap.Push(&pruner.scores, score)
+	} else if score > pruner.scores[0] {
+		pruner.scores[0] = score
+		heap.Fix(&pruner.scores, 0)
+	}
+}
+
+// float32的最小堆
+type float32Heap []float32
+
+func (h float32Heap) Len() int            { return len(h) }
+func (h float32Heap) Less(i, j int) bool  { return h[i] < h[j] }
+func (h float32Heap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *float32Heap) Push(x interface{}) { *h = append(*h, x.(float32)) }
+func (h *float32Heap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
@@ -0,0 +1,121 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/pickjunk/wuneng/types"
+	"github.com/pickjunk/wuneng/utils"
+)
+
+// 随机生成文档，"common"出现在全部文档中，"rare"只出现在少数文档中
+func randomPruneIndexer(indexType int, n int) (*Indexer, *Ranker) {
+	var indexer Indexer
+	indexer.Init(types.IndexerInitOptions{
+		IndexType:      indexType,
+		BM25Parameters: &types.BM25Parameters{K1: 2, B: 0.75},
+	})
+	var ranker Ranker
+	ranker.Init()
+
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < n; i++ {
+		docID := uint64(i + 1)
+		var keywords []types.KeywordIndex
+		length := 10 + r.Intn(90)
+		for _, token := range []string{"common", "word", "rare"} {
+			frequency := r.Intn(5) + 1
+			if token == "rare" && r.Intn(10) != 0 {
+				continue
+			}
+			starts := make([]int, frequency)
+			for j := range starts {
+				starts[j] = r.Intn(length) * 3
+			}
+			keywords = append(keywords, types.KeywordIndex{
+				Text: token, Frequency: float32(frequency), Starts: starts})
+		}
+		indexer.AddDocumentToCache(&types.DocumentIndex{
+			DocID: docID, TokenLength: float32(length), Keywords: keywords}, false)
+		ranker.AddDoc(docID, nil)
+	}
+	indexer.AddDocumentToCache(nil, true)
+	return &indexer, &ranker
+}
+
+func TestLookupPruned(t *testing.T) {
+	ctx := context.Background()
+	for _, indexType := range []int{types.LocationsIndex, types.FrequenciesIndex} {
+		indexer, ranker := randomPruneIndexer(indexType, 2000)
+		// 删除文档后上界不变，仍然有效
+		indexer.RemoveDocumentToCache(7, true)
+
+		for _, k := range []int{1, 10, 100} {
+			options := types.RankOptions{ScoringCriteria: types.RankByBM25{}, MaxOutputs: k}
+			tokens := []string{"common", "word", "rare"}
+
+			all, numDocs, _ := indexer.LookupContext(ctx, tokens, nil, types.LookupOptions{})
+			pruned, numPruned, _ := indexer.LookupContext(ctx, tokens, nil, types.LookupOptions{TopK: k})
+			utils.Expect(t, fmt.Sprint(numDocs), numPruned)
+			if len(pruned) >= len(all) {
+				t.Errorf("k=%d: 剪枝后仍有%d个文档", k, len(pruned))
+			}
+			expected, _ := ranker.Rank(all, options, false)
+			actual, _ := ranker.Rank(pruned, options, false)
+			utils.Expect(t, scoredDocsToString(expected), scoredDocsToString(actual))
+
+			query := types.Query{Should: []types.Query{{Token: "word"}, {Token: "rare"}}}
+			all, numDocs, _ = indexer.LookupQueryContext(ctx, &query, types.LookupOptions{})
+			pruned, numPruned, _ = indexer.LookupQueryContext(ctx, &query, types.LookupOptions{TopK: k})
+			utils.Expect(t, fmt.Sprint(numDocs), numPruned)
+			expected, _ = ranker.Rank(all, options, false)
+			actual, _ = ranker.Rank(pruned, options, false)
+			utils.Expect(t, scoredDocsToString(expected), scoredDocsToString(actual))
+		}
+
+		// 排序器中没有的文档不计入前k，剪枝后仍能得到完整的前k个
+		options := types.RankOptions{ScoringCriteria: types.RankByBM25{}, MaxOutputs: 10}
+		tokens := []string{"common", "word", "rare"}
+		all, _, _ := indexer.LookupContext(ctx, tokens, nil, types.LookupOptions{})
+		top, _ := ranker.Rank(all, types.RankOptions{ScoringCriteria: types.RankByBM25{}, MaxOutputs: 20}, false)
+		for _, doc := range top {
+			ranker.RemoveDoc(doc.DocID)
+		}
+		pruned, numPruned, _ := indexer.LookupContext(ctx, tokens, nil,
+			types.LookupOptions{TopK: 10, Ranked: ranker.HasDoc})
+		expected, numExpected := ranker.Rank(all, options, false)
+		actual, _ := ranker.Rank(pruned, options, false)
+		utils.Expect(t, "10", len(actual))
+		utils.Expect(t, scoredDocsToString(expected), scoredDocsToString(actual))
+
+		// 文档数与不剪枝时排序器统计的相同，不包含排序器中没有的文档
+		utils.Expect(t, fmt.Sprint(numExpected), numPruned)
+		utils.Expect(t, fmt.Sprint(len(all)-len(top)), numPruned)
+		query := types.Query{Should: []types.Query{{Token: "word"}, {Token: "rare"}}}
+		all, _, _ = indexer.LookupQueryContext(ctx, &query, types.LookupOptions{})
+		_, numExpected = ranker.Rank(all, options, false)
+		_, numPruned, _ = indexer.LookupQueryContext(ctx, &query, types.LookupOptions{TopK: 10, Ranked: ranker.HasDoc})
+		utils.Expect(t, fmt.Sprint(numExpected), numPruned)
+	}
+}
+
+func benchmarkLookup(b *testing.B, topK int) {
+	indexer, ranker := randomPruneIndexer(types.LocationsIndex, 100000)
+	options := types.RankOptions{ScoringCriteria: types.RankByBM25{}, MaxOutputs: 10}
+	tokens := []string{"common", "word", "rare"}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		docs, _, _ := indexer.LookupContext(context.Background(), tokens, nil, types.LookupOptions{TopK: topK})
+		ranker.Rank(docs, options, false)
+	}
+}
+
+func BenchmarkLookupPruned(b *testing.B) {
+	benchmarkLookup(b, 10)
+}
+
+func BenchmarkLookupUnpruned(b *testing.B) {
+	benchmarkLookup(b, 0)
+}
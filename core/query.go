@@ -0,0 +1,525 @@
+package core
+
+import (
+	"context"
+	"sort"
+
+	"github.com/pickjunk/wuneng/types"
+)
+
+// LookupQuery 查找满足布尔查询树的文档
+// 当docIDs不为nil时仅从docIDs指定的文档中查找
+// 返回的文档按DocID从大到小排列，BM25和紧邻距离按query.ScoringTokens()计算，
+// 各搜索键的得分乘以query.ScoringBoosts()中的系数，TokenSnippetLocations和TokenLocations也与之一一对应
+func (indexer *Indexer) LookupQuery(
+	query *types.Query, docIDs map[uint64]bool, countDocsOnly bool) (docs []types.IndexedDocument, numDocs int) {
+	docs, numDocs, _ = indexer.LookupQueryContext(context.Background(), query,
+		types.LookupOptions{DocIDs: docIDs, CountDocsOnly: countDocsOnly})
+	return
+}
+
+// LookupQueryContext 与LookupQuery相同，但按options查找，且ctx取消时提前结束，返回已找到的部分文档和ctx.Err()，
+// 索引器未初始化时返回ErrNotInitialized
+func (indexer *Indexer) LookupQueryContext(ctx context.Context, query *types.Query, options types.LookupOptions) (
+	docs []types.IndexedDocument, numDocs int, err error) {
+	if indexer.initialized == false {
+		err = ErrNotInitialized
+		return
+	}
+
+	// numDocuments和totalTokenLength在tableLock写锁下更新
+	indexer.tableLock.RLock()
+	defer indexer.tableLock.RUnlock()
+	if indexer.numDocuments == 0 {
+		return
+	}
+
+	tokens, boosts := query.ScoringTokens(), query.ScoringBoosts()
+	dfs := make([]int, len(tokens))
+	for i, token := range tokens {
+		dfs[i] = indexer.documentFrequency(token)
+	}
+
+	// 平均文本关键词长度，用于计算BM25
+	avgDocLength := indexer.totalTokenLength / float32(indexer.numDocuments)
+	pruner := indexer.newPruner(options)
+
+	// 逐段查找，各段的结果按DocID从大到小归并
+	var segmentDocs [][]types.IndexedDocument
+	for _, seg := range indexer.tableLock.segments {
+		found, n, segmentErr := indexer.lookupQuerySegment(ctx, seg, query, tokens, boosts, dfs, &options,
+			pruner, avgDocLength)
+		segmentDocs = append(segmentDocs, found)
+		numDocs += n
+		if err = segmentErr; err != nil {
+			break
+		}
+	}
+	docs = mergeIndexedDocuments(segmentDocs)
+	return
+}
+
+// 在一个段中查找满足布尔查询树的文档，按DocID从大到小输出
+func (indexer *Indexer) lookupQuerySegment(ctx context.Context, seg *segment, query *types.Query,
+	tokens []string, boosts []float32, dfs []int, options *types.LookupOptions,
+	pruner *bm25Pruner, avgDocLength float32) (
+	docs []types.IndexedDocument, numDocs int, err error) {
+	candidates := indexer.evaluateQuery(seg, query)
+	if len(candidates) == 0 {
+		return
+	}
+
+	table := make([]*postingCursor, len(tokens))
+	for i, token := range tokens {
+		// 本段中不存在的搜索键为nil，不参与评分
+		table[i] = newPostingCursor(seg.table[token])
+	}
+	if pruner != nil {
+		bounds := make([]float32, len(table))
+		for i, token := range tokens {
+			bounds[i] = boosts[i] * indexer.keywordBM25Bound(seg.table[token], dfs[i], avgDocLength)
+		}
+		pruner.setBounds(bounds)
+	}
+
+	// 从后向前输出保证先输出DocID较大文档
+	for i := len(candidates) - 1; i >= 0; i-- {
+		if (len(candidates)-i)%contextCheckInterval == 0 {
+			if err = ctx.Err(); err != nil {
+				return
+			}
+		}
+		docID := candidates[i]
+		if options.DocIDs != nil {
+			if _, found := options.DocIDs[docID]; !found {
+				continue
+			}
+		}
+		if docState, ok := indexer.tableLock.docsState[docID]; !ok || docState != 0 || seg.deleted[docID] {
+			continue
+		}
+		if !indexer.matchFilters(docID, options.Filters) {
+			continue
+		}
+		if pruner.counts(docID) {
+			numDocs++
+		}
+		if pruner != nil {
+			// 所有搜索键的上界之和不足时无需查找文档中的搜索键
+			if pruner.prunable(0, 0) {
+				continue
+			}
+			bm25 := indexer.documentBM25(docID, table, dfs, boosts, avgDocLength)
+			if pruner.prunable(bm25, len(table)) {
+				continue
+			}
+			pruner.add(docID, bm25)
+		}
+		if !options.CountDocsOnly {
+			docs = append(docs, indexer.scoreDocument(seg, docID, table, dfs, tokens, boosts, avgDocLength))
+		}
+	}
+	return
+}
+
+// 求段中匹配查询树的文档，返回按DocID升序排列的切片，其中可能有已删除的文档
+// 返回值可能直接引用反向索引表，调用者不可修改，且须持有tableLock读锁
+func (indexer *Indexer) evaluateQuery(seg *segment, query *types.Query) []uint64 {
+	if query.IsLeaf() {
+		if indices, found := seg.table[query.Keyword(query.Token)]; found {
+			return indexer.getDocIDs(indices)
+		}
+		return nil
+	}
+	if query.IsPhrase() {
+		return indexer.evaluatePhrase(seg, query)
+	}
+
+	// 先求非叶子子句，叶子子句按文档数从少到多在已有结果上查找，
+	// 压缩的倒排表只需解码结果所在的块
+	var leaves []*KeywordIndices
+	var result []uint64
+	hasResult := false
+	for i := range query.Must {
+		if q := &query.Must[i]; q.IsLeaf() {
+			indices, found := seg.table[q.Keyword(q.Token)]
+			if !found {
+				return nil
+			}
+			leaves = append(leaves, indices)
+			continue
+		}
+		docIDs := indexer.evaluateQuery(seg, &query.Must[i])
+		if hasResult {
+			result = intersectDocIDs(result, docIDs)
+		} else {
+			result, hasResult = docIDs, true
+		}
+		if len(result) == 0 {
+			return nil
+		}
+	}
+	sort.Slice(leaves, func(i, j int) bool {
+		return indexer.getIndexLength(leaves[i]) < indexer.getIndexLength(leaves[j])
+	})
+	for _, indices := range leaves {
+		if hasResult {
+			result = indexer.matchPostings(result, indices, true)
+		} else {
+			result, hasResult = indexer.getDocIDs(indices), true
+		}
+		if len(result) == 0 {
+			return nil
+		}
+	}
+
+	if len(query.Should) > 0 {
+		var union []uint64
+		for i := range query.Should {
+			union = unionDocIDs(union, indexer.evaluateQuery(seg, &query.Should[i]))
+		}
+		if hasResult {
+			result = intersectDocIDs(result, union)
+		} else {
+			result, hasResult = union, true
+		}
+	}
+
+	if len(query.MustNot) > 0 {
+		if !hasResult {
+			// 只有NOT子句时从全部文档中排除
+			result = seg.docIDs
+		}
+		for i := range query.MustNot {
+			if len(result) == 0 {
+				break
+			}
+			if q := &query.MustNot[i]; q.IsLeaf() {
+				result = indexer.matchPostings(result, seg.table[q.Keyword(q.Token)], false)
+			} else {
+				result = differenceDocIDs(result, indexer.evaluateQuery(seg, q))
+			}
+		}
+	}
+	return result
+}
+
+// 求满足短语位置约束的文档，返回值规则同evaluateQuery
+func (indexer *Indexer) evaluatePhrase(seg *segment, query *types.Query) []uint64 {
+	table := make([]*postingCursor, len(query.Phrase))
+	for i, token := range query.Phrase {
+		indices, found := seg.table[query.Keyword(token)]
+		if !found {
+			return nil
+		}
+		table[i] = newPostingCursor(indices)
+	}
+
+	// 从文档数最少的搜索键开始求交集
+	shortest := 0
+	for i, cursor := range table {
+		if indexer.getIndexLength(cursor.KeywordIndices) < indexer.getIndexLength(table[shortest].KeywordIndices) {
+			shortest = i
+		}
+	}
+	result := indexer.getDocIDs(table[shortest].KeywordIndices)
+	for i, cursor := range table {
+		if i != shortest {
+			result = indexer.matchPostings(result, cursor.KeywordIndices, true)
+		}
+		if len(result) == 0 {
+			return nil
+		}
+	}
+	if indexer.initOptions.IndexType != types.LocationsIndex || len(table) == 1 {
+		return result
+	}
+
+	// 逐个文档检查位置约束，result升序因此各搜索键的查找起点单调递增
+	matched := make([]uint64, 0, len(result))
+	pointers := make([]int, len(table))
+	locations := make([][]int, len(table))
+	ends := make([][]int, len(table))
+	for _, docID := range result {
+		for i, cursor := range table {
+			pointers[i], _ = indexer.searchIndex(
+				cursor, pointers[i], indexer.getIndexLength(cursor.KeywordIndices)-1, docID)
+			locations[i] = indexer.getLocations(cursor, pointers[i])
+			ends[i] = indexer.getEnds(cursor, pointers[i])
+		}
+		var starts []int
+		if query.InTokens {
+			starts = indexer.docTokenStarts[docID]
+		}
+		if matchPhrase(locations, ends, starts, query.Phrase, query.Slop, query.Window) {
+			matched = append(matched, docID)
+		}
+	}
+	return matched
+}
+
+// 判断各搜索键的出现位置是否满足短语约束，ends[i]为第i个搜索键各次出现的结束位置，见tokenEnd
+// window > 0 时要求全部搜索键出现在跨度不超过window的范围内，
+// 否则要求按顺序出现且相邻搜索键之间的间隔不超过slop。
+// starts为nil时以字节为单位，否则为文档中全部关键词的起始位置，以关键词个数为单位，见phraseDistance
+func matchPhrase(locations [][]int, ends [][]int, starts []int, tokens []string, slop int, window int) bool {
+	if window > 0 {
+		return matchWindow(locations, ends, starts, tokens, window)
+	}
+
+	// reachable 为第i个搜索键满足前i个搜索键约束的出现位置的下标
+	reachable := make([]int, len(locations[0]))
+	for j := range reachable {
+		reachable[j] = j
+	}
+	for i := 1; i < len(tokens); i++ {
+		var next []int
+		for j, location := range locations[i] {
+			for _, previous := range reachable {
+				gap := phraseDistance(starts, tokenEnd(locations, ends, tokens, i-1, previous), location)
+				if gap >= 0 && gap <= slop {
+					next = append(next, j)
+					break
+				}
+			}
+		}
+		if len(next) == 0 {
+			return false
+		}
+		reachable = next
+	}
+	return len(reachable) > 0
+}
+
+// 滑动窗口求覆盖全部搜索键的最小跨度
+func matchWindow(locations [][]int, ends [][]int, starts []int, tokens []string, window int) bool {
+	type occurrence struct {
+		start, end, token int
+	}
+	var occurrences []occurrence
+	for i, starts := range locations {
+		if len(starts) == 0 {
+			return false
+		}
+		for j, start := range starts {
+			occurrences = append(occurrences, occurrence{start, tokenEnd(locations, ends, tokens, i, j), i})
+		}
+	}
+	sort.Slice(occurrences, func(i, j int) bool { return occurrences[i].start < occurrences[j].start })
+
+	counts := make([]int, len(tokens))
+	covered, left := 0, 0
+	for right := range occurrences {
+		if counts[occurrences[right].token] == 0 {
+			covered++
+		}
+		counts[occurrences[right].token]++
+		for covered == len(tokens) {
+			end := 0
+			for _, o := range occurrences[left : right+1] {
+				if o.end > end {
+					end = o.end
+				}
+			}
+			if phraseDistance(starts, occurrences[left].start, end) <= window {
+				return true
+			}
+			counts[occurrences[left].token]--
+			if counts[occurrences[left].token] == 0 {
+				covered--
+			}
+			left++
+		}
+	}
+	return false
+}
+
+// 从from到to的距离，starts为nil时为字节数，否则为起始位置在[from, to)中的关键词个数
+// to < from（位置重叠）时总是返回负数
+func phraseDistance(starts []int, from int, to int) int {
+	if starts == nil || to < from {
+		return to - from
+	}
+	return sort.SearchInts(starts, to) - sort.SearchInts(starts, from)
+}
+
+// 第i个搜索键第j次出现的结束位置，没有记录结束位置时为起始位置加搜索键长度
+func tokenEnd(locations [][]int, ends [][]int, tokens []string, i int, j int) int {
+	if ends != nil && len(ends[i]) > j {
+		return ends[i][j]
+	}
+	return locations[i][j] + len(tokens[i])
+}
+
+// 只计算文档在各搜索键上的BM25，与scoreDocument一致
+func (indexer *Indexer) documentBM25(docID uint64,
+	table []*postingCursor, dfs []int, boosts []float32, avgDocLength float32) float32 {
+	bm25 := float32(0)
+	d := indexer.docTokenLengths[docID]
+	for i, indices := range table {
+		if indices == nil {
+			continue
+		}
+		position, found := indexer.searchIndex(indices, 0, indexer.getIndexLength(indices.KeywordIndices)-1, docID)
+		if !found {
+			continue
+		}
+		var frequency float32
+		if indexer.initOptions.IndexType == types.LocationsIndex {
+			frequency = float32(len(indexer.getLocations(indices, position)))
+		} else {
+			frequency = indexer.getFrequency(indices, position)
+		}
+		bm25 += boosts[i] * indexer.keywordBM25(dfs[i], frequency, d, avgDocLength)
+	}
+	return bm25
+}
+
+// 计算文档在各搜索键上的BM25、BM25F和紧邻距离，table中为nil或不包含该文档的搜索键不参与计算
+func (indexer *Indexer) scoreDocument(seg *segment, docID uint64,
+	table []*postingCursor, dfs []int, tokens []string, boosts []float32, avgDocLength float32) types.IndexedDocument {
+	indexedDoc := types.IndexedDocument{DocID: docID}
+	if indexer.initOptions.IndexType != types.LocationsIndex &&
+		indexer.initOptions.IndexType != types.FrequenciesIndex {
+		return indexedDoc
+	}
+
+	// 文档中出现的搜索键，以及其中带有位置信息的部分（用于计算紧邻距离）
+	var (
+		present, located []int
+		positions        = make([]int, len(table))
+		locatedLocations [][]int
+		locatedEnds      [][]int
+		locatedTokens    []string
+		d                = indexer.docTokenLengths[docID]
+	)
+	for i, indices := range table {
+		if indices == nil {
+			continue
+		}
+		position, found := indexer.searchIndex(indices, 0, indexer.getIndexLength(indices.KeywordIndices)-1, docID)
+		if !found {
+			continue
+		}
+		positions[i] = position
+		present = append(present, i)
+
+		var frequency float32
+		if indexer.initOptions.IndexType == types.LocationsIndex {
+			locations := indexer.getLocations(indices, position)
+			frequency = float32(len(locations))
+			if len(locations) > 0 {
+				located = append(located, i)
+				locatedLocations = append(locatedLocations, locations)
+				locatedEnds = append(locatedEnds, indexer.getEnds(indices, position))
+				locatedTokens = append(locatedTokens, tokens[i])
+			}
+		} else {
+			frequency = indexer.getFrequency(indices, position)
+		}
+		indexedDoc.BM25 += boosts[i] * indexer.keywordBM25(dfs[i], frequency, d, avgDocLength)
+	}
+	indexedDoc.BM25F = indexer.documentBM25F(seg, docID, tokens, dfs, boosts)
+
+	if indexer.initOptions.IndexType == types.LocationsIndex {
+		indexedDoc.TokenLocations = make([][]int, len(tokens))
+		indexedDoc.TokenEnds = make([][]int, len(tokens))
+		for _, i := range present {
+			indexedDoc.TokenLocations[i] = indexer.getLocations(table[i], positions[i])
+			indexedDoc.TokenEnds[i] = indexer.getEnds(table[i], positions[i])
+		}
+		indexedDoc.TokenSnippetLocations = make([]int, len(tokens))
+		for i := range indexedDoc.TokenSnippetLocations {
+			indexedDoc.TokenSnippetLocations[i] = -1
+		}
+		if len(located) > 0 {
+			tokenProximity, tokenLocations := computeTokenProximity(locatedLocations, locatedEnds, locatedTokens)
+			indexedDoc.TokenProximity = int32(tokenProximity)
+			for j, i := range located {
+				indexedDoc.TokenSnippetLocations[i] = tokenLocations[j]
+			}
+		}
+	}
+	return indexedDoc
+}
+
+// 两个升序DocID列表的交集
+// 长度悬殊时对较长的列表二分查找，否则顺序归并
+func intersectDocIDs(a, b []uint64) []uint64 {
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	result := make([]uint64, 0, len(a))
+	if len(a)*8 < len(b) {
+		start := 0
+		for _, docID := range a {
+			start += sort.Search(len(b)-start, func(i int) bool { return b[start+i] >= docID })
+			if start == len(b) {
+				break
+			}
+			if b[start] == docID {
+				result = append(result, docID)
+			}
+		}
+		return result
+	}
+
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		if a[i] < b[j] {
+			i++
+		} else if a[i] > b[j] {
+			j++
+		} else {
+			result = append(result, a[i])
+			i++
+			j++
+		}
+	}
+	return result
+}
+
+// 两个升序DocID列表的并集
+func unionDocIDs(a, b []uint64) []uint64 {
+	if len(a) == 0 {
+		return b
+	}
+	if len(b) == 0 {
+		return a
+	}
+	result := make([]uint64, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		if a[i] < b[j] {
+			result = append(result, a[i])
+			i++
+		} else if a[i] > b[j] {
+			result = append(result, b[j])
+			j++
+		} else {
+			result = append(result, a[i])
+			i++
+			j++
+		}
+	}
+	result = append(result, a[i:]...)
+	return append(result, b[j:]...)
+}
+
+// 升序DocID列表a中去掉b中的文档
+func differenceDocIDs(a, b []uint64) []uint64 {
+	if len(b) == 0 {
+		return a
+	}
+	result := make([]uint64, 0, len(a))
+	j := 0
+	for _, docID := range a {
+		for j < len(b) && b[j] < docID {
+			j++
+		}
+		if j < len(b) && b[j] == docID {
+			continue
+		}
+		result = append(result, docID)
+	}
+	return result
+}
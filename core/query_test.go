@@ -0,0 +1,260 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/pickjunk/wuneng/types"
+	"github.com/pickjunk/wuneng/utils"
+)
+
+func addDocsForQuery(indexer *Indexer) {
+	// doc1 = "token2 token3"
+	indexer.AddDocumentToCache(&types.DocumentIndex{
+		DocID: 1,
+		Keywords: []types.KeywordIndex{
+			{Text: "token2", Frequency: 1, Starts: []int{0}},
+			{Text: "token3", Frequency: 1, Starts: []int{7}},
+		},
+	}, false)
+	// doc2 = "token1 token2 token3"
+	indexer.AddDocumentToCache(&types.DocumentIndex{
+		DocID: 2,
+		Keywords: []types.KeywordIndex{
+			{Text: "token1", Frequency: 1, Starts: []int{0}},
+			{Text: "token2", Frequency: 1, Starts: []int{7}},
+			{Text: "token3", Frequency: 1, Starts: []int{14}},
+		},
+	}, false)
+	// doc3 = "token1 token2"
+	indexer.AddDocumentToCache(&types.DocumentIndex{
+		DocID: 3,
+		Keywords: []types.KeywordIndex{
+			{Text: "token1", Frequency: 1, Starts: []int{0}},
+			{Text: "token2", Frequency: 1, Starts: []int{7}},
+		},
+	}, false)
+	// doc4 = "token2"
+	indexer.AddDocumentToCache(&types.DocumentIndex{
+		DocID: 4,
+		Keywords: []types.KeywordIndex{
+			{Text: "token2", Frequency: 1, Starts: []int{0}},
+		},
+	}, false)
+	// doc7 = "token1 token3"
+	indexer.AddDocumentToCache(&types.DocumentIndex{
+		DocID: 7,
+		Keywords: []types.KeywordIndex{
+			{Text: "token1", Frequency: 1, Starts: []int{0}},
+			{Text: "token3", Frequency: 1, Starts: []int{7}},
+		},
+	}, false)
+	// doc9 = "token3"
+	indexer.AddDocumentToCache(&types.DocumentIndex{
+		DocID: 9,
+		Keywords: []types.KeywordIndex{
+			{Text: "token3", Frequency: 1, Starts: []int{0}},
+		},
+	}, true)
+}
+
+func leaf(token string) types.Query {
+	return types.Query{Token: token}
+}
+
+func TestLookupQuery(t *testing.T) {
+	var indexer Indexer
+	indexer.Init(types.IndexerInitOptions{IndexType: types.LocationsIndex})
+	addDocsForQuery(&indexer)
+
+	// 单个搜索键
+	query := leaf("token1")
+	utils.Expect(t, "[7 0 [0]] [3 0 [0]] [2 0 [0]] ",
+		indexedDocsToString(indexer.LookupQuery(&query, nil, false)))
+
+	// AND与Lookup一致
+	query = types.Query{Must: []types.Query{leaf("token1"), leaf("token2")}}
+	utils.Expect(t, "[3 1 [0 7]] [2 1 [0 7]] ",
+		indexedDocsToString(indexer.LookupQuery(&query, nil, false)))
+
+	// OR，未出现的关键词位置为-1
+	query = types.Query{Should: []types.Query{leaf("token1"), leaf("token4"), leaf("token3")}}
+	utils.Expect(t, "[9 0 [-1 -1 0]] [7 1 [0 -1 7]] [3 0 [0 -1 -1]] [2 8 [0 -1 14]] [1 0 [-1 -1 7]] ",
+		indexedDocsToString(indexer.LookupQuery(&query, nil, false)))
+
+	// token2 AND (token1 OR token3) NOT token3
+	query = types.Query{
+		Must: []types.Query{
+			leaf("token2"),
+			{Should: []types.Query{leaf("token1"), leaf("token3")}},
+		},
+		MustNot: []types.Query{leaf("token3")},
+	}
+	utils.Expect(t, "[3 13 [7 0 -1]] ",
+		indexedDocsToString(indexer.LookupQuery(&query, nil, false)))
+
+	// 只有NOT
+	query = types.Query{MustNot: []types.Query{leaf("token2")}}
+	utils.Expect(t, "[9 0 []] [7 0 []] ",
+		indexedDocsToString(indexer.LookupQuery(&query, nil, false)))
+
+	// 不存在的搜索键
+	query = types.Query{Must: []types.Query{leaf("token1"), leaf("token4")}}
+	utils.Expect(t, "", indexedDocsToString(indexer.LookupQuery(&query, nil, false)))
+
+	// 在指定文档中查找和仅计数
+	query = types.Query{Should: []types.Query{leaf("token1"), leaf("token2")}}
+	docIDs := map[uint64]bool{1: true, 7: true, 9: true}
+	utils.Expect(t, "[7 0 [0 -1]] [1 0 [-1 0]] ",
+		indexedDocsToString(indexer.LookupQuery(&query, docIDs, false)))
+	_, numDocs := indexer.LookupQuery(&query, nil, true)
+	utils.Expect(t, "5", numDocs)
+
+	// 删除的文档不再返回
+	indexer.RemoveDocumentToCache(3, true)
+	utils.Expect(t, "[7 0 [0 -1]] [4 0 [-1 0]] [2 1 [0 7]] [1 0 [-1 0]] ",
+		indexedDocsToString(indexer.LookupQuery(&query, nil, false)))
+}
+
+func TestLookupQueryWithPhrase(t *testing.T) {
+	var indexer Indexer
+	indexer.Init(types.IndexerInitOptions{IndexType: types.LocationsIndex})
+	// doc1 = "t1 t2 t3"
+	indexer.AddDocumentToCache(&types.DocumentIndex{
+		DocID: 1,
+		Keywords: []types.KeywordIndex{
+			{Text: "t1", Frequency: 1, Starts: []int{0}},
+			{Text: "t2", Frequency: 1, Starts: []int{3}},
+			{Text: "t3", Frequency: 1, Starts: []int{6}},
+		},
+	}, false)
+	// doc2 = "t2t1 . t3"
+	indexer.AddDocumentToCache(&types.DocumentIndex{
+		DocID: 2,
+		Keywords: []types.KeywordIndex{
+			{Text: "t1", Frequency: 1, Starts: []int{2}},
+			{Text: "t2", Frequency: 1, Starts: []int{0}},
+			{Text: "t3", Frequency: 1, Starts: []int{7}},
+		},
+	}, false)
+	// doc3 = "t1t2t3"
+	indexer.AddDocumentToCache(&types.DocumentIndex{
+		DocID: 3,
+		Keywords: []types.KeywordIndex{
+			{Text: "t1", Frequency: 1, Starts: []int{0}},
+			{Text: "t2", Frequency: 1, Starts: []int{2}},
+			{Text: "t3", Frequency: 1, Starts: []int{4}},
+		},
+	}, true)
+
+	query := types.Query{Phrase: []string{"t1", "t2"}}
+	utils.Expect(t, "[3 0 [0 2]] ", indexedDocsToString(indexer.LookupQuery(&query, nil, false)))
+	query = types.Query{Phrase: []string{"t2", "t1"}}
+	utils.Expect(t, "[2 0 [0 2]] ", indexedDocsToString(indexer.LookupQuery(&query, nil, false)))
+	query = types.Query{Phrase: []string{"t1", "t2", "t3"}, Slop: 1}
+	utils.Expect(t, "[3 0 [0 2 4]] [1 2 [0 3 6]] ", indexedDocsToString(indexer.LookupQuery(&query, nil, false)))
+	query = types.Query{Phrase: []string{"t3", "t2"}, Window: 5}
+	utils.Expect(t, "[3 4 [4 2]] [1 5 [6 3]] ", indexedDocsToString(indexer.LookupQuery(&query, nil, false)))
+	query = types.Query{Phrase: []string{"t3", "t2"}, Window: 9}
+	utils.Expect(t, "[3 4 [4 2]] [2 9 [7 0]] [1 5 [6 3]] ",
+		indexedDocsToString(indexer.LookupQuery(&query, nil, false)))
+
+	// 以关键词个数为单位时空白不计入间隔
+	query = types.Query{Phrase: []string{"t1", "t2", "t3"}, InTokens: true}
+	utils.Expect(t, "[3 0 [0 2 4]] [1 2 [0 3 6]] ", indexedDocsToString(indexer.LookupQuery(&query, nil, false)))
+	query = types.Query{Phrase: []string{"t3", "t1"}, Window: 2, InTokens: true}
+	utils.Expect(t, "[2 7 [7 2]] ", indexedDocsToString(indexer.LookupQuery(&query, nil, false)))
+
+	// 短语作为布尔查询的子句
+	query = types.Query{
+		Must:    []types.Query{leaf("t3")},
+		MustNot: []types.Query{{Phrase: []string{"t1", "t2"}, Slop: 1}},
+	}
+	utils.Expect(t, "[2 0 [7]] ", indexedDocsToString(indexer.LookupQuery(&query, nil, false)))
+}
+
+func TestMatchPhrase(t *testing.T) {
+	tokens := []string{"ab", "c", "de"}
+	utils.Expect(t, "true", matchPhrase([][]int{{0}, {2}, {3}}, nil, nil, tokens, 0, 0))
+	utils.Expect(t, "false", matchPhrase([][]int{{0}, {3}, {4}}, nil, nil, tokens, 0, 0))
+	utils.Expect(t, "true", matchPhrase([][]int{{0}, {3}, {4}}, nil, nil, tokens, 1, 0))
+	// 需要选择后面的出现位置才能满足约束
+	utils.Expect(t, "true", matchPhrase([][]int{{0, 10}, {2, 12}, {13}}, nil, nil, tokens, 0, 0))
+	utils.Expect(t, "false", matchPhrase([][]int{{0}, {}, {3}}, nil, nil, tokens, 0, 0))
+
+	utils.Expect(t, "true", matchPhrase([][]int{{4}, {0}, {1}}, nil, nil, tokens, 0, 6))
+	utils.Expect(t, "false", matchPhrase([][]int{{4}, {0}, {1}}, nil, nil, tokens, 0, 5))
+	utils.Expect(t, "true", matchPhrase([][]int{{4, 20}, {0, 30}, {22}}, nil, nil, tokens, 0, 11))
+
+	// 原文长度与搜索键不同时按记录的结束位置计算间隔
+	ends := [][]int{{6}, nil, {9}}
+	utils.Expect(t, "true", matchPhrase([][]int{{0}, {6}, {7}}, ends, nil, tokens, 0, 0))
+	utils.Expect(t, "false", matchPhrase([][]int{{0}, {2}, {3}}, ends, nil, tokens, 0, 0))
+	utils.Expect(t, "true", matchPhrase([][]int{{0}, {6}, {7}}, ends, nil, tokens, 0, 9))
+	utils.Expect(t, "false", matchPhrase([][]int{{0}, {6}, {7}}, ends, nil, tokens, 0, 8))
+
+	// 以关键词个数为单位，文本为"ab x c  de"，中间夹杂的关键词x和空白
+	starts := []int{0, 3, 5, 8}
+	locations := [][]int{{0}, {5}, {8}}
+	utils.Expect(t, "false", matchPhrase(locations, nil, starts, tokens, 0, 0))
+	utils.Expect(t, "true", matchPhrase(locations, nil, starts, tokens, 1, 0))
+	utils.Expect(t, "false", matchPhrase(locations, nil, nil, tokens, 1, 0))
+	utils.Expect(t, "true", matchPhrase(locations, nil, starts, tokens, 0, 4))
+	utils.Expect(t, "false", matchPhrase(locations, nil, starts, tokens, 0, 3))
+}
+
+func TestLookupQueryWithBM25(t *testing.T) {
+	var indexer Indexer
+	indexer.Init(types.IndexerInitOptions{
+		IndexType: types.FrequenciesIndex,
+		BM25Parameters: &types.BM25Parameters{
+			K1: 1,
+			B:  1,
+		},
+	})
+	indexer.AddDocumentToCache(&types.DocumentIndex{
+		DocID:       1,
+		TokenLength: 6,
+		Keywords: []types.KeywordIndex{
+			{Text: "token2", Frequency: 3},
+			{Text: "token3", Frequency: 7},
+			{Text: "token4", Frequency: 15},
+		},
+	}, false)
+	indexer.AddDocumentToCache(&types.DocumentIndex{
+		DocID:       2,
+		TokenLength: 2,
+		Keywords: []types.KeywordIndex{
+			{Text: "token6", Frequency: 3},
+			{Text: "token7", Frequency: 15},
+		},
+	}, true)
+
+	// 与TestLookupWithBM25的AND查询结果一致
+	query := types.Query{Must: []types.Query{leaf("token2"), leaf("token3"), leaf("token4")}}
+	outputs, _ := indexer.LookupQuery(&query, nil, false)
+	utils.Expect(t, "76055", int(outputs[0].BM25*10000))
+
+	// OR查询只累加文档中出现的关键词
+	query = types.Query{Should: []types.Query{leaf("token2"), leaf("token6")}}
+	outputs, _ = indexer.LookupQuery(&query, nil, false)
+	utils.Expect(t, "2", len(outputs))
+	utils.Expect(t, "2", outputs[0].DocID)
+	utils.Expect(t, "1", outputs[1].DocID)
+}
+
+func TestDocIDsSetOperations(t *testing.T) {
+	a := []uint64{1, 3, 5, 7, 9}
+	b := []uint64{2, 3, 4, 9}
+	utils.Expect(t, "[3 9]", intersectDocIDs(a, b))
+	utils.Expect(t, "[1 2 3 4 5 7 9]", unionDocIDs(a, b))
+	utils.Expect(t, "[1 5 7]", differenceDocIDs(a, b))
+	utils.Expect(t, "[2 4]", differenceDocIDs(b, a))
+
+	// 长度悬殊时走二分查找
+	long := []uint64{}
+	for i := uint64(0); i < 100; i++ {
+		long = append(long, i*2)
+	}
+	utils.Expect(t, "[4 98 198]", intersectDocIDs([]uint64{3, 4, 98, 198, 199}, long))
+	utils.Expect(t, "[]", intersectDocIDs([]uint64{}, long))
+}
@@ -1,7 +1,7 @@
 package core
 
 import (
-	"sort"
+	"context"
 	"sync"
 
 	"github.com/pickjunk/wuneng/types"
@@ -18,52 +18,100 @@ type Ranker struct {
 	initialized bool
 }
 
-// Init 初始化排序器
+// Init 初始化排序器，重复初始化时panic
 func (ranker *Ranker) Init() {
+	if err := ranker.TryInit(); err != nil {
+		log.Panic().Err(err).Msg("排序器不能初始化两次")
+	}
+}
+
+// TryInit 初始化排序器，重复初始化时返回ErrAlreadyInitialized
+func (ranker *Ranker) TryInit() error {
 	if ranker.initialized == true {
-		log.Panic().Msg("排序器不能初始化两次")
+		return ErrAlreadyInitialized
 	}
 	ranker.initialized = true
 
 	ranker.lock.fields = make(map[uint64]interface{})
 	ranker.lock.docs = make(map[uint64]bool)
+	return nil
 }
 
-// AddDoc 给某个文档添加评分字段
-func (ranker *Ranker) AddDoc(docID uint64, fields interface{}) {
+// AddDoc 给某个文档添加评分字段，排序器未初始化时返回ErrNotInitialized
+func (ranker *Ranker) AddDoc(docID uint64, fields interface{}) error {
 	if ranker.initialized == false {
-		log.Panic().Msg("排序器尚未初始化")
+		return ErrNotInitialized
 	}
 
 	ranker.lock.Lock()
 	ranker.lock.fields[docID] = fields
 	ranker.lock.docs[docID] = true
 	ranker.lock.Unlock()
+	return nil
 }
 
-// RemoveDoc 删除某个文档的评分字段
-func (ranker *Ranker) RemoveDoc(docID uint64) {
+// RemoveDoc 删除某个文档的评分字段，排序器未初始化时返回ErrNotInitialized
+func (ranker *Ranker) RemoveDoc(docID uint64) error {
 	if ranker.initialized == false {
-		log.Panic().Msg("排序器尚未初始化")
+		return ErrNotInitialized
 	}
 
 	ranker.lock.Lock()
 	delete(ranker.lock.fields, docID)
 	delete(ranker.lock.docs, docID)
 	ranker.lock.Unlock()
+	return nil
+}
+
+// HasDoc 排序器中是否有该文档，没有的文档在Rank中被剔除
+func (ranker *Ranker) HasDoc(docID uint64) bool {
+	ranker.lock.RLock()
+	defer ranker.lock.RUnlock()
+	_, found := ranker.lock.docs[docID]
+	return found
 }
 
-// Rank 给文档评分并排序
+// Rank 给文档评分并排序，排序规则见types.SortScoredDocuments
 func (ranker *Ranker) Rank(
 	docs []types.IndexedDocument, options types.RankOptions, countDocsOnly bool) (types.ScoredDocuments, int) {
+	outputDocs, numDocs, _ := ranker.RankContext(context.Background(), docs, options, countDocsOnly)
+	return outputDocs, numDocs
+}
+
+// RankContext 与Rank相同，但ctx取消时停止评分，返回已评分部分的排序结果和ctx.Err()，
+// 排序器未初始化时返回ErrNotInitialized
+func (ranker *Ranker) RankContext(ctx context.Context,
+	docs []types.IndexedDocument, options types.RankOptions, countDocsOnly bool) (
+	types.ScoredDocuments, int, error) {
+	return ranker.RankContextFunc(ctx, docs, options, countDocsOnly, nil)
+}
+
+// RankContextFunc 与RankContext相同，并对每个计入numDocs的文档调用counted（可以为nil），
+// 用于统计与numDocs基于同一组文档的信息，如标签统计
+func (ranker *Ranker) RankContextFunc(ctx context.Context,
+	docs []types.IndexedDocument, options types.RankOptions, countDocsOnly bool,
+	counted func(doc types.IndexedDocument)) (types.ScoredDocuments, int, error) {
 	if ranker.initialized == false {
-		log.Panic().Msg("排序器尚未初始化")
+		return nil, 0, ErrNotInitialized
+	}
+
+	// 只需要前k个文档时用有界堆保留，不必对全部文档排序
+	k := 0
+	if options.MaxOutputs > 0 && !countDocsOnly {
+		k = options.OutputOffset + options.MaxOutputs
 	}
+	topK := scoredDocumentHeap{options: options}
 
 	// 对每个文档评分
 	var outputDocs types.ScoredDocuments
+	var err error
 	numDocs := 0
-	for _, d := range docs {
+	for i, d := range docs {
+		if (i+1)%contextCheckInterval == 0 {
+			if err = ctx.Err(); err != nil {
+				break
+			}
+		}
 		ranker.lock.RLock()
 		// 判断doc是否存在
 		if _, ok := ranker.lock.docs[d.DocID]; ok {
@@ -73,13 +121,29 @@ func (ranker *Ranker) Rank(
 			scores := options.ScoringCriteria.Score(d, fs)
 			if len(scores) > 0 {
 				if !countDocsOnly {
-					outputDocs = append(outputDocs, types.ScoredDocument{
+					doc := types.ScoredDocument{
 						DocID:                 d.DocID,
 						Scores:                scores,
 						TokenSnippetLocations: d.TokenSnippetLocations,
-						TokenLocations:        d.TokenLocations})
+						TokenLocations:        d.TokenLocations,
+						TokenEnds:             d.TokenEnds}
+					if len(options.SortBy) > 0 {
+						doc.SortValues = sortValues(fs, options.SortBy)
+					}
+					// 翻页时只保留排在游标之后的文档，但仍计入文档总数
+					if options.SearchAfter == nil ||
+						types.CompareScoredDocuments(doc, *options.SearchAfter, options) > 0 {
+						if k > 0 {
+							topK.offer(doc, k)
+						} else {
+							outputDocs = append(outputDocs, doc)
+						}
+					}
 				}
 				numDocs++
+				if counted != nil {
+					counted(d)
+				}
 			}
 		} else {
 			ranker.lock.RUnlock()
@@ -88,11 +152,10 @@ func (ranker *Ranker) Rank(
 
 	// 排序
 	if !countDocsOnly {
-		if options.ReverseOrder {
-			sort.Sort(sort.Reverse(outputDocs))
-		} else {
-			sort.Sort(outputDocs)
+		if k > 0 {
+			outputDocs = topK.docs
 		}
+		types.SortScoredDocuments(outputDocs, options)
 		// 当用户要求只返回部分结果时返回部分结果
 		var start, end int
 		if options.MaxOutputs != 0 {
@@ -102,7 +165,7 @@ func (ranker *Ranker) Rank(
 			start = utils.MinInt(options.OutputOffset, len(outputDocs))
 			end = len(outputDocs)
 		}
-		return outputDocs[start:end], numDocs
+		return outputDocs[start:end], numDocs, err
 	}
-	return outputDocs, numDocs
+	return outputDocs, numDocs, err
 }
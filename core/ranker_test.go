@@ -1,8 +1,11 @@
 package core
 
 import (
+	"fmt"
+	"math/rand"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/pickjunk/wuneng/types"
 	"github.com/pickjunk/wuneng/utils"
@@ -127,3 +130,149 @@ func TestRemoveDoc(t *testing.T) {
 	}, types.RankOptions{ScoringCriteria: criteria}, false)
 	utils.Expect(t, "[1 [25300 ]] [2 [3000 ]] ", scoredDocsToString(scoredDocs))
 }
+
+type sortValuerFields map[string]interface{}
+
+func (fields sortValuerFields) SortValue(field string) interface{} {
+	return fields[field]
+}
+
+func TestRankSortBy(t *testing.T) {
+	type fields struct {
+		Category string
+		Time     time.Time
+		Price    float32
+		Stock    uint
+	}
+	base := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	var ranker Ranker
+	ranker.Init()
+	ranker.AddDoc(1, fields{"b", base.Add(time.Second), 9.5, 3})
+	ranker.AddDoc(2, &fields{"a", base.Add(time.Nanosecond), 1, 3})
+	ranker.AddDoc(3, fields{"b", base.Add(time.Hour), 20, 0})
+	ranker.AddDoc(4, map[string]interface{}{"Category": "a", "Price": 5})
+	ranker.AddDoc(5, nil)
+	ranker.AddDoc(6, sortValuerFields{"Category": "c", "Price": 100.0})
+
+	docs := []types.IndexedDocument{
+		{DocID: 1, BM25: 1}, {DocID: 2, BM25: 2}, {DocID: 3, BM25: 3},
+		{DocID: 4, BM25: 4}, {DocID: 5, BM25: 5}, {DocID: 6, BM25: 6},
+	}
+	sortedIDs := func(sortBy []types.SortField, reverse bool) (output string) {
+		scoredDocs, _ := ranker.Rank(docs, types.RankOptions{
+			ScoringCriteria: types.RankByBM25{},
+			SortBy:          sortBy,
+			ReverseOrder:    reverse,
+		}, false)
+		for _, doc := range scoredDocs {
+			output += fmt.Sprintf("%d ", doc.DocID)
+		}
+		return
+	}
+
+	// 纳秒精度的时间，没有该键的文档排在最后
+	utils.Expect(t, "3 1 2 4 5 6 ", sortedIDs([]types.SortField{{Field: "Time"}}, true))
+	utils.Expect(t, "2 1 3 6 5 4 ", sortedIDs([]types.SortField{{Field: "Time", Order: types.Ascending}}, false))
+
+	// 多个键，键值相同时按评分
+	utils.Expect(t, "4 2 3 1 6 5 ", sortedIDs([]types.SortField{
+		{Field: "Category", Order: types.Ascending}, {Field: "Price"}}, false))
+	utils.Expect(t, "2 4 1 3 6 5 ", sortedIDs([]types.SortField{
+		{Field: "Category", Order: types.Ascending}}, true))
+	utils.Expect(t, "6 3 1 4 2 5 ", sortedIDs([]types.SortField{{Field: "Price"}}, false))
+	utils.Expect(t, "2 1 3 6 5 4 ", sortedIDs([]types.SortField{{Field: "Stock"}}, false))
+
+	scoredDocs, _ := ranker.Rank(docs[:1], types.RankOptions{
+		ScoringCriteria: types.RankByBM25{},
+		SortBy:          []types.SortField{{Field: "Price"}, {Field: "Stock"}, {Field: "Missing"}},
+	}, false)
+	utils.Expect(t, "[9.5 3 <nil>]", scoredDocs[0].SortValues)
+}
+
+func TestRankSearchAfter(t *testing.T) {
+	var ranker Ranker
+	ranker.Init()
+	docs := []types.IndexedDocument{}
+	for docID := uint64(1); docID <= 6; docID++ {
+		ranker.AddDoc(docID, nil)
+		// 文档1、2和3、4评分相同
+		docs = append(docs, types.IndexedDocument{DocID: docID, BM25: float32((docID + 1) / 2)})
+	}
+
+	options := types.RankOptions{ScoringCriteria: types.RankByBM25{}, MaxOutputs: 2}
+	scoredDocs, numDocs := ranker.Rank(docs, options, false)
+	utils.Expect(t, "[6 [3000 ]] [5 [3000 ]] ", scoredDocsToString(scoredDocs))
+
+	options.SearchAfter = &scoredDocs[1]
+	scoredDocs, numDocs = ranker.Rank(docs, options, false)
+	utils.Expect(t, "[4 [2000 ]] [3 [2000 ]] ", scoredDocsToString(scoredDocs))
+	utils.Expect(t, "6", numDocs)
+
+	options.SearchAfter = &types.ScoredDocument{DocID: 2, Scores: []float32{1}}
+	scoredDocs, _ = ranker.Rank(docs, options, false)
+	utils.Expect(t, "[1 [1000 ]] ", scoredDocsToString(scoredDocs))
+
+	options.ReverseOrder = true
+	scoredDocs, _ = ranker.Rank(docs, options, false)
+	utils.Expect(t, "[3 [2000 ]] [4 [2000 ]] ", scoredDocsToString(scoredDocs))
+}
+
+func randomRankedDocs(ranker *Ranker, n int) []types.IndexedDocument {
+	r := rand.New(rand.NewSource(1))
+	docs := make([]types.IndexedDocument, n)
+	for i := range docs {
+		docID := uint64(i + 1)
+		ranker.AddDoc(docID, nil)
+		// 评分有大量重复，依赖DocID决定顺序
+		docs[i] = types.IndexedDocument{DocID: docID, BM25: float32(r.Intn(n / 10))}
+	}
+	return docs
+}
+
+func TestRankTopK(t *testing.T) {
+	var ranker Ranker
+	ranker.Init()
+	docs := randomRankedDocs(&ranker, 1000)
+
+	for _, options := range []types.RankOptions{
+		{MaxOutputs: 10},
+		{MaxOutputs: 10, OutputOffset: 95},
+		{MaxOutputs: 30, OutputOffset: 990},
+		{MaxOutputs: 7, ReverseOrder: true},
+	} {
+		options.ScoringCriteria = types.RankByBM25{}
+		topK, numDocs := ranker.Rank(docs, options, false)
+		utils.Expect(t, "1000", numDocs)
+
+		all := options
+		all.MaxOutputs, all.OutputOffset = 0, 0
+		expected, _ := ranker.Rank(docs, all, false)
+		end := utils.MinInt(options.OutputOffset+options.MaxOutputs, len(expected))
+		utils.Expect(t, scoredDocsToString(expected[options.OutputOffset:end]), scoredDocsToString(topK))
+	}
+}
+
+// 10万个文档取前10个，fullSort时对全部文档排序后再截取，即有界堆之前的做法
+func benchmarkRank(b *testing.B, fullSort bool) {
+	var ranker Ranker
+	ranker.Init()
+	docs := randomRankedDocs(&ranker, 100000)
+	options := types.RankOptions{ScoringCriteria: types.RankByBM25{}, MaxOutputs: 10}
+	if fullSort {
+		options.MaxOutputs = 0
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		scoredDocs, _ := ranker.Rank(docs, options, false)
+		_ = scoredDocs[:10]
+	}
+}
+
+func BenchmarkRankTopK(b *testing.B) {
+	benchmarkRank(b, false)
+}
+
+func BenchmarkRankFullSort(b *testing.B) {
+	benchmarkRank(b, true)
+}
@@ -0,0 +1,350 @@
+package core
+
+import (
+	"sort"
+	"sync/atomic"
+
+	"github.com/pickjunk/wuneng/types"
+)
+
+// 段数超过这个值时在后台合并较小的段
+const maxSegments = 8
+
+// 索引段，一批文档的反向索引表
+//
+// 段建成后只读，删除文档时只在段上记录墓碑，合并段时才真正去掉被删除的索引项。
+// 同一文档最多在一个段中没有墓碑
+type segment struct {
+	table map[string]*KeywordIndices
+
+	// 段中全部文档的DocID，升序
+	docIDs []uint64
+
+	// 全部搜索键，升序，用于前缀查找
+	terms []string
+
+	// 墓碑，deletedLog按记录顺序保存同样的DocID，只追加不修改，
+	// 合并时据此找出合并期间新增的墓碑。修改时须持有tableLock写锁
+	deleted    map[uint64]bool
+	deletedLog []uint64
+}
+
+// 建立或合并段后对搜索键排序
+func (seg *segment) sortTerms() {
+	seg.terms = make([]string, 0, len(seg.table))
+	for term := range seg.table {
+		seg.terms = append(seg.terms, term)
+	}
+	sort.Strings(seg.terms)
+}
+
+func (seg *segment) contains(docID uint64) bool {
+	i := sort.Search(len(seg.docIDs), func(i int) bool { return seg.docIDs[i] >= docID })
+	return i < len(seg.docIDs) && seg.docIDs[i] == docID
+}
+
+// 为段中的文档记录墓碑，调用者须持有tableLock写锁
+func (seg *segment) tombstone(docID uint64) {
+	if seg.deleted[docID] || !seg.contains(docID) {
+		return
+	}
+	seg.deleted[docID] = true
+	seg.deletedLog = append(seg.deletedLog, docID)
+}
+
+// 用一批按DocID升序排列且不重复的文档建立新段
+func (indexer *Indexer) buildSegment(documents []*types.DocumentIndex) *segment {
+	seg := &segment{
+		table:   make(map[string]*KeywordIndices),
+		docIDs:  make([]uint64, 0, len(documents)),
+		deleted: make(map[uint64]bool),
+	}
+	for _, document := range documents {
+		seg.docIDs = append(seg.docIDs, document.DocID)
+		for _, keyword := range document.Keywords {
+			indices, found := seg.table[keyword.Text]
+			if !found {
+				indices = &KeywordIndices{
+					maxFrequency: indexer.keywordFrequency(keyword),
+					minDocLength: document.TokenLength,
+				}
+				seg.table[keyword.Text] = indices
+			} else {
+				indices.updateBounds(indexer.keywordFrequency(keyword), document.TokenLength)
+			}
+			// 文档按DocID升序加入，只需追加
+			indices.docIDs = append(indices.docIDs, document.DocID)
+			switch indexer.initOptions.IndexType {
+			case types.LocationsIndex:
+				indices.locations = append(indices.locations, keyword.Starts)
+				indices.ends = append(indices.ends, keyword.Ends)
+			case types.FrequenciesIndex:
+				indices.frequencies = append(indices.frequencies, keyword.Frequency)
+			}
+		}
+	}
+	for _, indices := range seg.table {
+		indexer.packIndices(indices)
+	}
+	seg.sortTerms()
+	return seg
+}
+
+// 合并多个段，去掉deleted中的文档，同一文档出现在多个段中时保留排在后面的段中的
+// 只读取各段，调用者无需持有锁
+func (indexer *Indexer) mergeSegmentList(sources []*segment, deleted []map[uint64]bool) *segment {
+	merged := &segment{table: make(map[string]*KeywordIndices), deleted: make(map[uint64]bool)}
+
+	keywords := make(map[string]bool)
+	for i, seg := range sources {
+		live := postingList{}
+		for _, docID := range seg.docIDs {
+			if !deleted[i][docID] {
+				live.docIDs = append(live.docIDs, docID)
+			}
+		}
+		merged.docIDs = mergePostingLists(postingList{docIDs: merged.docIDs}, live, types.DocIDsIndex).docIDs
+		for keyword := range seg.table {
+			keywords[keyword] = true
+		}
+	}
+
+	for keyword := range keywords {
+		var result postingList
+		mergedIndices := &KeywordIndices{}
+		first := true
+		for i, seg := range sources {
+			indices, found := seg.table[keyword]
+			if !found {
+				continue
+			}
+			list := indices.postings()
+			if len(deleted[i]) > 0 {
+				list = list.filter(deleted[i], indexer.initOptions.IndexType)
+			}
+			result = mergePostingLists(result, list, indexer.initOptions.IndexType)
+
+			// 上界取各段上界的最值，仍是上界
+			if first || indices.maxFrequency > mergedIndices.maxFrequency {
+				mergedIndices.maxFrequency = indices.maxFrequency
+			}
+			if first || indices.minDocLength < mergedIndices.minDocLength {
+				mergedIndices.minDocLength = indices.minDocLength
+			}
+			first = false
+		}
+		if len(result.docIDs) == 0 {
+			continue
+		}
+		mergedIndices.docIDs, mergedIndices.frequencies, mergedIndices.locations, mergedIndices.ends =
+			result.docIDs, result.frequencies, result.locations, result.ends
+		indexer.packIndices(mergedIndices)
+		merged.table[keyword] = mergedIndices
+	}
+	merged.sortTerms()
+	return merged
+}
+
+// MergeSegments 将全部索引段合并为一个，去掉已删除文档的索引项
+// 段数过多时索引器会在后台自动合并，通常无需调用。索引器未初始化时返回ErrNotInitialized
+func (indexer *Indexer) MergeSegments() error {
+	if indexer.initialized == false {
+		return ErrNotInitialized
+	}
+	indexer.mergeSegments(true)
+	return nil
+}
+
+// 合并段，all为false时按pickSegments选出要合并的段
+// 合并过程中不持有tableLock，只在替换段时短暂持有写锁
+func (indexer *Indexer) mergeSegments(all bool) {
+	indexer.mergeLock.Lock()
+	defer indexer.mergeLock.Unlock()
+
+	indexer.tableLock.RLock()
+	sources := indexer.pickSegments(all)
+	logs := make([][]uint64, len(sources))
+	for i, seg := range sources {
+		logs[i] = seg.deletedLog
+	}
+	indexer.tableLock.RUnlock()
+	if len(sources) == 0 {
+		return
+	}
+
+	// deletedLog只追加，已取出的部分不会再被修改
+	deleted := make([]map[uint64]bool, len(sources))
+	for i, deletedLog := range logs {
+		deleted[i] = make(map[uint64]bool, len(deletedLog))
+		for _, docID := range deletedLog {
+			deleted[i][docID] = true
+		}
+	}
+	merged := indexer.mergeSegmentList(sources, deleted)
+
+	indexer.tableLock.Lock()
+	defer indexer.tableLock.Unlock()
+	// 补上合并期间新增的墓碑
+	for i, seg := range sources {
+		for _, docID := range seg.deletedLog[len(logs[i]):] {
+			merged.tombstone(docID)
+		}
+	}
+	isSource := make(map[*segment]bool, len(sources))
+	for _, seg := range sources {
+		isSource[seg] = true
+	}
+	segments := make([]*segment, 0, len(indexer.tableLock.segments)-len(sources)+1)
+	inserted := false
+	for _, seg := range indexer.tableLock.segments {
+		if !isSource[seg] {
+			segments = append(segments, seg)
+		} else if !inserted {
+			// 合并后的段放在最早的源段的位置
+			if len(merged.docIDs) > 0 {
+				segments = append(segments, merged)
+			}
+			inserted = true
+		}
+	}
+	indexer.tableLock.segments = segments
+}
+
+// 选出要合并的段，调用者须持有tableLock读锁
+// 段数超过maxSegments时合并最小的若干个段，使段数降到一半；另外合并墓碑超过一半的段
+func (indexer *Indexer) pickSegments(all bool) []*segment {
+	segments := indexer.tableLock.segments
+	if all {
+		if len(segments) == 1 && len(segments[0].deletedLog) == 0 {
+			return nil
+		}
+		return segments
+	}
+
+	var picked []*segment
+	rest := make([]*segment, 0, len(segments))
+	for _, seg := range segments {
+		if len(seg.deletedLog)*2 > len(seg.docIDs) {
+			picked = append(picked, seg)
+		} else {
+			rest = append(rest, seg)
+		}
+	}
+	if len(segments) > maxSegments {
+		sort.SliceStable(rest, func(i, j int) bool { return len(rest[i].docIDs) < len(rest[j].docIDs) })
+		n := len(segments) - maxSegments/2 + 1 - len(picked)
+		if n > len(rest) {
+			n = len(rest)
+		}
+		if n > 0 {
+			picked = append(picked, rest[:n]...)
+		}
+	}
+	if len(picked) == 1 && len(picked[0].deletedLog) == 0 {
+		return nil
+	}
+	return picked
+}
+
+// 需要时在后台合并段，同一时间最多一个后台合并，调用者须持有tableLock写锁
+// 后台合并一直进行到没有可选的段为止，并在写锁下清除merging，因此合并期间的写入不会漏掉合并
+func (indexer *Indexer) scheduleMerge() {
+	if len(indexer.pickSegments(false)) == 0 {
+		return
+	}
+	if atomic.CompareAndSwapInt32(&indexer.merging, 0, 1) {
+		go func() {
+			for {
+				indexer.mergeSegments(false)
+				indexer.tableLock.Lock()
+				if len(indexer.pickSegments(false)) == 0 {
+					atomic.StoreInt32(&indexer.merging, 0)
+					indexer.tableLock.Unlock()
+					return
+				}
+				indexer.tableLock.Unlock()
+			}
+		}()
+	}
+}
+
+// 各搜索键在全部段中的文档数，包括尚未合并掉的已删除文档，调用者须持有tableLock读锁
+func (indexer *Indexer) documentFrequency(keyword string) int {
+	df := 0
+	for _, seg := range indexer.tableLock.segments {
+		if indices, found := seg.table[keyword]; found {
+			df += indexer.getIndexLength(indices)
+		}
+	}
+	return df
+}
+
+// 未压缩的倒排表
+type postingList struct {
+	docIDs      []uint64
+	frequencies []float32
+	locations   [][]int
+	ends        [][]int
+}
+
+// 返回全部索引项，调用者不可修改
+func (indices *KeywordIndices) postings() postingList {
+	if indices.packed != nil {
+		docIDs, frequencies, locations, ends := indices.packed.unpack()
+		return postingList{docIDs, frequencies, locations, ends}
+	}
+	return postingList{indices.docIDs, indices.frequencies, indices.locations, indices.ends}
+}
+
+// 去掉deleted中的文档
+func (list postingList) filter(deleted map[uint64]bool, indexType int) postingList {
+	var result postingList
+	for i, docID := range list.docIDs {
+		if !deleted[docID] {
+			result.append(list, i, indexType)
+		}
+	}
+	return result
+}
+
+func (list *postingList) append(from postingList, i int, indexType int) {
+	list.docIDs = append(list.docIDs, from.docIDs[i])
+	switch indexType {
+	case types.LocationsIndex:
+		list.locations = append(list.locations, from.locations[i])
+		if from.ends != nil {
+			list.ends = append(list.ends, from.ends[i])
+		} else {
+			list.ends = append(list.ends, nil)
+		}
+	case types.FrequenciesIndex:
+		list.frequencies = append(list.frequencies, from.frequencies[i])
+	}
+}
+
+// 归并两个升序的倒排表，DocID相同时保留b中的索引项
+func mergePostingLists(a, b postingList, indexType int) postingList {
+	if len(a.docIDs) == 0 {
+		return b
+	}
+	if len(b.docIDs) == 0 {
+		return a
+	}
+	var result postingList
+	i, j := 0, 0
+	for i < len(a.docIDs) || j < len(b.docIDs) {
+		switch {
+		case j == len(b.docIDs) || (i < len(a.docIDs) && a.docIDs[i] < b.docIDs[j]):
+			result.append(a, i, indexType)
+			i++
+		case i == len(a.docIDs) || b.docIDs[j] < a.docIDs[i]:
+			result.append(b, j, indexType)
+			j++
+		default:
+			result.append(b, j, indexType)
+			i++
+			j++
+		}
+	}
+	return result
+}
@@ -0,0 +1,115 @@
+package core
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pickjunk/wuneng/types"
+	"github.com/pickjunk/wuneng/utils"
+)
+
+func TestSegments(t *testing.T) {
+	var indexer Indexer
+	indexer.Init(types.IndexerInitOptions{IndexType: types.LocationsIndex})
+
+	// 每次强制刷新建立一个段，每段两个文档，删除其中一个不会触发后台合并
+	for docID := uint64(1); docID <= 8; docID += 2 {
+		for _, id := range []uint64{docID, docID + 1} {
+			indexer.AddDocumentToCache(&types.DocumentIndex{
+				DocID:    id,
+				Keywords: []types.KeywordIndex{{Text: "token", Starts: []int{int(id)}}},
+			}, false)
+		}
+		indexer.AddDocumentToCache(nil, true)
+	}
+	utils.Expect(t, "4", len(indexer.tableLock.segments))
+
+	// 删除和更新只记录墓碑
+	indexer.RemoveDocumentToCache(3, true)
+	indexer.AddDocumentToCache(&types.DocumentIndex{
+		DocID:    5,
+		Keywords: []types.KeywordIndex{{Text: "other", Starts: []int{0}}},
+	}, true)
+	utils.Expect(t, "5", len(indexer.tableLock.segments))
+	utils.Expect(t, "[3]", indexer.tableLock.segments[1].deletedLog)
+	utils.Expect(t, "[5]", indexer.tableLock.segments[2].deletedLog)
+	utils.Expect(t, "1 2 4 6 7 8 ", indicesToString(&indexer, "token"))
+	utils.Expect(t, "[8 0 [8]] [7 0 [7]] [6 0 [6]] [4 0 [4]] [2 0 [2]] [1 0 [1]] ",
+		indexedDocsToString(indexer.Lookup([]string{"token"}, nil, nil, false)))
+	utils.Expect(t, "[5 0 [0]] ", indexedDocsToString(indexer.Lookup([]string{"other"}, nil, nil, false)))
+	query := types.Query{MustNot: []types.Query{{Token: "other"}}}
+	docs, _ := indexer.LookupQuery(&query, nil, false)
+	utils.Expect(t, "[8 0 []] [7 0 []] [6 0 []] [4 0 []] [2 0 []] [1 0 []] ", indexedDocsToString(docs, 0))
+
+	// 合并后去掉已删除的索引项，查找结果不变
+	indexer.MergeSegments()
+	utils.Expect(t, "1", len(indexer.tableLock.segments))
+	utils.Expect(t, "[1 2 4 5 6 7 8]", indexer.tableLock.segments[0].docIDs)
+	utils.Expect(t, "[]", indexer.tableLock.segments[0].deletedLog)
+	utils.Expect(t, "1 2 4 6 7 8 ", indicesToString(&indexer, "token"))
+	utils.Expect(t, "[8 0 [8]] [7 0 [7]] [6 0 [6]] [4 0 [4]] [2 0 [2]] [1 0 [1]] ",
+		indexedDocsToString(indexer.Lookup([]string{"token"}, nil, nil, false)))
+	docs, _ = indexer.LookupQuery(&query, nil, false)
+	utils.Expect(t, "[8 0 []] [7 0 []] [6 0 []] [4 0 []] [2 0 []] [1 0 []] ", indexedDocsToString(docs, 0))
+}
+
+func TestBackgroundMerge(t *testing.T) {
+	var indexer Indexer
+	indexer.Init(types.IndexerInitOptions{IndexType: types.FrequenciesIndex})
+
+	// 写入的同时查找
+	var wg sync.WaitGroup
+	done := make(chan bool)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				indexer.Lookup([]string{"token"}, nil, nil, false)
+			}
+		}
+	}()
+
+	numFlushes := 5 * maxSegments
+	for i := 0; i < numFlushes; i++ {
+		docID := uint64(i + 1)
+		indexer.AddDocumentToCache(&types.DocumentIndex{
+			DocID:    docID,
+			Keywords: []types.KeywordIndex{{Text: "token", Frequency: 1}},
+		}, true)
+		if i%3 == 0 {
+			indexer.RemoveDocumentToCache(docID, true)
+		}
+	}
+	close(done)
+	wg.Wait()
+
+	// 后台合并最终使段数不超过maxSegments，且不再有可合并的段
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		indexer.tableLock.RLock()
+		numSegments := len(indexer.tableLock.segments)
+		numPicked := len(indexer.pickSegments(false))
+		indexer.tableLock.RUnlock()
+		if numSegments <= maxSegments && numPicked == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("段没有被合并：%d", numSegments)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	_, numDocs := indexer.Lookup([]string{"token"}, nil, nil, true)
+	utils.Expect(t, fmt.Sprint(numFlushes-(numFlushes+2)/3), numDocs)
+
+	// MergeSegments等待进行中的合并结束后合并全部段
+	indexer.MergeSegments()
+	utils.Expect(t, "1", len(indexer.tableLock.segments))
+	_, numDocs = indexer.Lookup([]string{"token"}, nil, nil, true)
+	utils.Expect(t, fmt.Sprint(numFlushes-(numFlushes+2)/3), numDocs)
+}
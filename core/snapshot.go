@@ -0,0 +1,494 @@
+package core
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/pickjunk/wuneng/types"
+)
+
+var (
+	// ErrSnapshotMismatch 快照与当前索引器的索引类型不一致
+	ErrSnapshotMismatch = errors.New("wuneng: 快照与索引器的索引类型不一致")
+
+	// ErrSnapshotFormat 快照被截断或已损坏
+	ErrSnapshotFormat = errors.New("wuneng: 快照格式错误")
+)
+
+// 快照中单个长度的上限，与预写日志的记录长度上限相同
+const maxSnapshotLength = 1 << 30
+
+// Snapshot 将反向索引表、文档状态、文档和字段的关键词长度以及数值属性写入w
+// 尚在缓存中、未加入索引表的文档不会被写入，调用前应先刷新缓存。索引器未初始化时返回ErrNotInitialized
+func (indexer *Indexer) Snapshot(w io.Writer) error {
+	if indexer.initialized == false {
+		return ErrNotInitialized
+	}
+
+	indexer.tableLock.RLock()
+	defer indexer.tableLock.RUnlock()
+
+	sw := newSnapshotWriter(w)
+	sw.uvarint(uint64(indexer.initOptions.IndexType))
+	sw.uvarint(indexer.numDocuments)
+	sw.float32(indexer.totalTokenLength)
+
+	// 只写入已加入索引表的文档，等待删除和尚在缓存中的文档不写入
+	numSettled := 0
+	for _, docState := range indexer.tableLock.docsState {
+		if docState == 0 {
+			numSettled++
+		}
+	}
+	sw.uvarint(uint64(numSettled))
+	for docID, docState := range indexer.tableLock.docsState {
+		if docState == 0 {
+			sw.uvarint(docID)
+			sw.uvarint(uint64(docState))
+		}
+	}
+
+	sw.uvarint(uint64(len(indexer.docTokenLengths)))
+	for docID, length := range indexer.docTokenLengths {
+		sw.uvarint(docID)
+		sw.float32(length)
+	}
+
+	sw.uvarint(uint64(len(indexer.docFieldLengths)))
+	for docID, lengths := range indexer.docFieldLengths {
+		sw.uvarint(docID)
+		sw.uvarint(uint64(len(lengths)))
+		for field, length := range lengths {
+			sw.string(field)
+			sw.float32(length)
+		}
+	}
+
+	sw.uvarint(uint64(len(indexer.tableLock.attributes)))
+	for name, column := range indexer.tableLock.attributes {
+		sw.string(name)
+		sw.uvarint(uint64(len(column)))
+		for docID, value := range column {
+			sw.uvarint(docID)
+			sw.float64(value)
+		}
+	}
+
+	// 写入合并后的全部段，不含已删除和等待删除的索引项
+	segments := indexer.tableLock.segments
+	deleted := make([]map[uint64]bool, len(segments))
+	for i, seg := range segments {
+		deleted[i] = make(map[uint64]bool, len(seg.deleted))
+		for docID := range seg.deleted {
+			deleted[i][docID] = true
+		}
+		for _, docID := range seg.docIDs {
+			if docState, ok := indexer.tableLock.docsState[docID]; !ok || docState != 0 {
+				deleted[i][docID] = true
+			}
+		}
+	}
+	table := indexer.mergeSegmentList(segments, deleted).table
+	sw.uvarint(uint64(len(table)))
+	for keyword, indices := range table {
+		sw.string(keyword)
+		docIDs, frequencies, locations, ends := indices.docIDs, indices.frequencies, indices.locations, indices.ends
+		if indices.packed != nil {
+			docIDs, frequencies, locations, ends = indices.packed.unpack()
+		}
+		sw.uvarint(uint64(len(docIDs)))
+		// DocID升序排列，写入差值
+		var previous uint64
+		for i, docID := range docIDs {
+			sw.uvarint(docID - previous)
+			previous = docID
+			switch indexer.initOptions.IndexType {
+			case types.LocationsIndex:
+				sw.ints(locations[i])
+				// 结束位置不一定升序，写入与起始位置的差值
+				var end []int
+				if ends != nil {
+					end = ends[i]
+				}
+				sw.uvarint(uint64(len(end)))
+				for k, e := range end {
+					sw.uvarint(uint64(e - locations[i][k]))
+				}
+			case types.FrequenciesIndex:
+				sw.float32(frequencies[i])
+			}
+		}
+	}
+	return sw.flush()
+}
+
+// Restore 用Snapshot写入的数据替换索引器的全部内容，数据被截断或已损坏时返回ErrSnapshotFormat
+// r不是*bufio.Reader时可能会被多读，连续读取多个快照时请传入同一个*bufio.Reader
+func (indexer *Indexer) Restore(r io.Reader) error {
+	if indexer.initialized == false {
+		return ErrNotInitialized
+	}
+
+	sr := newSnapshotReader(r)
+	if int(sr.uvarint()) != indexer.initOptions.IndexType && sr.err == nil {
+		return ErrSnapshotMismatch
+	}
+	numDocuments := sr.uvarint()
+	totalTokenLength := sr.float32()
+
+	n := sr.uvarint()
+	docsState := make(map[uint64]int)
+	for i := uint64(0); i < n && sr.err == nil; i++ {
+		docID := sr.uvarint()
+		if docState := int(sr.uvarint()); docState == 0 {
+			docsState[docID] = docState
+		}
+	}
+
+	n = sr.uvarint()
+	docTokenLengths := make(map[uint64]float32)
+	for i := uint64(0); i < n && sr.err == nil; i++ {
+		docID := sr.uvarint()
+		docTokenLengths[docID] = sr.float32()
+	}
+
+	// 字段总关键词数由各文档的字段长度累加得到
+	n = sr.uvarint()
+	fieldTokenLengths := make(map[string]float32)
+	docFieldLengths := make(map[uint64]map[string]float32)
+	for i := uint64(0); i < n && sr.err == nil; i++ {
+		docID := sr.uvarint()
+		length := sr.length()
+		lengths := make(map[string]float32)
+		for j := 0; j < length && sr.err == nil; j++ {
+			field := sr.string()
+			lengths[field] = sr.float32()
+			fieldTokenLengths[field] += lengths[field]
+		}
+		docFieldLengths[docID] = lengths
+	}
+
+	n = sr.uvarint()
+	attributes := make(map[string]map[uint64]float64)
+	for i := uint64(0); i < n && sr.err == nil; i++ {
+		name := sr.string()
+		length := sr.length()
+		column := make(map[uint64]float64)
+		for j := 0; j < length && sr.err == nil; j++ {
+			docID := sr.uvarint()
+			column[docID] = sr.float64()
+		}
+		attributes[name] = column
+	}
+
+	n = sr.uvarint()
+	table := make(map[string]*KeywordIndices)
+	for i := uint64(0); i < n && sr.err == nil; i++ {
+		keyword := sr.string()
+		length := sr.length()
+		if sr.err != nil {
+			break
+		}
+
+		// 长度来自快照本身，预先分配的容量有上限，数据不足时读取出错而终止
+		indices := &KeywordIndices{docIDs: make([]uint64, 0, snapshotCapacity(length))}
+		var previous uint64
+		for j := 0; j < length && sr.err == nil; j++ {
+			previous += sr.uvarint()
+			indices.docIDs = append(indices.docIDs, previous)
+			switch indexer.initOptions.IndexType {
+			case types.LocationsIndex:
+				locations := sr.ints()
+				// 结束位置个数与起始位置不同时快照已损坏
+				numEnds := sr.length()
+				if numEnds > 0 && numEnds != len(locations) && sr.err == nil {
+					sr.err = ErrSnapshotFormat
+				}
+				var ends []int
+				for k := 0; k < numEnds && sr.err == nil; k++ {
+					ends = append(ends, locations[k]+int(sr.uvarint()))
+				}
+				indices.locations = append(indices.locations, locations)
+				indices.ends = append(indices.ends, ends)
+			case types.FrequenciesIndex:
+				indices.frequencies = append(indices.frequencies, sr.float32())
+			}
+		}
+		table[keyword] = indices
+	}
+	if sr.err != nil {
+		if errors.Is(sr.err, ErrSnapshotFormat) {
+			return sr.err
+		}
+		return fmt.Errorf("%w: %v", ErrSnapshotFormat, sr.err)
+	}
+
+	// 重新计算BM25上界，LocationsIndex时由各搜索键的位置得到文档中全部关键词的起始位置
+	docTokenStarts := make(map[uint64][]int)
+	for _, indices := range table {
+		for j, docID := range indices.docIDs {
+			var frequency float32
+			switch indexer.initOptions.IndexType {
+			case types.LocationsIndex:
+				frequency = float32(len(indices.locations[j]))
+				docTokenStarts[docID] = append(docTokenStarts[docID], indices.locations[j]...)
+			case types.FrequenciesIndex:
+				frequency = indices.frequencies[j]
+			}
+			if j == 0 {
+				indices.maxFrequency = frequency
+				indices.minDocLength = docTokenLengths[docID]
+			} else {
+				indices.updateBounds(frequency, docTokenLengths[docID])
+			}
+		}
+		indexer.packIndices(indices)
+	}
+	for docID, starts := range docTokenStarts {
+		docTokenStarts[docID] = tokenStarts([]types.KeywordIndex{{Starts: starts}})
+	}
+
+	// 恢复为一个段，快照中只有已加入索引表的文档
+	seg := &segment{table: table, deleted: make(map[uint64]bool)}
+	for docID := range docsState {
+		seg.docIDs = append(seg.docIDs, docID)
+	}
+	sort.Sort(types.DocumentsID(seg.docIDs))
+	seg.sortTerms()
+
+	indexer.addCacheLock.Lock()
+	indexer.removeCacheLock.Lock()
+	indexer.tableLock.Lock()
+	indexer.tableLock.segments = []*segment{seg}
+	indexer.tableLock.docsState = docsState
+	indexer.tableLock.attributes = attributes
+	indexer.docTokenLengths = docTokenLengths
+	indexer.docTokenStarts = docTokenStarts
+	indexer.fieldTokenLengths = fieldTokenLengths
+	indexer.docFieldLengths = docFieldLengths
+	indexer.totalTokenLength = totalTokenLength
+	indexer.numDocuments = numDocuments
+	indexer.addCacheLock.addCachePointer = 0
+	indexer.removeCacheLock.removeCachePointer = 0
+	indexer.tableLock.Unlock()
+	indexer.removeCacheLock.Unlock()
+	indexer.addCacheLock.Unlock()
+	return nil
+}
+
+// 排序器快照的gob结构，Fields为nil的文档也需要保留
+type rankerSnapshot struct {
+	DocIDs []uint64
+	Fields []rankerSnapshotFields
+}
+
+type rankerSnapshotFields struct {
+	Value interface{}
+}
+
+// Snapshot 将全部文档的评分字段写入w，评分字段用gob序列化，其具体类型需先用gob.Register注册
+func (ranker *Ranker) Snapshot(w io.Writer) error {
+	if ranker.initialized == false {
+		return ErrNotInitialized
+	}
+
+	ranker.lock.RLock()
+	snapshot := rankerSnapshot{
+		DocIDs: make([]uint64, 0, len(ranker.lock.docs)),
+		Fields: make([]rankerSnapshotFields, 0, len(ranker.lock.docs)),
+	}
+	for docID := range ranker.lock.docs {
+		snapshot.DocIDs = append(snapshot.DocIDs, docID)
+		snapshot.Fields = append(snapshot.Fields, rankerSnapshotFields{ranker.lock.fields[docID]})
+	}
+	ranker.lock.RUnlock()
+
+	return gob.NewEncoder(w).Encode(snapshot)
+}
+
+// Restore 用Snapshot写入的数据替换排序器的全部内容
+// r不是*bufio.Reader时可能会被多读，连续读取多个快照时请传入同一个*bufio.Reader
+func (ranker *Ranker) Restore(r io.Reader) error {
+	if ranker.initialized == false {
+		return ErrNotInitialized
+	}
+
+	var snapshot rankerSnapshot
+	if err := gob.NewDecoder(r).Decode(&snapshot); err != nil {
+		return err
+	}
+	fields := make(map[uint64]interface{}, len(snapshot.DocIDs))
+	docs := make(map[uint64]bool, len(snapshot.DocIDs))
+	for i, docID := range snapshot.DocIDs {
+		fields[docID] = snapshot.Fields[i].Value
+		docs[docID] = true
+	}
+
+	ranker.lock.Lock()
+	ranker.lock.fields = fields
+	ranker.lock.docs = docs
+	ranker.lock.Unlock()
+	return nil
+}
+
+// 带错误累积的快照写入器，出错后的写入都被忽略
+type snapshotWriter struct {
+	w   *bufio.Writer
+	buf [binary.MaxVarintLen64]byte
+	err error
+}
+
+func newSnapshotWriter(w io.Writer) *snapshotWriter {
+	return &snapshotWriter{w: bufio.NewWriter(w)}
+}
+
+func (sw *snapshotWriter) write(p []byte) {
+	if sw.err == nil {
+		_, sw.err = sw.w.Write(p)
+	}
+}
+
+func (sw *snapshotWriter) uvarint(x uint64) {
+	sw.write(sw.buf[:binary.PutUvarint(sw.buf[:], x)])
+}
+
+func (sw *snapshotWriter) float32(x float32) {
+	binary.LittleEndian.PutUint32(sw.buf[:4], math.Float32bits(x))
+	sw.write(sw.buf[:4])
+}
+
+func (sw *snapshotWriter) float64(x float64) {
+	binary.LittleEndian.PutUint64(sw.buf[:8], math.Float64bits(x))
+	sw.write(sw.buf[:8])
+}
+
+func (sw *snapshotWriter) string(s string) {
+	sw.uvarint(uint64(len(s)))
+	sw.write([]byte(s))
+}
+
+// 整数列表，写入与前一个的差值，差值为有符号的变长编码，因此不要求升序
+func (sw *snapshotWriter) ints(values []int) {
+	sw.uvarint(uint64(len(values)))
+	previous := 0
+	for _, value := range values {
+		n := binary.PutVarint(sw.buf[:], int64(value-previous))
+		sw.write(sw.buf[:n])
+		previous = value
+	}
+}
+
+func (sw *snapshotWriter) flush() error {
+	if sw.err != nil {
+		return sw.err
+	}
+	return sw.w.Flush()
+}
+
+// 带错误累积的快照读取器，出错后读到的都是零值
+type snapshotReader struct {
+	r   *bufio.Reader
+	err error
+}
+
+func newSnapshotReader(r io.Reader) *snapshotReader {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	return &snapshotReader{r: br}
+}
+
+func (sr *snapshotReader) uvarint() uint64 {
+	if sr.err != nil {
+		return 0
+	}
+	var x uint64
+	x, sr.err = binary.ReadUvarint(sr.r)
+	return x
+}
+
+func (sr *snapshotReader) varint() int64 {
+	if sr.err != nil {
+		return 0
+	}
+	var x int64
+	x, sr.err = binary.ReadVarint(sr.r)
+	return x
+}
+
+// 读出一个长度，超过maxSnapshotLength时视为快照已损坏
+func (sr *snapshotReader) length() int {
+	n := sr.uvarint()
+	if sr.err == nil && n > maxSnapshotLength {
+		sr.err = ErrSnapshotFormat
+	}
+	if sr.err != nil {
+		return 0
+	}
+	return int(n)
+}
+
+// 按快照中的长度预先分配的容量，长度不可信，因此不超过一个较小的值
+func snapshotCapacity(n int) int {
+	if n > 1024 {
+		return 1024
+	}
+	return n
+}
+
+func (sr *snapshotReader) float32() float32 {
+	if sr.err != nil {
+		return 0
+	}
+	var buf [4]byte
+	if _, sr.err = io.ReadFull(sr.r, buf[:]); sr.err != nil {
+		return 0
+	}
+	return math.Float32frombits(binary.LittleEndian.Uint32(buf[:]))
+}
+
+func (sr *snapshotReader) float64() float64 {
+	if sr.err != nil {
+		return 0
+	}
+	var buf [8]byte
+	if _, sr.err = io.ReadFull(sr.r, buf[:]); sr.err != nil {
+		return 0
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(buf[:]))
+}
+
+func (sr *snapshotReader) string() string {
+	n := sr.length()
+	if sr.err != nil {
+		return ""
+	}
+	// 逐块读取，数据被截断时不会按长度分配整个字符串
+	var builder strings.Builder
+	if _, sr.err = io.CopyN(&builder, sr.r, int64(n)); sr.err != nil {
+		return ""
+	}
+	return builder.String()
+}
+
+func (sr *snapshotReader) ints() []int {
+	n := sr.length()
+	if sr.err != nil {
+		return nil
+	}
+	values := make([]int, 0, snapshotCapacity(n))
+	previous := 0
+	for i := 0; i < n && sr.err == nil; i++ {
+		previous += int(sr.varint())
+		values = append(values, previous)
+	}
+	return values
+}
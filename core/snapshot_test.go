@@ -0,0 +1,194 @@
+package core
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"testing"
+
+	"github.com/pickjunk/wuneng/types"
+	"github.com/pickjunk/wuneng/utils"
+)
+
+type snapshotScoringFields struct {
+	Counter int
+	Amount  float32
+}
+
+func TestIndexerSnapshot(t *testing.T) {
+	var indexer Indexer
+	indexer.Init(types.IndexerInitOptions{IndexType: types.LocationsIndex})
+	// doc1 = "token2 token3"
+	indexer.AddDocumentToCache(&types.DocumentIndex{
+		DocID:       1,
+		TokenLength: 2,
+		Keywords: []types.KeywordIndex{
+			{Text: "token2", Frequency: 0, Starts: []int{0}},
+			{Text: "token3", Frequency: 0, Starts: []int{7}},
+		},
+	}, false)
+	// doc2 = "token1 token2 token3 token2"
+	indexer.AddDocumentToCache(&types.DocumentIndex{
+		DocID:       2,
+		TokenLength: 4,
+		Attributes:  map[string]float64{"price": 9.5},
+		Keywords: []types.KeywordIndex{
+			{Text: "token1", Frequency: 0, Starts: []int{0}},
+			{Text: "token2", Frequency: 0, Starts: []int{7, 21}},
+			{Text: "token3", Frequency: 0, Starts: []int{14}},
+		},
+	}, true)
+
+	var buf bytes.Buffer
+	if err := indexer.Snapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	var indexer1 Indexer
+	indexer1.Init(types.IndexerInitOptions{IndexType: types.LocationsIndex})
+	if err := indexer1.Restore(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	utils.Expect(t, "1 2 ", indicesToString(&indexer1, "token2"))
+	utils.Expect(t, "2 ", indicesToString(&indexer1, "token1"))
+	utils.Expect(t, "[[7 21]]", indexer1.tableLock.segments[0].table["token2"].locations[1:])
+	utils.Expect(t, "6", indexer1.totalTokenLength)
+	utils.Expect(t, "2", indexer1.numDocuments)
+	utils.Expect(t, "map[price:map[2:9.5]]", indexer1.tableLock.attributes)
+	utils.Expect(t, "map[1:[0 7] 2:[0 7 14 21]]", indexer1.docTokenStarts)
+	utils.Expect(t, "[2 1 [7 14]] [1 1 [0 7]] ",
+		indexedDocsToString(indexer1.Lookup([]string{"token2", "token3"}, []string{}, nil, false)))
+
+	var indexer2 Indexer
+	indexer2.Init(types.IndexerInitOptions{IndexType: types.FrequenciesIndex})
+	utils.Expect(t, ErrSnapshotMismatch.Error(), indexer2.Restore(bytes.NewReader(buf.Bytes())).Error())
+
+	// 等待删除的文档不写入快照，恢复后可以重新加入
+	indexer.RemoveDocumentToCache(1, false)
+	buf.Reset()
+	if err := indexer.Snapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var indexer3 Indexer
+	indexer3.Init(types.IndexerInitOptions{IndexType: types.LocationsIndex})
+	if err := indexer3.Restore(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	utils.Expect(t, "map[2:0]", indexer3.tableLock.docsState)
+	utils.Expect(t, "2 ", indicesToString(&indexer3, "token2"))
+	indexer3.AddDocumentToCache(&types.DocumentIndex{
+		DocID:    1,
+		Keywords: []types.KeywordIndex{{Text: "token2", Starts: []int{0}}},
+	}, true)
+	utils.Expect(t, "[2 0 [7]] [1 0 [0]] ",
+		indexedDocsToString(indexer3.Lookup([]string{"token2"}, []string{}, nil, false)))
+}
+
+func TestIndexerSnapshotUnsortedLocations(t *testing.T) {
+	var indexer Indexer
+	indexer.Init(types.IndexerInitOptions{IndexType: types.LocationsIndex})
+	// 用户输入的关键词位置不一定升序
+	indexer.AddDocumentToCache(&types.DocumentIndex{
+		DocID:    1,
+		Keywords: []types.KeywordIndex{{Text: "a", Starts: []int{9, 3}, Ends: []int{12, 5}}},
+	}, true)
+
+	var buf bytes.Buffer
+	utils.Expect(t, "<nil>", indexer.Snapshot(&buf))
+	var restored Indexer
+	restored.Init(types.IndexerInitOptions{IndexType: types.LocationsIndex})
+	utils.Expect(t, "<nil>", restored.Restore(&buf))
+	indices := restored.tableLock.segments[0].table["a"]
+	utils.Expect(t, "[[9 3]]", indices.locations)
+	utils.Expect(t, "[[12 5]]", indices.ends)
+}
+
+func TestIndexerRestoreCorrupted(t *testing.T) {
+	options := types.IndexerInitOptions{IndexType: types.LocationsIndex}
+	var indexer Indexer
+	indexer.Init(options)
+	indexer.AddDocumentToCache(&types.DocumentIndex{
+		DocID:        1,
+		TokenLength:  2,
+		FieldLengths: map[string]float32{"title": 1},
+		Attributes:   map[string]float64{"price": 9.5},
+		Keywords: []types.KeywordIndex{
+			{Text: "token1", Starts: []int{0}},
+			{Text: "token2", Starts: []int{7}, Ends: []int{10}},
+		},
+	}, true)
+	var buf bytes.Buffer
+	utils.Expect(t, "<nil>", indexer.Snapshot(&buf))
+	data := buf.Bytes()
+
+	// 任意位置截断都返回错误
+	for n := 0; n < len(data); n++ {
+		var restored Indexer
+		restored.Init(options)
+		if err := restored.Restore(bytes.NewReader(data[:n])); !errors.Is(err, ErrSnapshotFormat) {
+			t.Fatalf("截断到%d字节: %v", n, err)
+		}
+	}
+
+	// 超出上限的长度不会按其分配内存
+	var garbage bytes.Buffer
+	sw := newSnapshotWriter(&garbage)
+	sw.uvarint(uint64(types.LocationsIndex))
+	sw.uvarint(1)
+	sw.float32(1)
+	for i := 0; i < 4; i++ {
+		sw.uvarint(0)
+	}
+	sw.uvarint(1)
+	sw.uvarint(1 << 62)
+	utils.Expect(t, "<nil>", sw.flush())
+	var restored Indexer
+	restored.Init(options)
+	utils.Expect(t, "true", errors.Is(restored.Restore(&garbage), ErrSnapshotFormat))
+
+	// 长度在上限之内但数据不足
+	garbage.Reset()
+	sw = newSnapshotWriter(&garbage)
+	sw.uvarint(uint64(types.LocationsIndex))
+	sw.uvarint(1)
+	sw.float32(1)
+	for i := 0; i < 4; i++ {
+		sw.uvarint(0)
+	}
+	sw.uvarint(1)
+	sw.string("a")
+	sw.uvarint(maxSnapshotLength)
+	sw.uvarint(1)
+	sw.uvarint(maxSnapshotLength)
+	utils.Expect(t, "<nil>", sw.flush())
+	utils.Expect(t, "true", errors.Is(restored.Restore(&garbage), ErrSnapshotFormat))
+
+	// 出错后仍可恢复完整的快照
+	utils.Expect(t, "<nil>", restored.Restore(bytes.NewReader(data)))
+	utils.Expect(t, "1 ", indicesToString(&restored, "token2"))
+}
+
+func TestRankerSnapshot(t *testing.T) {
+	gob.Register(snapshotScoringFields{})
+
+	var ranker Ranker
+	ranker.Init()
+	ranker.AddDoc(1, snapshotScoringFields{1, 2})
+	ranker.AddDoc(2, nil)
+
+	var buf bytes.Buffer
+	if err := ranker.Snapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	var ranker1 Ranker
+	ranker1.Init()
+	ranker1.AddDoc(3, snapshotScoringFields{3, 4})
+	if err := ranker1.Restore(&buf); err != nil {
+		t.Fatal(err)
+	}
+	utils.Expect(t, "{1 2}", ranker1.lock.fields[1])
+	utils.Expect(t, "<nil>", ranker1.lock.fields[2])
+	utils.Expect(t, "true", ranker1.lock.docs[2])
+	utils.Expect(t, "false", ranker1.lock.docs[3])
+}
@@ -0,0 +1,100 @@
+package core
+
+import (
+	"container/heap"
+	"math"
+	"reflect"
+	"time"
+
+	"github.com/pickjunk/wuneng/types"
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// 从评分字段中取出各排序键的值，见types.SortField
+func sortValues(fields interface{}, sortBy []types.SortField) []interface{} {
+	values := make([]interface{}, len(sortBy))
+	if valuer, ok := fields.(types.SortValuer); ok {
+		for i, field := range sortBy {
+			values[i] = valuer.SortValue(field.Field)
+		}
+		return values
+	}
+
+	v := reflect.ValueOf(fields)
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return values
+		}
+		v = v.Elem()
+	}
+	for i, field := range sortBy {
+		switch {
+		case v.Kind() == reflect.Struct:
+			values[i] = sortValue(v.FieldByName(field.Field))
+		case v.Kind() == reflect.Map && v.Type().Key().Kind() == reflect.String:
+			values[i] = sortValue(v.MapIndex(reflect.ValueOf(field.Field).Convert(v.Type().Key())))
+		}
+	}
+	return values
+}
+
+// 将字段值转换为int64、float64或string，不支持的类型返回nil
+func sortValue(v reflect.Value) interface{} {
+	for v.IsValid() && (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return nil
+	}
+	if v.Type() == timeType && v.CanInterface() {
+		return v.Interface().(time.Time).UnixNano()
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if u := v.Uint(); u <= math.MaxInt64 {
+			return int64(u)
+		}
+		return float64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	case reflect.String:
+		return v.String()
+	}
+	return nil
+}
+
+// 保留排序最靠前的k个文档的有界堆，堆顶为其中排在最后的文档
+type scoredDocumentHeap struct {
+	docs    types.ScoredDocuments
+	options types.RankOptions
+}
+
+func (h *scoredDocumentHeap) Len() int      { return len(h.docs) }
+func (h *scoredDocumentHeap) Swap(i, j int) { h.docs[i], h.docs[j] = h.docs[j], h.docs[i] }
+func (h *scoredDocumentHeap) Less(i, j int) bool {
+	return types.CompareScoredDocuments(h.docs[i], h.docs[j], h.options) > 0
+}
+func (h *scoredDocumentHeap) Push(x interface{}) { h.docs = append(h.docs, x.(types.ScoredDocument)) }
+func (h *scoredDocumentHeap) Pop() interface{} {
+	doc := h.docs[len(h.docs)-1]
+	h.docs = h.docs[:len(h.docs)-1]
+	return doc
+}
+
+// 加入一个文档，堆已满时只有排在堆顶之前的文档才替换堆顶
+func (h *scoredDocumentHeap) offer(doc types.ScoredDocument, k int) {
+	if len(h.docs) < k {
+		heap.Push(h, doc)
+		return
+	}
+	if types.CompareScoredDocuments(doc, h.docs[0], h.options) < 0 {
+		h.docs[0] = doc
+		heap.Fix(h, 0)
+	}
+}
@@ -0,0 +1,134 @@
+package core
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pickjunk/wuneng/types"
+)
+
+// Suggest 返回以prefix开头的搜索键及其文档数，按文档数从大到小排列，最多n个
+// labels不为空时只统计同时包含全部标签的文档，文档数为0的搜索键不返回，
+// 拼音和字段的搜索键只在prefix本身位于同一命名空间时返回，见hiddenTerm
+// 文档数不含已删除的文档，但尚在缓存中的增删不计入。索引器未初始化时返回ErrNotInitialized
+func (indexer *Indexer) Suggest(prefix string, labels []string, n int) ([]types.Suggestion, error) {
+	if indexer.initialized == false {
+		return nil, ErrNotInitialized
+	}
+	if n <= 0 {
+		return nil, nil
+	}
+
+	indexer.tableLock.RLock()
+	defer indexer.tableLock.RUnlock()
+
+	counts := make(map[string]int)
+	for _, seg := range indexer.tableLock.segments {
+		labelDocIDs, found := indexer.labelDocIDs(seg, labels)
+		if !found {
+			continue
+		}
+		i := sort.SearchStrings(seg.terms, prefix)
+		for ; i < len(seg.terms) && strings.HasPrefix(seg.terms[i], prefix); i++ {
+			if hiddenTerm(prefix, seg.terms[i]) {
+				continue
+			}
+			counts[seg.terms[i]] += indexer.segmentTermCount(seg, seg.terms[i], labelDocIDs)
+		}
+	}
+
+	suggestions := make([]types.Suggestion, 0, len(counts))
+	for term, count := range counts {
+		if count > 0 {
+			suggestions = append(suggestions, types.Suggestion{Text: term, Frequency: count})
+		}
+	}
+	types.SortSuggestions(suggestions)
+	if len(suggestions) > n {
+		suggestions = suggestions[:n]
+	}
+	return suggestions, nil
+}
+
+// 以query开头或与之相近的term是否为query之外的拼音或字段搜索键，这些搜索键不是原文中的关键词，
+// 不应出现在补全和模糊匹配的结果中。query为FieldKeyword时比较去掉字段名之后的部分，
+// 如"field#title:pin"不匹配"field#title:pinyin#baidu"
+func hiddenTerm(query string, term string) bool {
+	if _, text, ok := types.SplitFieldKeyword(query); ok {
+		query = text
+		_, term, _ = types.SplitFieldKeyword(term)
+	}
+	return types.IsReservedKeyword(term) && !types.IsReservedKeyword(query)
+}
+
+// TermCounts 返回各搜索键的文档数，labels和文档数的含义同Suggest
+func (indexer *Indexer) TermCounts(terms []string, labels []string) ([]int, error) {
+	if indexer.initialized == false {
+		return nil, ErrNotInitialized
+	}
+
+	indexer.tableLock.RLock()
+	defer indexer.tableLock.RUnlock()
+
+	counts := make([]int, len(terms))
+	for _, seg := range indexer.tableLock.segments {
+		labelDocIDs, found := indexer.labelDocIDs(seg, labels)
+		if !found {
+			continue
+		}
+		for i, term := range terms {
+			counts[i] += indexer.segmentTermCount(seg, term, labelDocIDs)
+		}
+	}
+	return counts, nil
+}
+
+// 段中同时包含全部标签的文档，labels为空时返回nil，没有这样的文档时第二个返回值为false
+func (indexer *Indexer) labelDocIDs(seg *segment, labels []string) ([]uint64, bool) {
+	var docIDs []uint64
+	for i, label := range labels {
+		indices, found := seg.table[label]
+		if !found {
+			return nil, false
+		}
+		if i == 0 {
+			docIDs = indexer.getDocIDs(indices)
+		} else {
+			docIDs = indexer.matchPostings(docIDs, indices, true)
+		}
+		if len(docIDs) == 0 {
+			return nil, false
+		}
+	}
+	return docIDs, true
+}
+
+// 段中包含搜索键且未删除的文档数，labelDocIDs不为nil时只统计其中的文档
+func (indexer *Indexer) segmentTermCount(seg *segment, term string, labelDocIDs []uint64) int {
+	indices, found := seg.table[term]
+	if !found {
+		return 0
+	}
+	if labelDocIDs != nil {
+		count := 0
+		for _, docID := range indexer.matchPostings(labelDocIDs, indices, true) {
+			if !seg.deleted[docID] {
+				count++
+			}
+		}
+		return count
+	}
+
+	// 墓碑通常远少于索引项，逐个查找被删除的文档
+	count := indexer.getIndexLength(indices)
+	if count == 0 || len(seg.deleted) == 0 {
+		return count
+	}
+	cursor := newDocIDCursor(indices)
+	for docID := range seg.deleted {
+		if _, found := indexer.searchIndex(cursor, 0, indexer.getIndexLength(indices)-1, docID); found {
+			count--
+		}
+	}
+	return count
+}
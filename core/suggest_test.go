@@ -0,0 +1,57 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/pickjunk/wuneng/types"
+	"github.com/pickjunk/wuneng/utils"
+)
+
+func TestSuggest(t *testing.T) {
+	var indexer Indexer
+	indexer.Init(types.IndexerInitOptions{IndexType: types.DocIDsIndex})
+	indexer.AddDocumentToCache(&types.DocumentIndex{
+		DocID: 1,
+		Keywords: []types.KeywordIndex{{Text: "apple"}, {Text: "apply"}, {Text: "label:x"},
+			{Text: types.FieldKeyword("title", "apple")}, {Text: types.PinyinKeyword("pingguo")},
+			{Text: types.FieldKeyword("title", types.PinyinKeyword("pingguo"))}},
+	}, false)
+	indexer.AddDocumentToCache(&types.DocumentIndex{
+		DocID:    2,
+		Keywords: []types.KeywordIndex{{Text: "apple"}, {Text: "label:y"}},
+	}, true)
+	indexer.AddDocumentToCache(&types.DocumentIndex{
+		DocID:    3,
+		Keywords: []types.KeywordIndex{{Text: "apple"}, {Text: "application"}, {Text: "label:x"}},
+	}, true)
+
+	suggest := func(prefix string, labels []string, n int) []types.Suggestion {
+		suggestions, _ := indexer.Suggest(prefix, labels, n)
+		return suggestions
+	}
+	termCounts := func(terms []string, labels []string) []int {
+		counts, _ := indexer.TermCounts(terms, labels)
+		return counts
+	}
+	utils.Expect(t, "[{apple 3} {application 1} {apply 1}]", suggest("app", nil, 10))
+	utils.Expect(t, "[{apple 2} {application 1}]", suggest("app", []string{"label:x"}, 2))
+	utils.Expect(t, "[]", suggest("app", []string{"label:z"}, 10))
+	utils.Expect(t, "[{label:x 2} {label:y 1}]", suggest("label:", nil, 10))
+
+	// 拼音和字段的搜索键只在prefix位于同一命名空间时返回
+	utils.Expect(t, "[]", suggest("pin", nil, 10))
+	utils.Expect(t, "[{apple 3} {label:x 2} {application 1} {apply 1} {label:y 1}]", suggest("", nil, 10))
+	utils.Expect(t, "[{pinyin#pingguo 1}]", suggest(types.PinyinKeyword("p"), nil, 10))
+	utils.Expect(t, "[{field#title:apple 1}]", suggest(types.FieldKeyword("title", ""), nil, 10))
+	utils.Expect(t, "[{field#title:pinyin#pingguo 1}]",
+		suggest(types.FieldKeyword("title", types.PinyinKeyword("")), nil, 10))
+
+	// 已删除的文档不计入
+	indexer.RemoveDocumentToCache(3, true)
+	utils.Expect(t, "[{apple 2} {apply 1}]", suggest("app", nil, 10))
+	utils.Expect(t, "[1 0]", termCounts([]string{"apple", "application"}, []string{"label:y"}))
+
+	indexer.MergeSegments()
+	utils.Expect(t, "[{apple 2} {apply 1}]", suggest("app", nil, 10))
+	utils.Expect(t, "[{apple 1} {apply 1}]", suggest("app", []string{"label:x"}, 10))
+}
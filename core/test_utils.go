@@ -2,20 +2,39 @@ package core
 
 import (
 	"fmt"
+	"sort"
 
 	"github.com/pickjunk/wuneng/types"
 )
 
 func indicesToString(indexer *Indexer, token string) (output string) {
-	if indices, ok := indexer.tableLock.table[token]; ok {
-		for i := 0; i < indexer.getIndexLength(indices); i++ {
-			output += fmt.Sprintf("%d ",
-				indexer.getDocID(indices, i))
+	indexer.tableLock.RLock()
+	defer indexer.tableLock.RUnlock()
+	var docIDs []uint64
+	for _, seg := range indexer.tableLock.segments {
+		if indices, ok := seg.table[token]; ok {
+			cursor := newPostingCursor(indices)
+			for i := 0; i < indexer.getIndexLength(indices); i++ {
+				if docID := indexer.getDocID(cursor, i); !seg.deleted[docID] {
+					docIDs = append(docIDs, docID)
+				}
+			}
 		}
 	}
+	sort.Sort(types.DocumentsID(docIDs))
+	for _, docID := range docIDs {
+		output += fmt.Sprintf("%d ", docID)
+	}
 	return
 }
 
+// 第一个段中搜索键的索引项是否压缩
+func isPacked(indexer *Indexer, token string) bool {
+	indexer.tableLock.RLock()
+	defer indexer.tableLock.RUnlock()
+	return indexer.tableLock.segments[0].table[token].packed != nil
+}
+
 func indexedDocsToString(docs []types.IndexedDocument, numDocs int) (output string) {
 	for _, doc := range docs {
 		output += fmt.Sprintf("[%d %d %v] ",
@@ -0,0 +1,189 @@
+package engine
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/gob"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/pickjunk/wuneng/storage"
+	"github.com/pickjunk/wuneng/types"
+	"github.com/pickjunk/wuneng/utils"
+)
+
+// DocumentStoreFilePrefix 文档存储文件名前缀，文件名为 DocumentStoreFilePrefix.<裂分号>
+const DocumentStoreFilePrefix = "wuneng-documents"
+
+// 保存的文档原文，见EngineInitOptions.StoreDocuments
+type storedDocument struct {
+	Content    string
+	TextFields map[string]string
+	Fields     interface{}
+
+	// 分词时正文所占的长度，见indexedContentLength
+	ContentLength int
+}
+
+// 分词时的全文，排布见textFieldsLayout，正文为空时用户输入的关键词所占的位置以空格填充
+func (document storedDocument) indexedText() string {
+	if len(document.TextFields) == 0 {
+		return document.Content
+	}
+	fields, starts := textFieldsLayout(utils.MaxInt(document.ContentLength, len(document.Content)), document.TextFields)
+
+	var builder strings.Builder
+	builder.WriteString(document.Content)
+	for i, field := range fields {
+		builder.WriteString(strings.Repeat(" ", starts[i]-1-builder.Len()))
+		builder.WriteByte('\n')
+		builder.WriteString(document.TextFields[field])
+	}
+	return builder.String()
+}
+
+// 文档存储，按DocID裂分，值为压缩后的gob编码
+type documentStore struct {
+	shards []storage.Storage
+}
+
+// folder为空时保存在内存中
+func openDocumentStore(folder string, numShards int) (*documentStore, error) {
+	store := &documentStore{shards: make([]storage.Storage, numShards)}
+	if folder == "" {
+		for shard := range store.shards {
+			store.shards[shard] = storage.NewMemoryStorage()
+		}
+		return store, nil
+	}
+
+	if err := os.MkdirAll(folder, 0700); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrStorage, err)
+	}
+	for shard := range store.shards {
+		path := filepath.Join(folder, DocumentStoreFilePrefix+"."+strconv.Itoa(shard))
+		db, err := storage.OpenStorage(path)
+		if err != nil {
+			for _, opened := range store.shards[:shard] {
+				opened.Close()
+			}
+			return nil, fmt.Errorf("%w: %s: %v", ErrStorage, path, err)
+		}
+		store.shards[shard] = db
+	}
+	return store, nil
+}
+
+func (store *documentStore) shard(docID uint64) storage.Storage {
+	return store.shards[docID%uint64(len(store.shards))]
+}
+
+func (store *documentStore) set(docID uint64, data types.DocumentIndexData) error {
+	var buf bytes.Buffer
+	w, _ := flate.NewWriter(&buf, flate.BestSpeed)
+	err := gob.NewEncoder(w).Encode(storedDocument{
+		Content: data.Content, TextFields: data.TextFields, Fields: data.Fields,
+		ContentLength: indexedContentLength(data)})
+	if err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return store.shard(docID).Set(encodeStorageKey(docID), buf.Bytes())
+}
+
+// 文档不存在时第二个返回值为false
+func (store *documentStore) get(docID uint64) (document storedDocument, found bool, err error) {
+	value, err := store.shard(docID).Get(encodeStorageKey(docID))
+	if err != nil || value == nil {
+		return
+	}
+	r := flate.NewReader(bytes.NewReader(value))
+	defer r.Close()
+	if err = gob.NewDecoder(r).Decode(&document); err != nil {
+		return
+	}
+	return document, true, nil
+}
+
+func (store *documentStore) delete(docID uint64) error {
+	return store.shard(docID).Delete(encodeStorageKey(docID))
+}
+
+func (store *documentStore) close() {
+	for _, db := range store.shards {
+		db.Close()
+	}
+}
+
+type documentStoreRequest struct {
+	docID  uint64
+	data   types.DocumentIndexData
+	remove bool
+	ack    *writeAck
+}
+
+// 将文档的写入或删除发往文档存储，未保存文档时不做任何事，ack不为nil时写入完成后回执一次
+// 写入在文档存储worker中进行，同一文档的请求在同一裂分中按提交顺序执行
+func (engine *Engine) storeDocument(docID uint64, data types.DocumentIndexData, remove bool, ack *writeAck) {
+	if engine.documents == nil || docID == 0 {
+		return
+	}
+	atomic.AddUint64(&engine.numStoringRequests, 1)
+	engine.documentStoreChannels[engine.getStorageShard(docID)] <- documentStoreRequest{
+		docID: docID, data: data, remove: remove, ack: ack}
+}
+
+func (engine *Engine) documentStoreWorker(shard int) {
+	for {
+		select {
+		case <-engine.shutdownChannel:
+			return
+		case request := <-engine.documentStoreChannels[shard]:
+			var err error
+			if request.remove {
+				err = engine.documents.delete(request.docID)
+			} else {
+				err = engine.documents.set(request.docID, request.data)
+			}
+			if err != nil {
+				// 通常是 Fields 的具体类型没有用 gob.Register 注册
+				log.Error().Err(err).Uint64("docID", request.docID).Msg("文档存储写入失败")
+			}
+			atomic.AddUint64(&engine.numDocumentsStored, 1)
+			request.ack.finish(err)
+		}
+	}
+}
+
+// 按搜索请求在输出文档中填入保存的原文、评分字段和高亮片段
+func (engine *Engine) fillStoredDocuments(docs []types.ScoredDocument, tokens []string, request types.SearchRequest) {
+	if engine.documents == nil || (!request.ReturnContent && !request.ReturnFields && request.Highlight == nil) {
+		return
+	}
+	for i := range docs {
+		document, found, err := engine.documents.get(docs[i].DocID)
+		if err != nil {
+			log.Error().Err(err).Uint64("docID", docs[i].DocID).Msg("文档存储读取失败")
+			continue
+		}
+		if !found {
+			continue
+		}
+		if request.ReturnContent {
+			docs[i].Content = document.Content
+			docs[i].TextFields = document.TextFields
+		}
+		if request.ReturnFields {
+			docs[i].Fields = document.Fields
+		}
+		if request.Highlight != nil {
+			docs[i].Highlights = Highlight(document.indexedText(), tokens, docs[i], *request.Highlight)
+		}
+	}
+}
@@ -1,16 +1,23 @@
 package engine
 
 import (
+	"context"
 	"fmt"
+	"os"
+	"path/filepath"
 	"runtime"
-	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/cloudfoundry/bytefmt"
 	"github.com/huichen/murmur"
 	"github.com/pickjunk/sego"
+	"github.com/pickjunk/wuneng/analyzer"
 	"github.com/pickjunk/wuneng/core"
+	"github.com/pickjunk/wuneng/storage"
 	"github.com/pickjunk/wuneng/types"
 	"github.com/pickjunk/wuneng/utils"
 	"github.com/shirou/gopsutil/mem"
@@ -18,6 +25,9 @@ import (
 
 const (
 	numNanosecondsInAMillisecond = 1000000
+
+	// 持久存储文件名前缀，文件名为 PersistentStorageFilePrefix.<裂分号>
+	PersistentStorageFilePrefix = "wuneng"
 )
 
 // Engine struct
@@ -30,15 +40,27 @@ type Engine struct {
 	numRemovingRequests      uint64
 	numForceUpdatingRequests uint64
 	numTokenIndexAdded       uint64
+	numStoringRequests       uint64
+	numDocumentsStored       uint64
 
 	// 记录初始化参数
 	initOptions types.EngineInitOptions
 	initialized bool
+	shutdown    int32
 
 	indexers  []core.Indexer
 	rankers   []core.Ranker
 	segmenter sego.Segmenter
 
+	// 是否载入了sego词典，只使用自定义分析器或NotUsingSegmenter时为false
+	segmenterLoaded bool
+
+	// 拼音词典，未设置PinyinDictionary时为nil
+	pinyin *pinyinDictionary
+
+	// 同义词词典，*synonymDictionary，运行时可以替换
+	synonyms atomic.Value
+
 	// 建立索引器使用的通信通道
 	segmenterChannel         chan segmenterRequest
 	indexerAddDocChannels    []chan indexerAddDocumentRequest
@@ -50,35 +72,150 @@ type Engine struct {
 	rankerRankChannels      []chan rankerRankRequest
 	rankerRemoveDocChannels []chan rankerRemoveDocRequest
 
+	// 持久存储及其通信通道
+	dbs                       []storage.Storage
+	persistentStorageChannels []chan persistentStorageRequest
+
+	// 写请求持有读锁直到请求发往worker，快照持有写锁，保证快照期间没有新的写请求
+	writeLock sync.RWMutex
+
+	// 预写日志，未使用时为nil
+	wal *writeAheadLog
+
+	// 文档存储及其通信通道，未保存文档时为nil
+	documents             *documentStore
+	documentStoreChannels []chan documentStoreRequest
+
 	// 引擎退出的通信信道
 	shutdownChannel chan bool
 }
 
+// New 创建并初始化搜索引擎，初始化失败时返回错误
+func New(options types.EngineInitOptions) (*Engine, error) {
+	engine := &Engine{}
+	if err := engine.init(options); err != nil {
+		return nil, err
+	}
+	return engine, nil
+}
+
 // Init 初始化搜索引擎，拉起所有worker
+// 初始化失败时panic，需要返回错误请使用New
 func (engine *Engine) Init(options types.EngineInitOptions) {
+	if err := engine.init(options); err != nil {
+		log.Panic().Err(err).Msg("引擎初始化失败")
+	}
+}
+
+func (engine *Engine) init(options types.EngineInitOptions) error {
 	// 将线程数设置为CPU数
 	runtime.GOMAXPROCS(runtime.NumCPU())
 
 	// 初始化初始参数
 	if engine.initialized {
-		log.Panic().Msg("请勿重复初始化引擎")
+		return ErrAlreadyInitialized
+	}
+	if err := options.Validate(); err != nil {
+		return err
 	}
 	options.Init()
 	engine.initOptions = options
-	engine.initialized = true
 
-	if !options.NotUsingSegmenter {
+	if !options.NotUsingSegmenter && options.SegmenterDictionaries != "" {
+		// 分词器找不到词典文件时会直接退出进程，因此预先检查
+		for _, file := range strings.Split(options.SegmenterDictionaries, ",") {
+			if _, err := os.Stat(file); err != nil {
+				return fmt.Errorf("%w: %v", ErrDictionaryNotFound, err)
+			}
+		}
+
 		// 载入分词器词典
 		engine.segmenter.LoadDictionary(options.SegmenterDictionaries)
+		engine.segmenterLoaded = true
+	}
+
+	// 未指定分析器时使用sego分词器
+	if engine.initOptions.IndexAnalyzer == nil {
+		engine.initOptions.IndexAnalyzer = &analyzer.SegoAnalyzer{Segmenter: &engine.segmenter, Full: true}
+	}
+	if engine.initOptions.QueryAnalyzer == nil {
+		engine.initOptions.QueryAnalyzer = &analyzer.SegoAnalyzer{Segmenter: &engine.segmenter}
+	}
+
+	// 索引和搜索使用同样的过滤器
+	filters := options.TokenFilters
+	if options.StopTokenFile != "" {
+		stopFilter, err := analyzer.LoadStopFilter(options.StopTokenFile)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrDictionaryNotFound, err)
+		}
+		filters = append(filters[:len(filters):len(filters)], stopFilter)
+	}
+	if len(filters) > 0 {
+		engine.initOptions.IndexAnalyzer = &analyzer.FilteredAnalyzer{
+			Analyzer: engine.initOptions.IndexAnalyzer, Filters: filters}
+		engine.initOptions.QueryAnalyzer = &analyzer.FilteredAnalyzer{
+			Analyzer: engine.initOptions.QueryAnalyzer, Filters: filters}
+	}
+
+	if options.SynonymFile != "" {
+		rules, err := readSynonymFile(options.SynonymFile)
+		if err != nil {
+			return err
+		}
+		engine.setSynonyms(rules)
+	}
+
+	if options.PinyinDictionary != "" {
+		var err error
+		if engine.pinyin, err = loadPinyinDictionary(options.PinyinDictionary); err != nil {
+			return fmt.Errorf("%w: %v", ErrDictionaryNotFound, err)
+		}
+	}
+
+	// 先打开持久存储，失败时尚未拉起任何worker
+	if options.UsePersistentStorage {
+		if err := engine.openPersistentStorage(); err != nil {
+			return err
+		}
+	}
+
+	if options.StoreDocuments {
+		var err error
+		if engine.documents, err = openDocumentStore(
+			options.DocumentStoreFolder, options.PersistentStorageShards); err != nil {
+			for _, db := range engine.dbs {
+				db.Close()
+			}
+			return err
+		}
+		engine.documentStoreChannels = make([]chan documentStoreRequest, options.PersistentStorageShards)
+		for shard := range engine.documentStoreChannels {
+			engine.documentStoreChannels[shard] = make(chan documentStoreRequest, options.IndexerBufferLength)
+		}
 	}
 
 	// 初始化索引器和排序器
+	engine.indexers = make([]core.Indexer, options.NumShards)
+	engine.rankers = make([]core.Ranker, options.NumShards)
 	for shard := 0; shard < options.NumShards; shard++ {
-		engine.indexers = append(engine.indexers, core.Indexer{})
-		engine.indexers[shard].Init(*options.IndexerInitOptions)
+		engine.indexers[shard].TryInit(*options.IndexerInitOptions)
+		engine.rankers[shard].TryInit()
+	}
 
-		engine.rankers = append(engine.rankers, core.Ranker{})
-		engine.rankers[shard].Init()
+	// 从检查点快照恢复并读出预写日志，须在拉起worker之前
+	var walRecords []walRecord
+	if options.UseWAL {
+		var err error
+		if walRecords, err = engine.openWAL(); err != nil {
+			for _, db := range engine.dbs {
+				db.Close()
+			}
+			if engine.documents != nil {
+				engine.documents.close()
+			}
+			return err
+		}
 	}
 
 	// 初始化分词器通道
@@ -124,8 +261,7 @@ func (engine *Engine) Init(options types.EngineInitOptions) {
 	}
 
 	// 初始化退出通道
-	engine.shutdownChannel = make(
-		chan bool, options.NumSegmenterThreads+6*options.NumShards)
+	engine.shutdownChannel = make(chan bool, engine.numWorkers())
 
 	// 启动分词器
 	for iThread := 0; iThread < options.NumSegmenterThreads; iThread++ {
@@ -146,12 +282,107 @@ func (engine *Engine) Init(options types.EngineInitOptions) {
 			go engine.rankerRankWorker(shard)
 		}
 	}
+
+	// 启动文档存储worker，从持久存储和预写日志恢复时也经由它们写入
+	for shard := range engine.documentStoreChannels {
+		go engine.documentStoreWorker(shard)
+	}
+
+	engine.initialized = true
+
+	if options.UsePersistentStorage {
+		engine.restorePersistentStorage()
+	}
+	if options.UseWAL {
+		engine.replayWAL(walRecords)
+	}
+	return nil
+}
+
+// 打开或创建持久存储，失败时关闭已打开的部分
+func (engine *Engine) openPersistentStorage() error {
+	options := engine.initOptions
+	if err := os.MkdirAll(options.PersistentStorageFolder, 0700); err != nil {
+		return fmt.Errorf("%w: %v", ErrStorage, err)
+	}
+
+	engine.dbs = make([]storage.Storage, options.PersistentStorageShards)
+	engine.persistentStorageChannels = make(
+		[]chan persistentStorageRequest, options.PersistentStorageShards)
+	for shard := 0; shard < options.PersistentStorageShards; shard++ {
+		dbPath := filepath.Join(options.PersistentStorageFolder,
+			PersistentStorageFilePrefix+"."+strconv.Itoa(shard))
+		db, err := storage.OpenStorage(dbPath)
+		if err != nil {
+			for _, opened := range engine.dbs[:shard] {
+				opened.Close()
+			}
+			engine.dbs = nil
+			return fmt.Errorf("%w: %s: %v", ErrStorage, dbPath, err)
+		}
+		engine.dbs[shard] = db
+		engine.persistentStorageChannels[shard] = make(
+			chan persistentStorageRequest, options.IndexerBufferLength)
+	}
+	return nil
+}
+
+// 将持久存储中的文档恢复到索引器和排序器中，恢复完成后才启动持久存储worker
+func (engine *Engine) restorePersistentStorage() {
+	options := engine.initOptions
+
+	// 从持久存储中恢复索引，恢复期间不会重复写入持久存储
+	done := make(chan bool, options.PersistentStorageShards)
+	for shard := 0; shard < options.PersistentStorageShards; shard++ {
+		go engine.persistentStorageInitWorker(shard, done)
+	}
+	for shard := 0; shard < options.PersistentStorageShards; shard++ {
+		<-done
+	}
+	engine.FlushIndex()
+
+	for shard := 0; shard < options.PersistentStorageShards; shard++ {
+		go engine.persistentStorageWorker(shard)
+	}
+}
+
+// 所有监听shutdownChannel的worker数
+func (engine *Engine) numWorkers() int {
+	options := engine.initOptions
+	total := options.NumSegmenterThreads + 4*options.NumShards +
+		(options.NumIndexerThreadsPerShard+options.NumRankerThreadsPerShard)*options.NumShards
+	if options.UsePersistentStorage {
+		total += options.PersistentStorageShards
+	}
+	if options.StoreDocuments {
+		total += options.PersistentStorageShards
+	}
+	return total
 }
 
 // Shutdown 中止所有worker，关闭引擎
+// 重复调用或对未初始化的引擎调用不做任何事
 func (engine *Engine) Shutdown() {
-	options := engine.initOptions
-	total := options.NumSegmenterThreads + 6*options.NumShards
+	if !engine.initialized || !atomic.CompareAndSwapInt32(&engine.shutdown, 0, 1) {
+		return
+	}
+
+	if engine.wal != nil {
+		engine.closeWAL()
+	}
+
+	// 保证已接受的写请求全部写入持久存储和文档存储
+	if engine.initOptions.UsePersistentStorage || engine.documents != nil {
+		for {
+			runtime.Gosched()
+			if atomic.LoadUint64(&engine.numStoringRequests) ==
+				atomic.LoadUint64(&engine.numDocumentsStored) {
+				break
+			}
+		}
+	}
+
+	total := engine.numWorkers()
 	for i := 0; i < total; i++ {
 		engine.shutdownChannel <- true
 	}
@@ -160,9 +391,16 @@ func (engine *Engine) Shutdown() {
 	for {
 		runtime.Gosched()
 		if len(engine.shutdownChannel) == 0 {
-			return
+			break
 		}
 	}
+
+	for _, db := range engine.dbs {
+		db.Close()
+	}
+	if engine.documents != nil {
+		engine.documents.close()
+	}
 }
 
 // IndexDocument 将文档加入索引
@@ -175,17 +413,40 @@ func (engine *Engine) Shutdown() {
 // 注意：
 //      1. 这个函数是线程安全的，请尽可能并发调用以提高索引速度
 //      2. 这个函数调用是非同步的，也就是说在函数返回时有可能文档还没有加入索引中，因此
-//         如果立刻调用Search可能无法查询到这个文档。强制刷新索引请调用FlushIndex函数。
+//         如果立刻调用Search可能无法查询到这个文档。强制刷新索引请调用FlushIndex函数，
+//         需要等待单个文档可以被搜索到时请使用IndexDocumentSync。
+//      3. 使用持久存储时文档会被gob序列化，Fields的具体类型需先用gob.Register注册
 func (engine *Engine) IndexDocument(docID uint64, data types.DocumentIndexData, forceUpdate bool) {
-	engine.internalIndexDocument(docID, data, forceUpdate)
+	if err := engine.TryIndexDocument(docID, data, forceUpdate); err != nil {
+		log.Panic().Err(err).Uint64("docID", docID).Msg("无法索引文档")
+	}
 }
 
-func (engine *Engine) internalIndexDocument(
-	docID uint64, data types.DocumentIndexData, forceUpdate bool) {
+// TryIndexDocument 与IndexDocument相同，但引擎未初始化或已关闭时返回错误而不是panic
+func (engine *Engine) TryIndexDocument(docID uint64, data types.DocumentIndexData, forceUpdate bool) error {
+	if err := engine.checkState(); err != nil {
+		return err
+	}
+	if err := data.Validate(); err != nil {
+		return err
+	}
+	return engine.submitWrites([]walRecord{{docID: docID, data: data, forceUpdate: forceUpdate}}, nil)
+}
+
+// 检查引擎是否可以接受请求
+func (engine *Engine) checkState() error {
 	if !engine.initialized {
-		log.Panic().Msg("必须先初始化引擎")
+		return ErrNotInitialized
 	}
+	if atomic.LoadInt32(&engine.shutdown) != 0 {
+		return ErrShutdown
+	}
+	return nil
+}
 
+// ack不为nil时，文档加入索引器缓存和排序器后分别回执一次
+func (engine *Engine) internalIndexDocument(
+	docID uint64, data types.DocumentIndexData, forceUpdate bool, ack *writeAck) {
 	if docID != 0 {
 		atomic.AddUint64(&engine.numIndexingRequests, 1)
 	}
@@ -194,7 +455,18 @@ func (engine *Engine) internalIndexDocument(
 	}
 	hash := murmur.Murmur3([]byte(fmt.Sprintf("%d%s", docID, data.Content)))
 	engine.segmenterChannel <- segmenterRequest{
-		docID: docID, hash: hash, data: data, forceUpdate: forceUpdate}
+		docID: docID, hash: hash, data: data, forceUpdate: forceUpdate, ack: ack}
+}
+
+// 将文档的写入或删除发往持久存储，ack不为nil时写入完成后回执一次
+func (engine *Engine) persistDocument(docID uint64, data types.DocumentIndexData, remove bool, ack *writeAck) {
+	if !engine.initOptions.UsePersistentStorage || docID == 0 {
+		return
+	}
+	atomic.AddUint64(&engine.numStoringRequests, 1)
+	shard := engine.getStorageShard(docID)
+	engine.persistentStorageChannels[shard] <- persistentStorageRequest{
+		docID: docID, data: data, remove: remove, ack: ack}
 }
 
 // RemoveDocument 将文档从索引中删除
@@ -208,10 +480,21 @@ func (engine *Engine) internalIndexDocument(
 //      2. 这个函数调用是非同步的，也就是说在函数返回时有可能文档还没有加入索引中，因此
 //         如果立刻调用Search可能无法查询到这个文档。强制刷新索引请调用FlushIndex函数。
 func (engine *Engine) RemoveDocument(docID uint64, forceUpdate bool) {
-	if !engine.initialized {
-		log.Panic().Msg("必须先初始化引擎")
+	if err := engine.TryRemoveDocument(docID, forceUpdate); err != nil {
+		log.Panic().Err(err).Uint64("docID", docID).Msg("无法删除文档")
+	}
+}
+
+// TryRemoveDocument 与RemoveDocument相同，但引擎未初始化或已关闭时返回错误而不是panic
+func (engine *Engine) TryRemoveDocument(docID uint64, forceUpdate bool) error {
+	if err := engine.checkState(); err != nil {
+		return err
 	}
+	return engine.submitWrites([]walRecord{{docID: docID, remove: true, forceUpdate: forceUpdate}}, nil)
+}
 
+// ack不为nil时，每个shard的索引器和排序器删除文档后各回执一次
+func (engine *Engine) internalRemoveDocument(docID uint64, forceUpdate bool, ack *writeAck) {
 	if docID != 0 {
 		atomic.AddUint64(&engine.numRemovingRequests, 1)
 	}
@@ -219,18 +502,59 @@ func (engine *Engine) RemoveDocument(docID uint64, forceUpdate bool) {
 		atomic.AddUint64(&engine.numForceUpdatingRequests, 1)
 	}
 	for shard := 0; shard < engine.initOptions.NumShards; shard++ {
-		engine.indexerRemoveDocChannels[shard] <- indexerRemoveDocRequest{docID: docID, forceUpdate: forceUpdate}
+		engine.indexerRemoveDocChannels[shard] <- indexerRemoveDocRequest{
+			docID: docID, forceUpdate: forceUpdate, ack: ack}
 		if docID == 0 {
 			continue
 		}
-		engine.rankerRemoveDocChannels[shard] <- rankerRemoveDocRequest{docID: docID}
+		engine.rankerRemoveDocChannels[shard] <- rankerRemoveDocRequest{docID: docID, ack: ack}
+	}
+}
+
+// 搜索请求的关键词，Text不为空时为其分词结果，否则为Tokens
+func (engine *Engine) requestTokens(request types.SearchRequest) []string {
+	tokens := []string{}
+	if request.Text != "" {
+		tokens = append(tokens, engine.analyzeQuery(request.Text)...)
+	} else {
+		for _, t := range request.Tokens {
+			tokens = append(tokens, t)
+		}
 	}
+	return tokens
 }
 
 // Search 查找满足搜索条件的文档，此函数线程安全
-func (engine *Engine) Search(request types.SearchRequest) (output types.SearchResponse) {
-	if !engine.initialized {
-		log.Panic().Msg("必须先初始化引擎")
+// 引擎未初始化、已关闭或翻页游标不合法时panic，需要返回错误请使用TrySearch
+func (engine *Engine) Search(request types.SearchRequest) types.SearchResponse {
+	output, err := engine.TrySearch(request)
+	if err != nil {
+		log.Panic().Err(err).Msg("无法搜索")
+	}
+	return output
+}
+
+// TrySearch 与Search相同，但出错时返回错误而不是panic
+func (engine *Engine) TrySearch(request types.SearchRequest) (types.SearchResponse, error) {
+	return engine.SearchContext(context.Background(), request)
+}
+
+// SearchContext 查找满足搜索条件的文档，ctx取消或超时后索引器和排序器会尽快停止处理，
+// 返回已完成部分的结果（output.Timeout为true）和ctx.Err()
+//
+// request.Timeout仍然有效，其超时只设置output.Timeout而不返回错误
+func (engine *Engine) SearchContext(ctx context.Context, request types.SearchRequest) (
+	output types.SearchResponse, err error) {
+	if err = engine.checkState(); err != nil {
+		return
+	}
+
+	searchCtx := ctx
+	if request.Timeout > 0 {
+		var cancel context.CancelFunc
+		searchCtx, cancel = context.WithTimeout(ctx,
+			time.Nanosecond*time.Duration(numNanosecondsInAMillisecond*request.Timeout))
+		defer cancel()
 	}
 
 	var rankOptions types.RankOptions
@@ -242,19 +566,83 @@ func (engine *Engine) Search(request types.SearchRequest) (output types.SearchRe
 	if rankOptions.ScoringCriteria == nil {
 		rankOptions.ScoringCriteria = engine.initOptions.DefaultRankOptions.ScoringCriteria
 	}
+	if request.Cursor != "" && !request.Orderless {
+		var after types.ScoredDocument
+		if after, err = types.DecodeCursor(request.Cursor); err != nil {
+			return
+		}
+		rankOptions.SearchAfter = &after
+		rankOptions.OutputOffset = 0
+	}
 
 	// 收集关键词
 	tokens := []string{}
-	if request.Text != "" {
-		querySegments := engine.segmenter.Segment([]byte(request.Text))
-		for _, s := range querySegments {
-			tokens = append(tokens, s.Token().Text())
+	query := request.Query
+	if query == nil && request.QueryText != "" {
+		var fields map[string]types.FieldParameters
+		if parameters := engine.initOptions.IndexerInitOptions.BM25FParameters; parameters != nil {
+			fields = parameters.Fields
 		}
-	} else {
-		for _, t := range request.Tokens {
-			tokens = append(tokens, t)
+		query = parseQuery(request.QueryText, engine.analyzeQuery, fields)
+	}
+	synonyms := engine.synonymDictionary()
+	if query == nil && (request.Fuzzy != nil || engine.pinyin != nil || synonyms != nil) {
+		// 模糊匹配、拼音匹配和同义词通过布尔查询树实现，关键词之间为AND关系
+		requestTokens := engine.requestTokens(request)
+		expand := request.Fuzzy != nil
+		for _, token := range requestTokens {
+			if _, ok := pinyinQueryToken(token); ok && engine.pinyin != nil {
+				expand = true
+			}
+			if synonyms != nil && len(synonyms.expansions[token]) > 0 {
+				expand = true
+			}
+		}
+		if expand && len(requestTokens) > 0 {
+			query = &types.Query{}
+			for _, token := range requestTokens {
+				query.Must = append(query.Must, types.Query{Token: token})
+			}
 		}
 	}
+	if query != nil && request.Fuzzy != nil {
+		fuzzyOptions := *request.Fuzzy
+		fuzzyOptions.Init()
+		var expanded types.Query
+		if expanded, err = engine.expandFuzzyQuery(*query, fuzzyOptions); err != nil {
+			return
+		}
+		query = &expanded
+	}
+	if query != nil && synonyms != nil {
+		expanded := synonyms.expandQuery(*query)
+		query = &expanded
+	}
+	if query != nil && engine.pinyin != nil {
+		expanded := expandPinyinQuery(*query)
+		query = &expanded
+	}
+	if query != nil {
+		// 标签作为AND条件
+		if len(request.Labels) > 0 {
+			q := &types.Query{Must: []types.Query{*query}}
+			for _, label := range request.Labels {
+				q.Must = append(q.Must, types.Query{Token: label})
+			}
+			query = q
+		}
+		tokens = query.ScoringTokens()
+	} else {
+		tokens = engine.requestTokens(request)
+	}
+
+	// 剪枝只适用于按BM25从大到小取前若干个文档，统计分面需要全部匹配的文档，不剪枝
+	topK := 0
+	if request.Prune && isRankByBM25(rankOptions.ScoringCriteria) && len(rankOptions.SortBy) == 0 &&
+		!rankOptions.ReverseOrder && rankOptions.MaxOutputs > 0 && rankOptions.SearchAfter == nil &&
+		!request.Orderless && !request.CountDocsOnly && len(request.Facets) == 0 {
+		topK = rankOptions.OutputOffset + rankOptions.MaxOutputs
+	}
 
 	// 建立排序器返回的通信通道
 	rankerReturnChannel := make(
@@ -262,61 +650,84 @@ func (engine *Engine) Search(request types.SearchRequest) (output types.SearchRe
 
 	// 生成查找请求
 	lookupRequest := indexerLookupRequest{
+		ctx:                 searchCtx,
 		countDocsOnly:       request.CountDocsOnly,
 		tokens:              tokens,
 		labels:              request.Labels,
+		query:               query,
 		docIDs:              request.DocIDs,
+		filters:             request.Filters,
+		facets:              request.Facets,
+		topK:                topK,
 		options:             rankOptions,
 		rankerReturnChannel: rankerReturnChannel,
 		orderless:           request.Orderless,
 	}
 
-	// 向索引器发送查找请求
-	for shard := 0; shard < engine.initOptions.NumShards; shard++ {
-		engine.indexerLookupChannels[shard] <- lookupRequest
-	}
-
-	// 从通信通道读取排序器的输出
+	// 向索引器发送查找请求，再从通信通道读取排序器的输出，超时后不再等待
 	numDocs := 0
-	rankOutput := types.ScoredDocuments{}
-	timeout := request.Timeout
-	isTimeout := false
-	if timeout <= 0 {
-		// 不设置超时
-		for shard := 0; shard < engine.initOptions.NumShards; shard++ {
-			rankerOutput := <-rankerReturnChannel
-			if !request.CountDocsOnly {
-				for _, doc := range rankerOutput.docs {
-					rankOutput = append(rankOutput, doc)
+	var shardOutputs []types.ScoredDocuments
+	allSorted := true
+	isTimeout, isPartial := false, false
+	var shardErr error
+	numRequests := 0
+	for shard := 0; shard < engine.initOptions.NumShards && !isTimeout; shard++ {
+		select {
+		case engine.indexerLookupChannels[shard] <- lookupRequest:
+			numRequests++
+		case <-searchCtx.Done():
+			isTimeout = true
+		}
+	}
+	for i := 0; i < numRequests && !isTimeout; i++ {
+		select {
+		case rankerOutput := <-rankerReturnChannel:
+			if rankerOutput.err != nil {
+				if shardErr == nil {
+					shardErr = rankerOutput.err
 				}
+				continue
+			}
+			if !request.CountDocsOnly {
+				shardOutputs = append(shardOutputs, rankerOutput.docs)
+				allSorted = allSorted && (rankerOutput.sorted || len(rankerOutput.docs) == 0)
 			}
 			numDocs += rankerOutput.numDocs
-		}
-	} else {
-		// 设置超时
-		deadline := time.Now().Add(time.Nanosecond * time.Duration(numNanosecondsInAMillisecond*request.Timeout))
-		for shard := 0; shard < engine.initOptions.NumShards; shard++ {
-			select {
-			case rankerOutput := <-rankerReturnChannel:
-				if !request.CountDocsOnly {
-					for _, doc := range rankerOutput.docs {
-						rankOutput = append(rankOutput, doc)
-					}
+			for label, count := range rankerOutput.facets {
+				if output.Facets == nil {
+					output.Facets = make(map[string]int)
 				}
-				numDocs += rankerOutput.numDocs
-			case <-time.After(deadline.Sub(time.Now())):
-				isTimeout = true
-				break
+				output.Facets[label] += count
 			}
+			isPartial = isPartial || rankerOutput.timeout
+		case <-searchCtx.Done():
+			isTimeout = true
 		}
 	}
+	if shardErr != nil {
+		err = engineError(shardErr)
+		return
+	}
+	isTimeout = isTimeout || isPartial
+	if isTimeout {
+		// 只有调用者的ctx结束时才返回错误
+		err = ctx.Err()
+	}
 
-	// 再排序
-	if !request.CountDocsOnly && !request.Orderless {
-		if rankOptions.ReverseOrder {
-			sort.Sort(sort.Reverse(rankOutput))
-		} else {
-			sort.Sort(rankOutput)
+	// 各shard的输出已排好序时多路归并，否则合并后再排序
+	rankOutput := types.ScoredDocuments{}
+	if !request.CountDocsOnly && !request.Orderless && allSorted {
+		limit := 0
+		if rankOptions.MaxOutputs > 0 {
+			limit = rankOptions.OutputOffset + rankOptions.MaxOutputs
+		}
+		rankOutput = mergeScoredDocuments(shardOutputs, rankOptions, limit)
+	} else {
+		for _, docs := range shardOutputs {
+			rankOutput = append(rankOutput, docs...)
+		}
+		if !request.CountDocsOnly && !request.Orderless {
+			types.SortScoredDocuments(rankOutput, rankOptions)
 		}
 	}
 
@@ -337,15 +748,31 @@ func (engine *Engine) Search(request types.SearchRequest) (output types.SearchRe
 				end = utils.MinInt(start+rankOptions.MaxOutputs, len(rankOutput))
 			}
 			output.Docs = rankOutput[start:end]
+			if rankOptions.MaxOutputs > 0 && len(output.Docs) == rankOptions.MaxOutputs {
+				output.Cursor = types.EncodeCursor(output.Docs[len(output.Docs)-1])
+			}
 		}
 	}
+	engine.fillStoredDocuments(output.Docs, tokens, request)
 	output.NumDocs = numDocs
 	output.Timeout = isTimeout
 	return
 }
 
-// Segment 分词
+func isRankByBM25(criteria types.ScoringCriteria) bool {
+	switch criteria.(type) {
+	case types.RankByBM25, *types.RankByBM25:
+		return true
+	}
+	return false
+}
+
+// Segment 用sego分词器分词，与QueryAnalyzer无关
+// 没有设置SegmenterDictionaries时改用QueryAnalyzer分词，NotUsingSegmenter时返回nil
 func (engine *Engine) Segment(text string) (tokens []string) {
+	if !engine.segmenterLoaded {
+		return engine.analyze(engine.initOptions.QueryAnalyzer, text)
+	}
 	segments := engine.segmenter.Segment([]byte(text))
 	for _, s := range segments {
 		tokens = append(tokens, s.Token().Text())
@@ -353,8 +780,24 @@ func (engine *Engine) Segment(text string) (tokens []string) {
 	return
 }
 
-// FullSegment 分词
+// 用QueryAnalyzer对搜索的文本分词，不使用分词器时原样返回
+func (engine *Engine) analyzeQuery(text string) []string {
+	if engine.initOptions.NotUsingSegmenter {
+		return []string{text}
+	}
+	var tokens []string
+	for _, token := range engine.initOptions.QueryAnalyzer.Analyze(text) {
+		tokens = append(tokens, token.Text)
+	}
+	return tokens
+}
+
+// FullSegment 用sego分词器全切分
+// 没有设置SegmenterDictionaries时改用IndexAnalyzer分词，NotUsingSegmenter时返回nil
 func (engine *Engine) FullSegment(text string) (tokens []string) {
+	if !engine.segmenterLoaded {
+		return engine.analyze(engine.initOptions.IndexAnalyzer, text)
+	}
 	segments := engine.segmenter.FullSegment([]byte(text))
 	for _, s := range segments {
 		tokens = append(tokens, s.Token().Text())
@@ -362,8 +805,22 @@ func (engine *Engine) FullSegment(text string) (tokens []string) {
 	return
 }
 
-// Tokens 返回详细信息的分词
+// 没有sego词典时代替Segment和FullSegment，默认的分析器也依赖sego词典，因此NotUsingSegmenter时返回nil
+func (engine *Engine) analyze(analyzer types.Analyzer, text string) (tokens []string) {
+	if engine.initOptions.NotUsingSegmenter {
+		return nil
+	}
+	for _, token := range analyzer.Analyze(text) {
+		tokens = append(tokens, token.Text)
+	}
+	return
+}
+
+// Tokens 返回详细信息的分词，只在设置了SegmenterDictionaries时可用，否则返回nil
 func (engine *Engine) Tokens(text string) (tokens []*sego.Token) {
+	if !engine.segmenterLoaded {
+		return nil
+	}
 	segments := engine.segmenter.Segment([]byte(text))
 	for _, s := range segments {
 		tokens = append(tokens, s.Token())
@@ -371,8 +828,11 @@ func (engine *Engine) Tokens(text string) (tokens []*sego.Token) {
 	return
 }
 
-// FullTokens 返回详细信息的分词
+// FullTokens 返回详细信息的全切分，只在设置了SegmenterDictionaries时可用，否则返回nil
 func (engine *Engine) FullTokens(text string) (tokens []*sego.Token) {
+	if !engine.segmenterLoaded {
+		return nil
+	}
 	segments := engine.segmenter.FullSegment([]byte(text))
 	for _, s := range segments {
 		tokens = append(tokens, s.Token())
@@ -395,17 +855,26 @@ func (engine *Engine) MemoryUsage() {
 }
 
 // FlushIndex 阻塞等待直到所有索引添加完毕
+// 使用持久存储或保存文档时同时等待所有文档写入持久存储和文档存储
 func (engine *Engine) FlushIndex() {
+	if engine.checkState() != nil {
+		return
+	}
+	engine.flushIndex()
+}
+
+func (engine *Engine) flushIndex() {
 	for {
 		runtime.Gosched()
 		if engine.numIndexingRequests == engine.numDocumentsIndexed &&
-			engine.numRemovingRequests*uint64(engine.initOptions.NumShards) == engine.numDocumentsRemoved {
+			engine.numRemovingRequests*uint64(engine.initOptions.NumShards) == engine.numDocumentsRemoved &&
+			engine.numStoringRequests == engine.numDocumentsStored {
 			// 保证 CHANNEL 中 REQUESTS 全部被执行完
 			break
 		}
 	}
 	// 强制更新，保证其为最后的请求
-	engine.IndexDocument(0, types.DocumentIndexData{}, true)
+	engine.internalIndexDocument(0, types.DocumentIndexData{}, true, nil)
 	for {
 		runtime.Gosched()
 		if engine.numForceUpdatingRequests*uint64(engine.initOptions.NumShards) == engine.numDocumentsForceUpdated {
@@ -419,3 +888,8 @@ func (engine *Engine) FlushIndex() {
 func (engine *Engine) getShard(hash uint32) int {
 	return int(hash - hash/uint32(engine.initOptions.NumShards)*uint32(engine.initOptions.NumShards))
 }
+
+// 从DocID得到持久存储的裂分
+func (engine *Engine) getStorageShard(docID uint64) int {
+	return int(docID % uint64(engine.initOptions.PersistentStorageShards))
+}
@@ -1,9 +1,21 @@
 package engine
 
 import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
 	"reflect"
+	"sort"
+	"strings"
 	"testing"
 
+	"github.com/pickjunk/wuneng/analyzer"
 	"github.com/pickjunk/wuneng/types"
 	"github.com/pickjunk/wuneng/utils"
 )
@@ -521,3 +533,1231 @@ func TestEngineSegment(t *testing.T) {
 	utils.Expect(t, "19", len(outputs))
 	utils.Expect(t, "[十 三 十三 亿 都是沙雕 包括我 十三亿 莆 田 百度 baidu 广告 莆田 广 告 百度 baidu 莆田 广告]", outputs)
 }
+
+func TestPersistentStorage(t *testing.T) {
+	gob.Register(ScoringFields{})
+	folder, err := ioutil.TempDir("", "wuneng")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+
+	options := types.EngineInitOptions{
+		SegmenterDictionaries: "../test/test_dict.txt",
+		DefaultRankOptions: &types.RankOptions{
+			ScoringCriteria: TestScoringCriteria{},
+		},
+		IndexerInitOptions: &types.IndexerInitOptions{
+			IndexType: types.LocationsIndex,
+		},
+		UsePersistentStorage:    true,
+		PersistentStorageFolder: folder,
+		PersistentStorageShards: 2,
+	}
+
+	var engine Engine
+	engine.Init(options)
+	AddDocs(&engine)
+	engine.RemoveDocument(5, true)
+	engine.FlushIndex()
+	engine.Shutdown()
+
+	// 重启后从持久存储恢复
+	var engine1 Engine
+	engine1.Init(options)
+	defer engine1.Shutdown()
+
+	outputs := engine1.Search(types.SearchRequest{Text: "中国人口"})
+	utils.Expect(t, "1", len(outputs.Docs))
+	utils.Expect(t, "1", outputs.Docs[0].DocID)
+	utils.Expect(t, "18000", int(outputs.Docs[0].Scores[0]*1000))
+	utils.Expect(t, "4", engine1.NumDocumentsIndexed())
+}
+
+func TestStoredDocuments(t *testing.T) {
+	gob.Register(ScoringFields{})
+	folder, err := ioutil.TempDir("", "wuneng")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+
+	for _, storeFolder := range []string{"", folder} {
+		options := types.EngineInitOptions{
+			SegmenterDictionaries:   "../test/test_dict.txt",
+			StoreDocuments:          true,
+			DocumentStoreFolder:     storeFolder,
+			PersistentStorageShards: 2,
+		}
+		var engine Engine
+		engine.Init(options)
+		AddDocs(&engine)
+		engine.IndexDocument(6, types.DocumentIndexData{
+			TextFields: map[string]string{"title": "中国人口"},
+		}, false)
+		engine.RemoveDocument(5, false)
+		engine.FlushIndex()
+
+		docIDs := map[uint64]bool{1: true, 6: true}
+		outputs := engine.Search(types.SearchRequest{Text: "中国人口", DocIDs: docIDs, ReturnContent: true})
+		utils.Expect(t, "2", len(outputs.Docs))
+		docs := make(map[uint64]types.ScoredDocument)
+		for _, doc := range outputs.Docs {
+			docs[doc.DocID] = doc
+		}
+		utils.Expect(t, "中国有十三亿人口人口", docs[1].Content)
+		utils.Expect(t, "<nil>", docs[1].Fields)
+		utils.Expect(t, "map[title:中国人口]", docs[6].TextFields)
+
+		outputs = engine.Search(types.SearchRequest{Text: "中国人口", DocIDs: map[uint64]bool{1: true}, ReturnFields: true})
+		utils.Expect(t, "", outputs.Docs[0].Content)
+		utils.Expect(t, "{1 2 3}", outputs.Docs[0].Fields)
+
+		// 未要求时不返回
+		outputs = engine.Search(types.SearchRequest{Text: "中国人口", DocIDs: map[uint64]bool{1: true}})
+		utils.Expect(t, "", outputs.Docs[0].Content)
+
+		// 同步写请求返回时文档存储已写入
+		ctx := context.Background()
+		utils.Expect(t, "<nil>", engine.IndexDocumentSync(ctx, 7, types.DocumentIndexData{Content: "百度"}))
+		_, found, _ := engine.documents.get(7)
+		utils.Expect(t, "true", found)
+		utils.Expect(t, "<nil>", engine.RemoveDocumentSync(ctx, 7))
+		_, found, _ = engine.documents.get(7)
+		utils.Expect(t, "false", found)
+		engine.Shutdown()
+
+		if storeFolder == "" {
+			continue
+		}
+
+		// 保存在文件中的文档在重启后仍可取回
+		var engine1 Engine
+		engine1.Init(options)
+		AddDocs(&engine1)
+		documents := engine1.documents
+		_, found, err := documents.get(5)
+		utils.Expect(t, "true <nil>", fmt.Sprint(found, err))
+		engine1.RemoveDocument(5, true)
+		engine1.FlushIndex()
+		_, found, _ = documents.get(5)
+		utils.Expect(t, "false", found)
+		document, found, _ := documents.get(6)
+		utils.Expect(t, "true map[title:中国人口]", fmt.Sprint(found, document.TextFields))
+		engine1.Shutdown()
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	text := "中国有十三亿人口人口"
+	doc := types.ScoredDocument{
+		TokenLocations:        [][]int{{0}, {18, 24}},
+		TokenSnippetLocations: []int{0, 18},
+	}
+	tokens := []string{"中国", "人口"}
+	utils.Expect(t, "[<em>中国</em>有十三亿<em>人口</em><em>人口</em>]",
+		Highlight(text, tokens, doc, types.HighlightOptions{}))
+
+	// 片段在字符边界处截断，其余片段不与第一个重叠
+	utils.Expect(t, "[<em>中国</em>有 <em>人口</em>]",
+		Highlight(text, tokens, doc, types.HighlightOptions{NumFragments: 2, FragmentSize: 10}))
+
+	// 超出原文或不在字符边界上的位置被忽略，片段不跨越换行
+	doc = types.ScoredDocument{
+		TokenLocations:        [][]int{{7, 20}, {1}},
+		TokenEnds:             [][]int{{13, 26}, nil},
+		TokenSnippetLocations: []int{7, -1},
+	}
+	utils.Expect(t, "[[中国]人口]", Highlight("正文\n中国人口", []string{types.FieldKeyword("title", "中国"), "国"}, doc,
+		types.HighlightOptions{PreTag: "[", PostTag: "]"}))
+	utils.Expect(t, "[]", Highlight("正文", []string{"中国"}, doc, types.HighlightOptions{}))
+
+	// 拼音和全角字符等与原文不同的关键词按结束位置高亮
+	doc = types.ScoredDocument{
+		TokenLocations:        [][]int{{0}, {18}},
+		TokenEnds:             [][]int{{6}, {30}},
+		TokenSnippetLocations: []int{0, 18},
+	}
+	utils.Expect(t, "[<em>中国</em>有十三亿<em>ＩＰＡＤ</em>]",
+		Highlight("中国有十三亿ＩＰＡＤ", []string{types.PinyinKeyword("zg"), "ipad"}, doc, types.HighlightOptions{}))
+}
+
+func TestSearchHighlight(t *testing.T) {
+	gob.Register(ScoringFields{})
+	var engine Engine
+	engine.Init(types.EngineInitOptions{
+		SegmenterDictionaries: "../test/test_dict.txt",
+		IndexerInitOptions: &types.IndexerInitOptions{
+			IndexType: types.LocationsIndex,
+			BM25FParameters: &types.BM25FParameters{K1: 2, B: 0.75, Fields: map[string]types.FieldParameters{
+				"title": {Boost: 1},
+			}},
+		},
+		StoreDocuments: true,
+	})
+	defer engine.Shutdown()
+
+	AddDocs(&engine)
+	engine.IndexDocument(6, types.DocumentIndexData{
+		Content:    "人口",
+		TextFields: map[string]string{"title": "中国"},
+	}, true)
+	engine.FlushIndex()
+
+	outputs := engine.Search(types.SearchRequest{
+		Text: "中国人口", DocIDs: map[uint64]bool{1: true}, Highlight: &types.HighlightOptions{}})
+	utils.Expect(t, "[<em>中国</em>有十三亿<em>人口</em><em>人口</em>]", outputs.Docs[0].Highlights)
+	utils.Expect(t, "", outputs.Docs[0].Content)
+
+	outputs = engine.Search(types.SearchRequest{
+		QueryText: "title:中国", Highlight: &types.HighlightOptions{NumFragments: 2}})
+	utils.Expect(t, "[<em>中国</em>]", outputs.Docs[0].Highlights)
+
+	// 正文为空、用户输入关键词时，字段仍接在关键词的最后位置之后
+	engine.IndexDocument(7, types.DocumentIndexData{
+		Tokens:     []types.TokenData{{Text: "人口", Locations: []int{0, 12}}},
+		TextFields: map[string]string{"title": "有人口的中国"},
+	}, true)
+	engine.FlushIndex()
+	outputs = engine.Search(types.SearchRequest{
+		QueryText: "title:中国", DocIDs: map[uint64]bool{7: true}, Highlight: &types.HighlightOptions{}})
+	utils.Expect(t, "1", len(outputs.Docs))
+	utils.Expect(t, "[有人口的<em>中国</em>]", outputs.Docs[0].Highlights)
+}
+
+func TestFuzzySearch(t *testing.T) {
+	var engine Engine
+	engine.Init(types.EngineInitOptions{
+		SegmenterDictionaries: "../test/test_dict.txt",
+		NumShards:             2,
+		IndexerInitOptions: &types.IndexerInitOptions{
+			IndexType: types.LocationsIndex,
+		},
+	})
+	defer engine.Shutdown()
+
+	engine.IndexDocument(1, types.DocumentIndexData{Content: "baidu map"}, false)
+	engine.IndexDocument(2, types.DocumentIndexData{Content: "baidou"}, false)
+	engine.IndexDocument(3, types.DocumentIndexData{Content: "google map"}, false)
+	engine.FlushIndex()
+
+	outputs := engine.Search(types.SearchRequest{Text: "baidoo"})
+	utils.Expect(t, "0", len(outputs.Docs))
+
+	// 扩展出的搜索键得分低于关键词本身
+	outputs = engine.Search(types.SearchRequest{Text: "baidu", Fuzzy: &types.FuzzyOptions{MaxEdits: 1}})
+	utils.Expect(t, "[baidu baidou]", outputs.Tokens)
+	utils.Expect(t, "2", len(outputs.Docs))
+	utils.Expect(t, "1", outputs.Docs[0].DocID)
+	utils.Expect(t, "2", outputs.Docs[1].DocID)
+
+	outputs = engine.Search(types.SearchRequest{Text: "baidou", Fuzzy: &types.FuzzyOptions{MaxEdits: 1}})
+	utils.Expect(t, "2", len(outputs.Docs))
+	utils.Expect(t, "2", outputs.Docs[0].DocID)
+	utils.Expect(t, "1", outputs.Docs[1].DocID)
+
+	// 6个字符的关键词自动允许两次编辑，NOT子句不扩展
+	outputs = engine.Search(types.SearchRequest{QueryText: "baidoo NOT mop", Fuzzy: &types.FuzzyOptions{}})
+	utils.Expect(t, "[baidoo baidou baidu]", outputs.Tokens)
+	utils.Expect(t, "2", len(outputs.Docs))
+	outputs = engine.Search(types.SearchRequest{QueryText: "baidoo NOT map", Fuzzy: &types.FuzzyOptions{}})
+	utils.Expect(t, "1", len(outputs.Docs))
+	utils.Expect(t, "2", outputs.Docs[0].DocID)
+
+	outputs = engine.Search(types.SearchRequest{
+		Text: "baidoo", Fuzzy: &types.FuzzyOptions{PrefixLength: 5}})
+	utils.Expect(t, "[baidoo baidou]", outputs.Tokens)
+	utils.Expect(t, "1", len(outputs.Docs))
+}
+
+func TestPinyin(t *testing.T) {
+	dictionary, err := loadPinyinDictionary("../test/test_pinyin.txt")
+	utils.Expect(t, "<nil>", err)
+	convert := func(token string) string {
+		full, initials, ok := dictionary.convert(token)
+		return fmt.Sprint(full, " ", initials, " ", ok)
+	}
+	utils.Expect(t, "baidu bd true", convert("百度"))
+	utils.Expect(t, "zhong z true", convert("中"))
+	utils.Expect(t, "chongqing cq true", convert("重庆"))
+	utils.Expect(t, "shisanyi91 ssy91 true", convert("十三亿91"))
+	utils.Expect(t, "  false", convert("baidu"))
+	utils.Expect(t, "  false", convert("百度一下"))
+	utils.Expect(t, "  false", convert("百-度"))
+
+	var engine Engine
+	engine.Init(types.EngineInitOptions{
+		SegmenterDictionaries: "../test/test_dict.txt",
+		PinyinDictionary:      "../test/test_pinyin.txt",
+		IndexerInitOptions: &types.IndexerInitOptions{
+			IndexType: types.LocationsIndex,
+			BM25FParameters: &types.BM25FParameters{K1: 2, B: 0.75, Fields: map[string]types.FieldParameters{
+				"title": {Boost: 1},
+			}},
+		},
+	})
+	defer engine.Shutdown()
+
+	engine.IndexDocument(1, types.DocumentIndexData{Content: "百度"}, false)
+	engine.IndexDocument(2, types.DocumentIndexData{Content: "中国有十三亿人口"}, false)
+	engine.IndexDocument(3, types.DocumentIndexData{
+		Content:    "bd wuneng",
+		TextFields: map[string]string{"title": "中国"},
+	}, false)
+	engine.FlushIndex()
+
+	outputs := engine.Search(types.SearchRequest{Text: "baidu"})
+	utils.Expect(t, "[baidu pinyin#baidu]", outputs.Tokens)
+	utils.Expect(t, "1", len(outputs.Docs))
+	utils.Expect(t, "1", outputs.Docs[0].DocID)
+
+	// 拼音与原文中的字母关键词都能匹配
+	outputs = engine.Search(types.SearchRequest{Text: "BD"})
+	utils.Expect(t, "2", len(outputs.Docs))
+
+	outputs = engine.Search(types.SearchRequest{QueryText: "zhongguo AND renkou"})
+	utils.Expect(t, "1", len(outputs.Docs))
+	utils.Expect(t, "2", outputs.Docs[0].DocID)
+
+	outputs = engine.Search(types.SearchRequest{QueryText: "title:zg"})
+	utils.Expect(t, "[field#title:zg field#title:pinyin#zg]", outputs.Tokens)
+	utils.Expect(t, "1", len(outputs.Docs))
+	utils.Expect(t, "3", outputs.Docs[0].DocID)
+
+	// 汉字关键词不扩展，拼音不计入文档长度
+	outputs = engine.Search(types.SearchRequest{Text: "百度"})
+	utils.Expect(t, "[百度]", outputs.Tokens)
+	utils.Expect(t, "1", len(outputs.Docs))
+
+	// 自动补全不返回拼音搜索键
+	suggestions, _ := engine.Suggest("pin", 10, nil)
+	utils.Expect(t, "[]", suggestions)
+	suggestions, _ = engine.Suggest("b", 10, nil)
+	utils.Expect(t, "[{baidu 1} {bd 1}]", suggestions)
+}
+
+func TestAnalyzers(t *testing.T) {
+	_, err := New(types.EngineInitOptions{IndexAnalyzer: analyzer.BigramAnalyzer{}})
+	utils.Expect(t, "true", errors.Is(err, types.ErrEmptyDictionaries))
+
+	var engine Engine
+	engine.Init(types.EngineInitOptions{
+		IndexAnalyzer: analyzer.BigramAnalyzer{},
+		QueryAnalyzer: analyzer.BigramAnalyzer{},
+		IndexerInitOptions: &types.IndexerInitOptions{
+			IndexType: types.LocationsIndex,
+			BM25FParameters: &types.BM25FParameters{K1: 2, B: 0.75, Fields: map[string]types.FieldParameters{
+				"title": {Boost: 1},
+			}},
+		},
+	})
+	defer engine.Shutdown()
+
+	// 没有sego词典时用配置的分析器分词
+	utils.Expect(t, "[中国 国人 人口]", engine.Segment("中国人口"))
+	utils.Expect(t, "[中国 国人 人口]", engine.FullSegment("中国人口"))
+	utils.Expect(t, "0", len(engine.Tokens("中国人口")))
+	utils.Expect(t, "0", len(engine.FullTokens("中国人口")))
+
+	engine.IndexDocument(1, types.DocumentIndexData{Content: "中国人口"}, false)
+	engine.IndexDocument(2, types.DocumentIndexData{
+		Content:    "百度收购91无线",
+		TextFields: map[string]string{"title": "中国互联网"},
+	}, false)
+	engine.FlushIndex()
+
+	outputs := engine.Search(types.SearchRequest{Text: "国人"})
+	utils.Expect(t, "[国人]", outputs.Tokens)
+	utils.Expect(t, "1", len(outputs.Docs))
+	utils.Expect(t, "[[3]]", outputs.Docs[0].TokenLocations)
+
+	outputs = engine.Search(types.SearchRequest{QueryText: "title:中国 91"})
+	utils.Expect(t, "[field#title:中国 91]", outputs.Tokens)
+	utils.Expect(t, "1", len(outputs.Docs))
+	utils.Expect(t, "2", outputs.Docs[0].DocID)
+
+	outputs = engine.Search(types.SearchRequest{Text: "中国人"})
+	utils.Expect(t, "[中国 国人]", outputs.Tokens)
+	utils.Expect(t, "1", len(outputs.Docs))
+}
+
+func TestTokenFilters(t *testing.T) {
+	var engine Engine
+	engine.Init(types.EngineInitOptions{
+		SegmenterDictionaries: "../test/test_dict.txt",
+		StopTokenFile:         "../test/test_stop_tokens.txt",
+		TokenFilters:          []types.TokenFilter{analyzer.WidthFilter{}, analyzer.LowercaseFilter{}, analyzer.PunctuationFilter{}},
+		StoreDocuments:        true,
+		IndexerInitOptions: &types.IndexerInitOptions{
+			IndexType: types.LocationsIndex,
+		},
+	})
+	defer engine.Shutdown()
+
+	engine.IndexDocument(1, types.DocumentIndexData{Content: "中国的人口，十三亿"}, false)
+	engine.IndexDocument(2, types.DocumentIndexData{Content: "iPhone的中国"}, false)
+	engine.FlushIndex()
+
+	// 停用词和标点不加入索引
+	suggestions, _ := engine.Suggest("的", 10, nil)
+	utils.Expect(t, "[]", suggestions)
+	suggestions, _ = engine.Suggest("，", 10, nil)
+	utils.Expect(t, "[]", suggestions)
+
+	// 搜索时同样去掉停用词
+	outputs := engine.Search(types.SearchRequest{Text: "中国的人口"})
+	utils.Expect(t, "[中国 人口]", outputs.Tokens)
+	utils.Expect(t, "1", len(outputs.Docs))
+	utils.Expect(t, "1", outputs.Docs[0].DocID)
+
+	outputs = engine.Search(types.SearchRequest{
+		QueryText: "IPHONE 的",
+		Highlight: &types.HighlightOptions{},
+	})
+	utils.Expect(t, "[iphone]", outputs.Tokens)
+	utils.Expect(t, "1", len(outputs.Docs))
+	utils.Expect(t, "[<em>iPhone</em>的中国]", outputs.Docs[0].Highlights)
+
+	// 全角字符转为半角后长度改变，按原文中的范围高亮
+	engine.IndexDocument(3, types.DocumentIndexData{Content: "ｉＰａｄ的屏幕"}, false)
+	engine.FlushIndex()
+	outputs = engine.Search(types.SearchRequest{Text: "Ｐ", Highlight: &types.HighlightOptions{}})
+	utils.Expect(t, "[p]", outputs.Tokens)
+	utils.Expect(t, "1", len(outputs.Docs))
+	utils.Expect(t, "[ｉ<em>Ｐ</em>ａｄ的屏幕]", outputs.Docs[0].Highlights)
+}
+
+func TestSynonyms(t *testing.T) {
+	rules, err := parseSynonyms(strings.NewReader("# 注释\n\n土豆，马铃薯, 洋芋\n番茄 => 西红柿,圣女果\n"))
+	utils.Expect(t, "<nil>", err)
+	utils.Expect(t, "[{[土豆 马铃薯 洋芋] []} {[番茄] [西红柿 圣女果]}]", rules)
+	_, err = parseSynonyms(strings.NewReader("番茄 =>"))
+	utils.Expect(t, "true", errors.Is(err, ErrInvalidSynonym))
+	_, err = parseSynonyms(strings.NewReader("a\n番茄"))
+	utils.Expect(t, "true", errors.Is(err, ErrInvalidSynonym))
+
+	var engine Engine
+	engine.Init(types.EngineInitOptions{
+		SegmenterDictionaries: "../test/test_dict.txt",
+		SynonymFile:           "../test/test_synonyms.txt",
+	})
+	defer engine.Shutdown()
+
+	engine.IndexDocument(1, types.DocumentIndexData{Content: "中国"}, false)
+	engine.IndexDocument(2, types.DocumentIndexData{Content: "国有"}, false)
+	engine.IndexDocument(3, types.DocumentIndexData{Content: "十三亿"}, false)
+	engine.IndexDocument(4, types.DocumentIndexData{Content: "人口"}, false)
+	engine.FlushIndex()
+
+	search := func(request types.SearchRequest) string {
+		outputs := engine.Search(request)
+		var docIDs []uint64
+		for _, doc := range outputs.Docs {
+			docIDs = append(docIDs, doc.DocID)
+		}
+		sort.Slice(docIDs, func(i, j int) bool { return docIDs[i] < docIDs[j] })
+		return fmt.Sprint(outputs.Tokens, docIDs)
+	}
+
+	// 双向同义词
+	utils.Expect(t, "[中国 国有] [1 2]", search(types.SearchRequest{Text: "中国"}))
+	utils.Expect(t, "[国有 中国] [1 2]", search(types.SearchRequest{Text: "国有"}))
+
+	// 单向同义词，NOT子句不展开
+	utils.Expect(t, "[人口 十三亿] [3 4]", search(types.SearchRequest{Text: "人口"}))
+	utils.Expect(t, "[十三亿] [3]", search(types.SearchRequest{Text: "十三亿"}))
+	utils.Expect(t, "[中国 国有] [1 2]", search(types.SearchRequest{QueryText: "中国 NOT 人口"}))
+
+	// 运行时替换，多个关键词的同义词作为短语
+	utils.Expect(t, "<nil>", engine.SetSynonyms([]types.SynonymRule{
+		{Words: []string{"人口"}, Synonyms: []string{"中国有"}},
+	}))
+	utils.Expect(t, "[中国] [1]", search(types.SearchRequest{Text: "中国"}))
+	utils.Expect(t, "[人口 中国 有] [4]", search(types.SearchRequest{Text: "人口"}))
+
+	utils.Expect(t, "<nil>", engine.LoadSynonyms("../test/test_synonyms.txt"))
+	utils.Expect(t, "[中国 国有] [1 2]", search(types.SearchRequest{Text: "中国"}))
+	utils.Expect(t, "true", errors.Is(engine.LoadSynonyms("../test/no_such_file.txt"), ErrDictionaryNotFound))
+
+	utils.Expect(t, "<nil>", engine.SetSynonyms(nil))
+	utils.Expect(t, "[中国] [1]", search(types.SearchRequest{Text: "中国"}))
+}
+
+func TestSuggest(t *testing.T) {
+	var engine Engine
+	engine.Init(types.EngineInitOptions{
+		SegmenterDictionaries: "../test/test_dict.txt",
+		NumShards:             3,
+	})
+	defer engine.Shutdown()
+
+	addDocsWithLabels(&engine)
+
+	suggestions, err := engine.Suggest("中", 10, nil)
+	utils.Expect(t, "<nil>", err)
+	utils.Expect(t, "[{中 3} {中国 3}]", suggestions)
+
+	suggestions, _ = engine.Suggest("百", 1, nil)
+	utils.Expect(t, "[{百度 5}]", suggestions)
+
+	suggestions, _ = engine.Suggest("百", 10, []string{"中国"})
+	utils.Expect(t, "[{百度 3} {百 2}]", suggestions)
+
+	suggestions, _ = engine.Suggest("谷", 10, nil)
+	utils.Expect(t, "[]", suggestions)
+}
+
+func TestParseQuery(t *testing.T) {
+	segment := func(term string) []string { return []string{term} }
+	fields := map[string]types.FieldParameters{"title": {}, "tags": {}}
+	utils.Expect(t, "a", parseQuery("a", segment, fields))
+	utils.Expect(t, "(a AND b)", parseQuery("a b", segment, fields))
+	utils.Expect(t, "(a AND b)", parseQuery("a AND b", segment, fields))
+	utils.Expect(t, "(a OR b)", parseQuery("a OR b", segment, fields))
+	utils.Expect(t, "(a AND NOT b)", parseQuery("a NOT b", segment, fields))
+	utils.Expect(t, "(NOT a)", parseQuery("NOT a", segment, fields))
+	utils.Expect(t, "a", parseQuery("NOT NOT a", segment, fields))
+	utils.Expect(t, "(手机 AND (苹果 OR 华为) AND NOT 二手)",
+		parseQuery("手机 AND (苹果 OR 华为) NOT 二手", segment, fields))
+	// OR优先级低于AND
+	utils.Expect(t, "((a AND b) OR c)", parseQuery("a b OR c", segment, fields))
+
+	// 宽松解析
+	utils.Expect(t, "(a OR b)", parseQuery("(a OR b", segment, fields))
+	utils.Expect(t, "(a AND b)", parseQuery("a) b OR", segment, fields))
+	utils.Expect(t, "()", parseQuery("", segment, fields))
+	utils.Expect(t, "a", parseQuery("a NOT )", segment, fields))
+	utils.Expect(t, "(a OR b)", parseQuery("a NOT OR b", segment, fields))
+	utils.Expect(t, "(a AND b)", parseQuery("a NOT AND b", segment, fields))
+	utils.Expect(t, "a", parseQuery("a NOT", segment, fields))
+	utils.Expect(t, "(a AND (NOT b))", parseQuery("a (NOT NOT NOT b)", segment, fields))
+
+	// 短语
+	utils.Expect(t, `("a b" AND c)`, parseQuery(`"a b" c`, segment, fields))
+	utils.Expect(t, `("a b"~3 OR "c d"@10)`, parseQuery(`"a b"~3 OR “c d”@10`, segment, fields))
+	utils.Expect(t, `("a b"~1t AND "c d"@4t AND "e f"~0t)`, parseQuery(`"a b"~1t "c d"@4t "e f"~0t`, segment, fields))
+	utils.Expect(t, `(a AND "b c")`, parseQuery(`a "b c`, segment, fields))
+	utils.Expect(t, "a", parseQuery(`"a"`, segment, fields))
+
+	// 限定字段
+	utils.Expect(t, "(title:a AND b)", parseQuery("title:a b", segment, fields))
+	utils.Expect(t, `(title:"a b" OR (NOT tags:c))`, parseQuery(`title:"a b" OR NOT tags:c`, segment, fields))
+	utils.Expect(t, ":a", parseQuery(":a", segment, fields))
+	utils.Expect(t, "a:", parseQuery("a:", segment, fields))
+
+	// 未声明的字段名原样作为词，如"前缀:值"形式的标签
+	query := parseQuery("category:phone", segment, fields)
+	utils.Expect(t, "", query.Field)
+	utils.Expect(t, "category:phone", query.Token)
+	query = parseQuery(`category:"a b"`, segment, fields)
+	utils.Expect(t, `(category: AND "a b")`, query)
+	utils.Expect(t, "", parseQuery("title:a", segment, nil).Field)
+
+	// 一个词的多个分词结果之间为AND
+	utils.Expect(t, "((a1 AND a2) OR b)", parseQuery("a OR（b）", func(term string) []string {
+		if term == "b" {
+			return []string{term}
+		}
+		return []string{term + "1", term + "2"}
+	}, fields))
+}
+
+func TestSearchWithQuery(t *testing.T) {
+	var engine Engine
+	engine.Init(types.EngineInitOptions{
+		SegmenterDictionaries: "../test/test_dict.txt",
+		DefaultRankOptions: &types.RankOptions{
+			ScoringCriteria: &RankByTokenProximity{},
+		},
+		IndexerInitOptions: &types.IndexerInitOptions{
+			IndexType: types.LocationsIndex,
+		},
+	})
+	defer engine.Shutdown()
+
+	AddDocs(&engine)
+
+	outputs := engine.Search(types.SearchRequest{QueryText: "人口 NOT 中国"})
+	utils.Expect(t, "[人口]", outputs.Tokens)
+	utils.Expect(t, "2", len(outputs.Docs))
+	utils.Expect(t, "2", outputs.NumDocs)
+
+	outputs = engine.Search(types.SearchRequest{QueryText: "中国 (十三亿 OR 有)", CountDocsOnly: true})
+	utils.Expect(t, "[中国 十三亿 有]", outputs.Tokens)
+	utils.Expect(t, "2", outputs.NumDocs)
+
+	outputs = engine.Search(types.SearchRequest{
+		Query: &types.Query{Should: []types.Query{{Token: "中国"}, {Token: "有"}}},
+	})
+	utils.Expect(t, "5", outputs.NumDocs)
+	utils.Expect(t, "2", len(outputs.Docs[0].TokenSnippetLocations))
+}
+
+func TestSearchWithPhrase(t *testing.T) {
+	var engine Engine
+	engine.Init(types.EngineInitOptions{
+		SegmenterDictionaries: "../test/test_dict.txt",
+		DefaultRankOptions: &types.RankOptions{
+			ScoringCriteria: &RankByTokenProximity{},
+		},
+		IndexerInitOptions: &types.IndexerInitOptions{
+			IndexType: types.LocationsIndex,
+		},
+	})
+	defer engine.Shutdown()
+
+	AddDocs(&engine)
+
+	outputs := engine.Search(types.SearchRequest{QueryText: `"中国 人口"`})
+	utils.Expect(t, "[中国 人口]", outputs.Tokens)
+	utils.Expect(t, "1", len(outputs.Docs))
+	utils.Expect(t, "2", outputs.Docs[0].DocID)
+
+	outputs = engine.Search(types.SearchRequest{QueryText: `"中国 人口"~9`})
+	utils.Expect(t, "2", len(outputs.Docs))
+	utils.Expect(t, "2", outputs.Docs[0].DocID)
+	utils.Expect(t, "5", outputs.Docs[1].DocID)
+
+	outputs = engine.Search(types.SearchRequest{QueryText: `"人口 中国"@21`})
+	utils.Expect(t, "2", len(outputs.Docs))
+	utils.Expect(t, "2", outputs.Docs[0].DocID)
+	utils.Expect(t, "5", outputs.Docs[1].DocID)
+
+	// 以关键词个数为单位，"中国十三亿人口"中间夹杂一个关键词，"中国有十三亿人口人口"中间夹杂两个
+	outputs = engine.Search(types.SearchRequest{QueryText: `"中国 人口"~1t`})
+	utils.Expect(t, "2", len(outputs.Docs))
+	utils.Expect(t, "5", outputs.Docs[1].DocID)
+	outputs = engine.Search(types.SearchRequest{QueryText: `"中国 人口"~2t`})
+	utils.Expect(t, "3", len(outputs.Docs))
+}
+
+func TestSearchWithFields(t *testing.T) {
+	var engine Engine
+	engine.Init(types.EngineInitOptions{
+		SegmenterDictionaries: "../test/test_dict.txt",
+		DefaultRankOptions: &types.RankOptions{
+			ScoringCriteria: types.RankByBM25F{},
+		},
+		IndexerInitOptions: &types.IndexerInitOptions{
+			IndexType:      types.LocationsIndex,
+			BM25Parameters: &types.BM25Parameters{K1: 2, B: 0.75},
+			BM25FParameters: &types.BM25FParameters{K1: 2, B: 0.75, Fields: map[string]types.FieldParameters{
+				"title": {Boost: 5, B: 0.5},
+				"body":  {Boost: 1, B: 0.75},
+			}},
+		},
+	})
+	defer engine.Shutdown()
+
+	engine.IndexDocument(1, types.DocumentIndexData{
+		TextFields: map[string]string{"title": "有十三亿", "body": "中国人口"},
+	}, false)
+	engine.IndexDocument(2, types.DocumentIndexData{
+		TextFields: map[string]string{"title": "中国人口", "body": "有十三亿"},
+	}, false)
+	engine.IndexDocument(3, types.DocumentIndexData{
+		Content: "中国人口",
+	}, false)
+	engine.FlushIndex()
+
+	// 标题中的关键词得分更高
+	outputs := engine.Search(types.SearchRequest{Text: "中国"})
+	utils.Expect(t, "3", len(outputs.Docs))
+	utils.Expect(t, "2", outputs.Docs[0].DocID)
+
+	outputs = engine.Search(types.SearchRequest{QueryText: "title:中国"})
+	utils.Expect(t, "[field#title:中国]", outputs.Tokens)
+	utils.Expect(t, "1", len(outputs.Docs))
+	utils.Expect(t, "2", outputs.Docs[0].DocID)
+
+	outputs = engine.Search(types.SearchRequest{QueryText: `body:"中国 人口" NOT title:十三亿`})
+	utils.Expect(t, "0", len(outputs.Docs))
+	outputs = engine.Search(types.SearchRequest{QueryText: `"中国 人口" NOT title:十三亿`})
+	utils.Expect(t, "2", len(outputs.Docs))
+}
+
+func TestIndexDocumentSync(t *testing.T) {
+	var engine Engine
+	engine.Init(types.EngineInitOptions{
+		SegmenterDictionaries: "../test/test_dict.txt",
+		DefaultRankOptions: &types.RankOptions{
+			ScoringCriteria: TestScoringCriteria{},
+		},
+	})
+	defer engine.Shutdown()
+
+	ctx := context.Background()
+	err := engine.IndexDocumentSync(ctx, 1, types.DocumentIndexData{
+		Content: "中国有十三亿人口人口",
+		Fields:  ScoringFields{1, 2, 3},
+	})
+	utils.Expect(t, "<nil>", err)
+
+	// 无需FlushIndex即可搜索到
+	outputs := engine.Search(types.SearchRequest{Text: "中国人口"})
+	utils.Expect(t, "1", len(outputs.Docs))
+
+	err = engine.IndexDocumentsSync(ctx, []types.BatchDocument{
+		{DocID: 2, Data: types.DocumentIndexData{Content: "中国人口", Fields: ScoringFields{1, 2, 3}}},
+		{DocID: 5, Data: types.DocumentIndexData{Content: "中国十三亿人口", Fields: ScoringFields{0, 9, 1}}},
+	})
+	utils.Expect(t, "<nil>", err)
+	outputs = engine.Search(types.SearchRequest{Text: "中国人口"})
+	utils.Expect(t, "3", len(outputs.Docs))
+
+	utils.Expect(t, "<nil>", engine.RemoveDocumentSync(ctx, 2))
+	outputs = engine.Search(types.SearchRequest{Text: "中国人口"})
+	utils.Expect(t, "2", len(outputs.Docs))
+	utils.Expect(t, "5", outputs.Docs[0].DocID)
+	utils.Expect(t, "1", outputs.Docs[1].DocID)
+
+	// 非法文档和已取消的ctx
+	utils.Expect(t, ErrInvalidDocID.Error(), engine.IndexDocumentSync(ctx, 0, types.DocumentIndexData{}))
+	utils.Expect(t, ErrInvalidDocID.Error(), engine.RemoveDocumentSync(ctx, 0))
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	utils.Expect(t, context.Canceled.Error(), engine.IndexDocumentSync(cancelled, 3, types.DocumentIndexData{
+		Content: "有人口",
+	}))
+}
+
+func TestErrors(t *testing.T) {
+	_, err := New(types.EngineInitOptions{})
+	utils.Expect(t, "true", errors.Is(err, types.ErrEmptyDictionaries))
+	_, err = New(types.EngineInitOptions{SegmenterDictionaries: "../test/not_exist.txt"})
+	utils.Expect(t, "true", errors.Is(err, ErrDictionaryNotFound))
+	_, err = New(types.EngineInitOptions{
+		SegmenterDictionaries: "../test/test_dict.txt",
+		IndexerInitOptions: &types.IndexerInitOptions{
+			BM25FParameters: &types.BM25FParameters{Fields: map[string]types.FieldParameters{"field#title": {Boost: 2}}},
+		},
+	})
+	utils.Expect(t, "true", errors.Is(err, types.ErrInvalidFieldName))
+
+	var uninitialized Engine
+	_, err = uninitialized.TrySearch(types.SearchRequest{Text: "中国"})
+	utils.Expect(t, "true", errors.Is(err, ErrNotInitialized))
+	err = uninitialized.TryIndexDocument(1, types.DocumentIndexData{Content: "中国"}, false)
+	utils.Expect(t, "true", errors.Is(err, ErrNotInitialized))
+	err = uninitialized.TryRemoveDocument(1, false)
+	utils.Expect(t, "true", errors.Is(err, ErrNotInitialized))
+	uninitialized.Shutdown()
+
+	engine, err := New(types.EngineInitOptions{
+		SegmenterDictionaries: "../test/test_dict.txt",
+	})
+	utils.Expect(t, "<nil>", err)
+	utils.Expect(t, "true", errors.Is(engine.init(engine.initOptions), ErrAlreadyInitialized))
+	utils.Expect(t, "<nil>", engine.TryIndexDocument(1, types.DocumentIndexData{Content: "中国"}, true))
+
+	// 字段名和标签不能与字段关键词、拼音关键词混淆
+	err = engine.TryIndexDocument(2, types.DocumentIndexData{
+		TextFields: map[string]string{"a:b": "中国"},
+	}, true)
+	utils.Expect(t, "true", errors.Is(err, types.ErrInvalidFieldName))
+	err = engine.TryIndexDocument(2, types.DocumentIndexData{Content: "中国", Labels: []string{"field#title:中国"}}, true)
+	utils.Expect(t, "true", errors.Is(err, types.ErrReservedLabel))
+	err = engine.IndexDocumentSync(context.Background(), 2, types.DocumentIndexData{Labels: []string{"pinyin#zhongguo"}})
+	utils.Expect(t, "true", errors.Is(err, types.ErrReservedLabel))
+	engine.FlushIndex()
+	outputs, err := engine.TrySearch(types.SearchRequest{Text: "中国"})
+	utils.Expect(t, "<nil>", err)
+	utils.Expect(t, "1", len(outputs.Docs))
+
+	// 关闭后返回错误，重复关闭无副作用
+	engine.Shutdown()
+	engine.Shutdown()
+	_, err = engine.TrySearch(types.SearchRequest{Text: "中国"})
+	utils.Expect(t, "true", errors.Is(err, ErrShutdown))
+	err = engine.IndexDocumentSync(context.Background(), 2, types.DocumentIndexData{Content: "中国"})
+	utils.Expect(t, "true", errors.Is(err, ErrShutdown))
+}
+
+func TestSearchContext(t *testing.T) {
+	var engine Engine
+	engine.Init(types.EngineInitOptions{
+		SegmenterDictionaries: "../test/test_dict.txt",
+		DefaultRankOptions: &types.RankOptions{
+			ScoringCriteria: TestScoringCriteria{},
+		},
+	})
+	defer engine.Shutdown()
+
+	AddDocs(&engine)
+
+	outputs, err := engine.SearchContext(context.Background(), types.SearchRequest{Text: "中国人口"})
+	utils.Expect(t, "<nil>", err)
+	utils.Expect(t, "false", outputs.Timeout)
+	utils.Expect(t, "2", len(outputs.Docs))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	outputs, err = engine.SearchContext(ctx, types.SearchRequest{Text: "中国人口"})
+	utils.Expect(t, context.Canceled.Error(), err)
+	utils.Expect(t, "true", outputs.Timeout)
+	utils.Expect(t, "[中国 人口]", outputs.Tokens)
+
+	// 未超时的Timeout不影响结果
+	outputs, err = engine.SearchContext(context.Background(), types.SearchRequest{Text: "中国人口", Timeout: 1000})
+	utils.Expect(t, "<nil>", err)
+	utils.Expect(t, "false", outputs.Timeout)
+	utils.Expect(t, "2", len(outputs.Docs))
+}
+
+func TestSnapshot(t *testing.T) {
+	gob.Register(ScoringFields{})
+	options := types.EngineInitOptions{
+		SegmenterDictionaries: "../test/test_dict.txt",
+		DefaultRankOptions: &types.RankOptions{
+			ScoringCriteria: TestScoringCriteria{},
+		},
+		IndexerInitOptions: &types.IndexerInitOptions{
+			IndexType: types.LocationsIndex,
+		},
+	}
+
+	var engine Engine
+	engine.Init(options)
+	AddDocs(&engine)
+	engine.RemoveDocument(5, true)
+
+	var buf bytes.Buffer
+	if err := engine.Snapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	engine.Shutdown()
+
+	var engine1 Engine
+	engine1.Init(options)
+	defer engine1.Shutdown()
+	if err := engine1.Restore(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+
+	outputs := engine1.Search(types.SearchRequest{Text: "中国人口"})
+	utils.Expect(t, "1", len(outputs.Docs))
+	utils.Expect(t, "1", outputs.Docs[0].DocID)
+	utils.Expect(t, "18000", int(outputs.Docs[0].Scores[0]*1000))
+
+	// 裂分数不一致
+	options.NumShards = 3
+	var engine2 Engine
+	engine2.Init(options)
+	defer engine2.Shutdown()
+	err := engine2.Restore(bytes.NewReader(buf.Bytes()))
+	utils.Expect(t, "true", errors.Is(err, ErrSnapshotFormat))
+	utils.Expect(t, "true", errors.Is(engine2.Restore(bytes.NewReader([]byte("wuneng"))), ErrSnapshotFormat))
+}
+
+func TestWAL(t *testing.T) {
+	gob.Register(ScoringFields{})
+	folder, err := ioutil.TempDir("", "wuneng")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+
+	options := types.EngineInitOptions{
+		SegmenterDictionaries: "../test/test_dict.txt",
+		DefaultRankOptions: &types.RankOptions{
+			ScoringCriteria: TestScoringCriteria{},
+		},
+		IndexerInitOptions: &types.IndexerInitOptions{
+			IndexType: types.LocationsIndex,
+		},
+		UseWAL:                  true,
+		PersistentStorageFolder: folder,
+	}
+	walPath := filepath.Join(folder, WALFileName)
+
+	// 不调用Shutdown模拟进程崩溃
+	var engine Engine
+	engine.Init(options)
+	AddDocs(&engine)
+	engine.RemoveDocument(5, false)
+
+	// 末尾写入不完整的记录
+	file, err := os.OpenFile(walPath, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write([]byte{100, 1, 2})
+	file.Close()
+
+	var engine1 Engine
+	engine1.Init(options)
+	outputs := engine1.Search(types.SearchRequest{Text: "中国人口"})
+	utils.Expect(t, "1", len(outputs.Docs))
+	utils.Expect(t, "1", outputs.Docs[0].DocID)
+	utils.Expect(t, "18000", int(outputs.Docs[0].Scores[0]*1000))
+
+	// 检查点后日志被清空，索引保存在快照中
+	if err := engine1.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	info, _ := os.Stat(walPath)
+	utils.Expect(t, "0", info.Size())
+	engine1.IndexDocument(6, types.DocumentIndexData{
+		Content: "中国人口",
+		Fields:  ScoringFields{1, 1, 1},
+	}, false)
+
+	var engine2 Engine
+	engine2.Init(options)
+	outputs = engine2.Search(types.SearchRequest{Text: "中国人口"})
+	utils.Expect(t, "2", len(outputs.Docs))
+
+	// 正常关闭时做检查点
+	engine2.Shutdown()
+	info, _ = os.Stat(walPath)
+	utils.Expect(t, "0", info.Size())
+	utils.Expect(t, ErrShutdown.Error(), engine2.TryRemoveDocument(6, false).Error())
+
+	var engine3 Engine
+	engine3.Init(options)
+	defer engine3.Shutdown()
+	outputs = engine3.Search(types.SearchRequest{Text: "中国人口"})
+	utils.Expect(t, "2", len(outputs.Docs))
+
+	_, err = New(types.EngineInitOptions{
+		SegmenterDictionaries: "../test/test_dict.txt",
+		UseWAL:                true,
+	})
+	utils.Expect(t, "true", errors.Is(err, types.ErrEmptyStorageFolder))
+}
+
+func TestFacets(t *testing.T) {
+	var engine Engine
+	engine.Init(types.EngineInitOptions{
+		SegmenterDictionaries: "../test/test_dict.txt",
+		IndexerInitOptions: &types.IndexerInitOptions{
+			IndexType: types.LocationsIndex,
+		},
+	})
+	defer engine.Shutdown()
+
+	engine.IndexDocument(1, types.DocumentIndexData{
+		Content: "中国有十三亿人口",
+		Labels:  []string{"category:人口", "source:统计局"},
+		Fields:  ScoringFields{A: 1, B: 1, C: 1},
+	}, false)
+	engine.IndexDocument(2, types.DocumentIndexData{
+		Content: "中国人口",
+		Labels:  []string{"category:人口", "source:新闻"},
+	}, false)
+	engine.IndexDocument(3, types.DocumentIndexData{
+		Content: "中国经济",
+		Labels:  []string{"category:经济", "source:新闻"},
+	}, false)
+	engine.IndexDocument(4, types.DocumentIndexData{
+		Content: "美国人口",
+		Labels:  []string{"category:人口"},
+	}, false)
+	engine.FlushIndex()
+
+	outputs := engine.Search(types.SearchRequest{Text: "中国", Facets: []string{"category:", "source:"}})
+	utils.Expect(t, "3", outputs.NumDocs)
+	utils.Expect(t, "map[category:人口:2 category:经济:1 source:新闻:2 source:统计局:1]", outputs.Facets)
+
+	outputs = engine.Search(types.SearchRequest{
+		Text: "人口", Facets: []string{"category:"}, CountDocsOnly: true})
+	utils.Expect(t, "3", outputs.NumDocs)
+	utils.Expect(t, "map[category:人口:3]", outputs.Facets)
+
+	outputs = engine.Search(types.SearchRequest{Text: "人口"})
+	utils.Expect(t, "map[]", outputs.Facets)
+
+	// 只统计排序器计入NumDocs的文档
+	outputs = engine.Search(types.SearchRequest{
+		Text: "中国", Facets: []string{"category:", "source:"},
+		RankOptions: &types.RankOptions{ScoringCriteria: TestScoringCriteria{}}})
+	utils.Expect(t, "1", outputs.NumDocs)
+	utils.Expect(t, "map[category:人口:1 source:统计局:1]", outputs.Facets)
+}
+
+func TestSearchWithFilters(t *testing.T) {
+	var engine Engine
+	engine.Init(types.EngineInitOptions{
+		SegmenterDictionaries: "../test/test_dict.txt",
+		IndexerInitOptions: &types.IndexerInitOptions{
+			IndexType: types.LocationsIndex,
+		},
+	})
+	defer engine.Shutdown()
+
+	engine.IndexDocument(1, types.DocumentIndexData{
+		Content:    "中国有十三亿人口",
+		Attributes: map[string]float64{"price": 10, "ts": 1577836800},
+	}, false)
+	engine.IndexDocument(2, types.DocumentIndexData{
+		Content:    "中国人口",
+		Attributes: map[string]float64{"price": 150, "ts": 1577836900},
+	}, false)
+	engine.IndexDocument(3, types.DocumentIndexData{
+		Content:    "中国十三亿人口",
+		Attributes: map[string]float64{"price": 100},
+	}, false)
+	engine.IndexDocument(4, types.DocumentIndexData{
+		Content: "有人口",
+	}, false)
+	engine.FlushIndex()
+
+	between, err := types.ParseRangeFilter("price BETWEEN 10 AND 100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	outputs := engine.Search(types.SearchRequest{Text: "人口", Filters: []types.RangeFilter{between}})
+	utils.Expect(t, "2", outputs.NumDocs)
+
+	ts, err := types.ParseRangeFilter("ts>=1577836800")
+	if err != nil {
+		t.Fatal(err)
+	}
+	outputs = engine.Search(types.SearchRequest{Text: "人口", Filters: []types.RangeFilter{between, ts}})
+	utils.Expect(t, "1", outputs.NumDocs)
+	utils.Expect(t, "1", outputs.Docs[0].DocID)
+
+	outputs = engine.Search(types.SearchRequest{
+		QueryText: "中国 OR 有", Filters: []types.RangeFilter{types.GreaterThan("price", 10)}})
+	utils.Expect(t, "2", outputs.NumDocs)
+
+	for _, text := range []string{"price", "price >> 1", "price BETWEEN a AND 1", "> 1"} {
+		_, err := types.ParseRangeFilter(text)
+		utils.Expect(t, "true", errors.Is(err, types.ErrInvalidFilter))
+	}
+	filter, _ := types.ParseRangeFilter("price < 100")
+	utils.Expect(t, "false", filter.Match(100))
+	utils.Expect(t, "true", filter.Match(99.9))
+}
+
+func TestSearchSortBy(t *testing.T) {
+	type article struct {
+		Timestamp int64
+		Author    string
+	}
+
+	var engine Engine
+	engine.Init(types.EngineInitOptions{
+		SegmenterDictionaries: "../test/test_dict.txt",
+		NumShards:             4,
+		IndexerInitOptions: &types.IndexerInitOptions{
+			IndexType: types.LocationsIndex,
+		},
+	})
+	defer engine.Shutdown()
+
+	// 超出float32精度的时间戳
+	timestamps := []int64{1577836800000000001, 1577836800000000003, 1577836800000000002, 1577836800000000004}
+	authors := []string{"b", "a", "b", "a"}
+	for i := range timestamps {
+		engine.IndexDocument(uint64(i+1), types.DocumentIndexData{
+			Content: "中国人口",
+			Fields:  article{timestamps[i], authors[i]},
+		}, false)
+	}
+	engine.FlushIndex()
+
+	docIDs := func(outputs types.SearchResponse) (output string) {
+		for _, doc := range outputs.Docs {
+			output += fmt.Sprintf("%d ", doc.DocID)
+		}
+		return
+	}
+	outputs := engine.Search(types.SearchRequest{Text: "人口", RankOptions: &types.RankOptions{
+		SortBy: []types.SortField{{Field: "Timestamp"}},
+	}})
+	utils.Expect(t, "4 2 3 1 ", docIDs(outputs))
+	utils.Expect(t, "[1577836800000000004]", outputs.Docs[0].SortValues)
+
+	outputs = engine.Search(types.SearchRequest{Text: "人口", RankOptions: &types.RankOptions{
+		SortBy:       []types.SortField{{Field: "Author", Order: types.Ascending}, {Field: "Timestamp"}},
+		OutputOffset: 1,
+		MaxOutputs:   2,
+	}})
+	utils.Expect(t, "2 3 ", docIDs(outputs))
+}
+
+func TestSearchCursor(t *testing.T) {
+	type article struct {
+		Timestamp int64
+	}
+
+	var engine Engine
+	engine.Init(types.EngineInitOptions{
+		SegmenterDictionaries: "../test/test_dict.txt",
+		NumShards:             3,
+		IndexerInitOptions: &types.IndexerInitOptions{
+			IndexType: types.LocationsIndex,
+		},
+	})
+	defer engine.Shutdown()
+
+	// 相同的内容，评分全部相同
+	for docID := uint64(1); docID <= 25; docID++ {
+		engine.IndexDocument(docID, types.DocumentIndexData{
+			Content: "中国人口",
+			Fields:  article{int64(docID % 4)},
+		}, false)
+	}
+	engine.FlushIndex()
+
+	for _, rankOptions := range []types.RankOptions{
+		{MaxOutputs: 10},
+		{MaxOutputs: 7, SortBy: []types.SortField{{Field: "Timestamp"}}},
+		{MaxOutputs: 5, ReverseOrder: true, SortBy: []types.SortField{{Field: "Timestamp", Order: types.Ascending}}},
+	} {
+		options := rankOptions
+		options.MaxOutputs = 0
+		all := engine.Search(types.SearchRequest{Text: "人口", RankOptions: &options})
+
+		var paged []types.ScoredDocument
+		cursor := ""
+		for page := 0; page < 10; page++ {
+			options := rankOptions
+			outputs := engine.Search(types.SearchRequest{Text: "人口", RankOptions: &options, Cursor: cursor})
+			utils.Expect(t, "25", outputs.NumDocs)
+			paged = append(paged, outputs.Docs...)
+			if outputs.Cursor == "" {
+				break
+			}
+			cursor = outputs.Cursor
+		}
+		utils.Expect(t, "25", len(paged))
+		for i := range paged {
+			utils.Expect(t, fmt.Sprint(all.Docs[i].DocID), paged[i].DocID)
+		}
+	}
+
+	_, err := engine.TrySearch(types.SearchRequest{Text: "人口", Cursor: "???"})
+	utils.Expect(t, "true", errors.Is(err, types.ErrInvalidCursor))
+	_, err = engine.TrySearch(types.SearchRequest{Text: "人口", Cursor: types.EncodeCursor(types.ScoredDocument{})[:1]})
+	utils.Expect(t, "true", errors.Is(err, types.ErrInvalidCursor))
+}
+
+func TestSearchPruned(t *testing.T) {
+	var engine Engine
+	engine.Init(types.EngineInitOptions{
+		SegmenterDictionaries: "../test/test_dict.txt",
+		NumShards:             3,
+		IndexerInitOptions: &types.IndexerInitOptions{
+			IndexType: types.LocationsIndex,
+		},
+	})
+	defer engine.Shutdown()
+
+	// 各文档中搜索键的词频和文档长度不同
+	r := rand.New(rand.NewSource(1))
+	words := []string{"中国", "人口", "十三亿", "百度"}
+	for docID := uint64(1); docID <= 300; docID++ {
+		content := ""
+		for i := 0; i < 5+r.Intn(20); i++ {
+			content += words[r.Intn(len(words))]
+		}
+		engine.IndexDocument(docID, types.DocumentIndexData{
+			Content: content + "中国人口",
+			Labels:  []string{fmt.Sprintf("mod:%d", docID%3)},
+		}, false)
+	}
+	engine.FlushIndex()
+
+	for _, rankOptions := range []types.RankOptions{
+		{MaxOutputs: 10},
+		{MaxOutputs: 5, OutputOffset: 20},
+		// 以下不剪枝
+		{MaxOutputs: 10, ReverseOrder: true},
+		{},
+	} {
+		options := rankOptions
+		expected := engine.Search(types.SearchRequest{Text: "中国人口", RankOptions: &options})
+		actual := engine.Search(types.SearchRequest{Text: "中国人口", RankOptions: &options, Prune: true})
+		utils.Expect(t, "300", actual.NumDocs)
+		utils.Expect(t, fmt.Sprint(expected.Docs), actual.Docs)
+		utils.Expect(t, expected.Cursor, actual.Cursor)
+	}
+
+	// 统计分面时不剪枝，分面计数覆盖全部匹配的文档
+	options := types.RankOptions{MaxOutputs: 10}
+	expected := engine.Search(types.SearchRequest{Text: "中国人口", RankOptions: &options})
+	actual := engine.Search(types.SearchRequest{
+		Text: "中国人口", RankOptions: &options, Prune: true, Facets: []string{"mod:"}})
+	utils.Expect(t, "300", actual.NumDocs)
+	utils.Expect(t, fmt.Sprint(expected.Docs), actual.Docs)
+	utils.Expect(t, "map[mod:0:100 mod:1:100 mod:2:100]", actual.Facets)
+
+	// 排序器中没有的文档不计入前topK，剪枝的结果不足一页时不剪枝重新查找
+	top := engine.Search(types.SearchRequest{Text: "中国人口", RankOptions: &types.RankOptions{MaxOutputs: 40}})
+	for _, doc := range top.Docs {
+		for shard := range engine.rankers {
+			engine.rankers[shard].RemoveDoc(doc.DocID)
+		}
+	}
+	expected = engine.Search(types.SearchRequest{Text: "中国人口", RankOptions: &options})
+	actual = engine.Search(types.SearchRequest{Text: "中国人口", RankOptions: &options, Prune: true})
+	utils.Expect(t, "10", len(actual.Docs))
+	utils.Expect(t, fmt.Sprint(expected.Docs), actual.Docs)
+}
+
+func randomShardOutputs(numShards, n int, options types.RankOptions) []types.ScoredDocuments {
+	r := rand.New(rand.NewSource(1))
+	lists := make([]types.ScoredDocuments, numShards)
+	for i := 0; i < n; i++ {
+		shard := r.Intn(numShards)
+		lists[shard] = append(lists[shard], types.ScoredDocument{
+			DocID:  uint64(i + 1),
+			Scores: []float32{float32(r.Intn(n / 10))},
+		})
+	}
+	for _, list := range lists {
+		types.SortScoredDocuments(list, options)
+	}
+	return lists
+}
+
+func TestMergeScoredDocuments(t *testing.T) {
+	for _, options := range []types.RankOptions{{}, {ReverseOrder: true}} {
+		lists := randomShardOutputs(5, 1000, options)
+		var expected types.ScoredDocuments
+		for _, list := range lists {
+			expected = append(expected, list...)
+		}
+		types.SortScoredDocuments(expected, options)
+
+		for _, limit := range []int{0, 1, 10, 999, 1000, 2000} {
+			merged := mergeScoredDocuments(lists, options, limit)
+			end := len(expected)
+			if limit > 0 && limit < end {
+				end = limit
+			}
+			utils.Expect(t, fmt.Sprint(expected[:end]), merged)
+		}
+	}
+	utils.Expect(t, "0", len(mergeScoredDocuments(nil, types.RankOptions{}, 10)))
+	utils.Expect(t, "0", len(mergeScoredDocuments([]types.ScoredDocuments{{}, {}}, types.RankOptions{}, 0)))
+}
+
+// 8个shard各1万个已排序文档取前10个，fullSort时合并后整体排序，即多路归并之前的做法
+func benchmarkMerge(b *testing.B, fullSort bool) {
+	options := types.RankOptions{}
+	lists := randomShardOutputs(8, 80000, options)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if fullSort {
+			var docs types.ScoredDocuments
+			for _, list := range lists {
+				docs = append(docs, list...)
+			}
+			types.SortScoredDocuments(docs, options)
+			_ = docs[:10]
+		} else {
+			mergeScoredDocuments(lists, options, 10)
+		}
+	}
+}
+
+func BenchmarkMergeScoredDocuments(b *testing.B) {
+	benchmarkMerge(b, false)
+}
+
+func BenchmarkSortScoredDocuments(b *testing.B) {
+	benchmarkMerge(b, true)
+}
@@ -0,0 +1,38 @@
+package engine
+
+import (
+	"errors"
+
+	"github.com/pickjunk/wuneng/core"
+)
+
+var (
+	// ErrNotInitialized 引擎尚未初始化
+	ErrNotInitialized = errors.New("wuneng: 必须先初始化引擎")
+
+	// ErrAlreadyInitialized 引擎重复初始化
+	ErrAlreadyInitialized = errors.New("wuneng: 请勿重复初始化引擎")
+
+	// ErrShutdown 引擎已关闭
+	ErrShutdown = errors.New("wuneng: 引擎已关闭")
+
+	// ErrDictionaryNotFound 无法读取分词器词典文件
+	ErrDictionaryNotFound = errors.New("wuneng: 无法读取词典文件")
+
+	// ErrStorage 无法打开或创建持久存储
+	ErrStorage = errors.New("wuneng: 持久存储错误")
+
+	// ErrInvalidSynonym 无法解析的同义词规则
+	ErrInvalidSynonym = errors.New("wuneng: 同义词规则不合法")
+
+	// ErrInvalidDocID 文档编号为0，0只用于强制刷新索引
+	ErrInvalidDocID = errors.New("wuneng: docID不能为0")
+)
+
+// 把索引器和排序器返回的core.ErrNotInitialized转换为引擎的ErrNotInitialized
+func engineError(err error) error {
+	if errors.Is(err, core.ErrNotInitialized) {
+		return ErrNotInitialized
+	}
+	return err
+}
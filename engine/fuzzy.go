@@ -0,0 +1,87 @@
+package engine
+
+import (
+	"strings"
+
+	"github.com/pickjunk/wuneng/types"
+)
+
+// 把查询树中不在NOT子句下的叶子节点扩展为该搜索键与编辑距离之内的搜索键的OR，
+// 扩展出的搜索键的Boost按编辑距离降低。短语节点不扩展
+func (engine *Engine) expandFuzzyQuery(query types.Query, options types.FuzzyOptions) (types.Query, error) {
+	if query.IsLeaf() {
+		prefix := ""
+		if query.Field != "" {
+			prefix = types.FieldKeyword(query.Field, "")
+		}
+		terms, err := engine.fuzzyTerms(prefix, query.Token, options)
+		if err != nil || len(terms) == 0 {
+			return query, err
+		}
+		boost := query.Boost
+		if boost <= 0 {
+			boost = 1
+		}
+		expanded := types.Query{Should: []types.Query{query}}
+		for _, term := range terms {
+			expanded.Should = append(expanded.Should, types.Query{
+				Token: strings.TrimPrefix(term.Text, prefix),
+				Field: query.Field,
+				Boost: boost * options.Boost(term.Distance),
+			})
+		}
+		return expanded, nil
+	}
+	if query.IsPhrase() {
+		return query, nil
+	}
+
+	var err error
+	expanded := query
+	expanded.Must = make([]types.Query, len(query.Must))
+	for i := range query.Must {
+		if expanded.Must[i], err = engine.expandFuzzyQuery(query.Must[i], options); err != nil {
+			return query, err
+		}
+	}
+	expanded.Should = make([]types.Query, len(query.Should))
+	for i := range query.Should {
+		if expanded.Should[i], err = engine.expandFuzzyQuery(query.Should[i], options); err != nil {
+			return query, err
+		}
+	}
+	return expanded, nil
+}
+
+// 汇总各shard中与token相近的搜索键，保留编辑距离小、文档数多的MaxExpansions个
+func (engine *Engine) fuzzyTerms(prefix string, token string, options types.FuzzyOptions) (
+	[]types.FuzzyTerm, error) {
+	edits := options.Edits(token)
+	if edits <= 0 {
+		return nil, nil
+	}
+
+	merged := make(map[string]types.FuzzyTerm)
+	for shard := range engine.indexers {
+		shardTerms, err := engine.indexers[shard].FuzzyTerms(
+			prefix, token, edits, options.PrefixLength, options.MaxExpansions)
+		if err != nil {
+			return nil, engineError(err)
+		}
+		for _, term := range shardTerms {
+			if previous, found := merged[term.Text]; found {
+				term.Frequency += previous.Frequency
+			}
+			merged[term.Text] = term
+		}
+	}
+	terms := make([]types.FuzzyTerm, 0, len(merged))
+	for _, term := range merged {
+		terms = append(terms, term)
+	}
+	types.SortFuzzyTerms(terms)
+	if len(terms) > options.MaxExpansions {
+		terms = terms[:options.MaxExpansions]
+	}
+	return terms, nil
+}
@@ -0,0 +1,185 @@
+package engine
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pickjunk/wuneng/types"
+	"github.com/pickjunk/wuneng/utils"
+)
+
+// 关键词在原文中的一次出现
+type highlightSpan struct {
+	token      int
+	start, end int
+}
+
+// 原文中的一个片段，score越大越好
+type highlightFragment struct {
+	start, end int
+	score      int
+}
+
+// Highlight 用搜索结果中关键词的位置从原文text中截取高亮片段
+//
+// tokens为SearchResponse.Tokens，doc.TokenLocations、doc.TokenEnds和doc.TokenSnippetLocations须是对text分词得到的，
+// 因此仅对LocationsIndex有效。第一个片段围绕紧邻距离最小的一组关键词（TokenSnippetLocations），
+// 其余片段依次选取包含不同关键词最多的位置，片段之间不重叠、不跨越换行。没有关键词出现时返回nil
+func Highlight(text string, tokens []string, doc types.ScoredDocument, options types.HighlightOptions) []string {
+	options.Init()
+	spans := highlightSpans(text, tokens, doc.TokenLocations, doc.TokenEnds)
+	if len(spans) == 0 {
+		return nil
+	}
+
+	var fragments []highlightFragment
+	overlaps := func(f highlightFragment) bool {
+		for _, chosen := range fragments {
+			if f.start < chosen.end && chosen.start < f.end {
+				return true
+			}
+		}
+		return false
+	}
+
+	// 紧邻距离最小的一组关键词
+	start, end := -1, -1
+	for _, span := range spans {
+		if span.token >= len(doc.TokenSnippetLocations) || doc.TokenSnippetLocations[span.token] != span.start {
+			continue
+		}
+		if start == -1 || span.start < start {
+			start = span.start
+		}
+		if span.end > end {
+			end = span.end
+		}
+	}
+	if start != -1 {
+		fragments = append(fragments, highlightWindow(text, start, end, options.FragmentSize, spans))
+	}
+
+	// 以每次出现为中心的候选片段
+	var candidates []highlightFragment
+	for _, span := range spans {
+		candidates = append(candidates, highlightWindow(text, span.start, span.end, options.FragmentSize, spans))
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].score > candidates[j].score })
+	for _, candidate := range candidates {
+		if len(fragments) >= options.NumFragments {
+			break
+		}
+		if !overlaps(candidate) {
+			fragments = append(fragments, candidate)
+		}
+	}
+	if len(fragments) > options.NumFragments {
+		fragments = fragments[:options.NumFragments]
+	}
+
+	highlights := make([]string, len(fragments))
+	for i, fragment := range fragments {
+		highlights[i] = renderHighlight(text, fragment, spans, options)
+	}
+	return highlights
+}
+
+// 收集关键词的全部出现，按起始位置排序，超出原文或不在UTF-8字符边界上的位置被忽略
+// ends与locations一一对应，为空时结束位置为起始位置加上关键词的长度
+func highlightSpans(text string, tokens []string, locations [][]int, ends [][]int) (spans []highlightSpan) {
+	for i, tokenLocations := range locations {
+		if i >= len(tokens) {
+			break
+		}
+		for j, location := range tokenLocations {
+			end := location + len(tokens[i])
+			if i < len(ends) && j < len(ends[i]) {
+				end = ends[i][j]
+			}
+			if location < 0 || end <= location || end > len(text) ||
+				!utf8.RuneStart(text[location]) || (end < len(text) && !utf8.RuneStart(text[end])) {
+				continue
+			}
+			spans = append(spans, highlightSpan{i, location, end})
+		}
+	}
+	sort.Slice(spans, func(i, j int) bool {
+		if spans[i].start != spans[j].start {
+			return spans[i].start < spans[j].start
+		}
+		return spans[i].end > spans[j].end
+	})
+	return
+}
+
+// 围绕[start, end)选取不超过size字节的片段，片段不跨越换行，首尾都在UTF-8字符边界上
+func highlightWindow(text string, start int, end int, size int, spans []highlightSpan) highlightFragment {
+	lineStart := strings.LastIndexByte(text[:start], '\n') + 1
+	lineEnd := len(text)
+	if i := strings.IndexByte(text[start:], '\n'); i >= 0 {
+		lineEnd = start + i
+	}
+	if end > lineEnd {
+		end = lineEnd
+	}
+
+	// 两侧均匀扩展，一侧到达行首或行尾时余量留给另一侧
+	if end-start < size {
+		pad := size - (end - start)
+		left := start - pad/2
+		right := end + pad - pad/2
+		if left < lineStart {
+			right += lineStart - left
+			left = lineStart
+		}
+		if right > lineEnd {
+			left -= right - lineEnd
+			right = lineEnd
+		}
+		start, end = utils.MaxInt(left, lineStart), right
+	} else {
+		end = start + size
+	}
+
+	// 起点后移、终点前移到字符边界，保证不超过size
+	for start < end && !utf8.RuneStart(text[start]) {
+		start++
+	}
+	for end < len(text) && end > start && !utf8.RuneStart(text[end]) {
+		end--
+	}
+
+	// 包含的不同关键词数优先，其次为出现次数
+	fragment := highlightFragment{start: start, end: end}
+	seen := make(map[int]bool)
+	for _, span := range spans {
+		if span.start >= start && span.end <= end {
+			if !seen[span.token] {
+				seen[span.token] = true
+				fragment.score += len(spans)
+			}
+			fragment.score++
+		}
+	}
+	return fragment
+}
+
+// 在片段中的关键词两侧加上标签，重叠的出现合并为一处
+func renderHighlight(text string, fragment highlightFragment, spans []highlightSpan, options types.HighlightOptions) string {
+	var builder strings.Builder
+	position := fragment.start
+	for _, span := range spans {
+		start, end := utils.MaxInt(span.start, position), utils.MinInt(span.end, fragment.end)
+		if start >= end {
+			continue
+		}
+		builder.WriteString(text[position:start])
+		builder.WriteString(options.PreTag)
+		builder.WriteString(text[start:end])
+		builder.WriteString(options.PostTag)
+		position = end
+	}
+	builder.WriteString(text[position:fragment.end])
+	return builder.String()
+}
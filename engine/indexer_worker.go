@@ -1,6 +1,7 @@
 package engine
 
 import (
+	"context"
 	"sync/atomic"
 
 	"github.com/pickjunk/wuneng/types"
@@ -9,13 +10,19 @@ import (
 type indexerAddDocumentRequest struct {
 	document    *types.DocumentIndex
 	forceUpdate bool
+	ack         *writeAck
 }
 
 type indexerLookupRequest struct {
+	ctx                 context.Context
 	countDocsOnly       bool
 	tokens              []string
 	labels              []string
+	query               *types.Query
 	docIDs              map[uint64]bool
+	filters             []types.RangeFilter
+	facets              []string
+	topK                int
 	options             types.RankOptions
 	rankerReturnChannel chan rankerReturnRequest
 	orderless           bool
@@ -24,6 +31,7 @@ type indexerLookupRequest struct {
 type indexerRemoveDocRequest struct {
 	docID       uint64
 	forceUpdate bool
+	ack         *writeAck
 }
 
 func (engine *Engine) indexerAddDocumentWorker(shard int) {
@@ -32,7 +40,7 @@ func (engine *Engine) indexerAddDocumentWorker(shard int) {
 		case <-engine.shutdownChannel:
 			return
 		case request := <-engine.indexerAddDocChannels[shard]:
-			engine.indexers[shard].AddDocumentToCache(request.document, request.forceUpdate)
+			err := engine.indexers[shard].AddDocumentToCache(request.document, request.forceUpdate)
 			if request.document != nil {
 				atomic.AddUint64(&engine.numTokenIndexAdded,
 					uint64(len(request.document.Keywords)))
@@ -41,6 +49,7 @@ func (engine *Engine) indexerAddDocumentWorker(shard int) {
 			if request.forceUpdate {
 				atomic.AddUint64(&engine.numDocumentsForceUpdated, 1)
 			}
+			request.ack.finish(engineError(err))
 		}
 	}
 }
@@ -51,13 +60,14 @@ func (engine *Engine) indexerRemoveDocWorker(shard int) {
 		case <-engine.shutdownChannel:
 			return
 		case request := <-engine.indexerRemoveDocChannels[shard]:
-			engine.indexers[shard].RemoveDocumentToCache(request.docID, request.forceUpdate)
+			_, err := engine.indexers[shard].RemoveDocumentToCache(request.docID, request.forceUpdate)
 			if request.docID != 0 {
 				atomic.AddUint64(&engine.numDocumentsRemoved, 1)
 			}
 			if request.forceUpdate {
 				atomic.AddUint64(&engine.numDocumentsForceUpdated, 1)
 			}
+			request.ack.finish(engineError(err))
 		}
 	}
 }
@@ -68,45 +78,88 @@ func (engine *Engine) indexerLookupWorker(shard int) {
 		case <-engine.shutdownChannel:
 			return
 		case request := <-engine.indexerLookupChannels[shard]:
+			if request.ctx.Err() != nil {
+				// 请求已被放弃
+				request.rankerReturnChannel <- rankerReturnRequest{timeout: true}
+				continue
+			}
+
+			// 统计标签时需要具体的文档
+			countDocsOnly := request.countDocsOnly && len(request.facets) == 0
 			var docs []types.IndexedDocument
 			var numDocs int
-			if request.docIDs == nil {
-				docs, numDocs = engine.indexers[shard].Lookup(request.tokens, request.labels, nil, request.countDocsOnly)
+			var err error
+			options := types.LookupOptions{
+				DocIDs:        request.docIDs,
+				Filters:       request.filters,
+				TopK:          request.topK,
+				CountDocsOnly: countDocsOnly,
+				Ranked:        engine.rankers[shard].HasDoc,
+			}
+			if request.query != nil {
+				docs, numDocs, err = engine.indexers[shard].LookupQueryContext(request.ctx, request.query, options)
 			} else {
-				docs, numDocs = engine.indexers[shard].Lookup(request.tokens, request.labels, request.docIDs, request.countDocsOnly)
+				docs, numDocs, err = engine.indexers[shard].LookupContext(
+					request.ctx, request.tokens, request.labels, options)
+			}
+			if err != nil && request.ctx.Err() == nil {
+				request.rankerReturnChannel <- rankerReturnRequest{err: err}
+				continue
+			}
+			timeout := err != nil
+
+			// 不经过排序器时numDocs为索引器找到的文档数，标签也在这些文档上统计，
+			// 否则由排序器在计入numDocs的文档上统计
+			ranked := !request.countDocsOnly && len(docs) > 0 && !request.orderless && !timeout
+			var facets map[string]int
+			if len(request.facets) > 0 && !ranked {
+				if facets, err = engine.indexers[shard].CountFacets(docs, request.facets); err != nil {
+					request.rankerReturnChannel <- rankerReturnRequest{err: err}
+					continue
+				}
 			}
 
 			if request.countDocsOnly {
-				request.rankerReturnChannel <- rankerReturnRequest{numDocs: numDocs}
+				request.rankerReturnChannel <- rankerReturnRequest{
+					numDocs: numDocs, facets: facets, timeout: timeout}
 				continue
 			}
 
 			if len(docs) == 0 {
-				request.rankerReturnChannel <- rankerReturnRequest{}
+				request.rankerReturnChannel <- rankerReturnRequest{facets: facets, timeout: timeout}
 				continue
 			}
 
-			if request.orderless {
+			if request.orderless || timeout {
 				var outputDocs []types.ScoredDocument
 				for _, d := range docs {
 					outputDocs = append(outputDocs, types.ScoredDocument{
 						DocID:                 d.DocID,
 						TokenSnippetLocations: d.TokenSnippetLocations,
-						TokenLocations:        d.TokenLocations})
+						TokenLocations:        d.TokenLocations,
+						TokenEnds:             d.TokenEnds})
 				}
 				request.rankerReturnChannel <- rankerReturnRequest{
 					docs:    outputDocs,
 					numDocs: len(outputDocs),
+					facets:  facets,
+					timeout: timeout,
 				}
 				continue
 			}
 
 			rankerRequest := rankerRankRequest{
+				ctx:                 request.ctx,
 				countDocsOnly:       request.countDocsOnly,
 				docs:                docs,
 				options:             request.options,
+				facets:              request.facets,
 				rankerReturnChannel: request.rankerReturnChannel,
 			}
+			if request.topK > 0 {
+				// 剪枝后docs不包含全部匹配文档
+				rankerRequest.numDocs = numDocs
+			}
 			engine.rankerRankChannels[shard] <- rankerRequest
 		}
 	}
@@ -0,0 +1,56 @@
+package engine
+
+import (
+	"container/heap"
+
+	"github.com/pickjunk/wuneng/types"
+)
+
+// 多路归并各shard已排好序的输出，limit > 0时只取前limit个文档
+func mergeScoredDocuments(lists []types.ScoredDocuments, options types.RankOptions, limit int) types.ScoredDocuments {
+	h := mergeHeap{options: options}
+	total := 0
+	for _, list := range lists {
+		if len(list) > 0 {
+			h.lists = append(h.lists, list)
+			total += len(list)
+		}
+	}
+	if limit <= 0 || limit > total {
+		limit = total
+	}
+	if len(h.lists) == 1 {
+		return h.lists[0][:limit]
+	}
+
+	heap.Init(&h)
+	output := make(types.ScoredDocuments, 0, limit)
+	for len(output) < limit {
+		output = append(output, h.lists[0][0])
+		h.lists[0] = h.lists[0][1:]
+		if len(h.lists[0]) == 0 {
+			heap.Pop(&h)
+		} else {
+			heap.Fix(&h, 0)
+		}
+	}
+	return output
+}
+
+// 以各列表的首个文档排序的堆
+type mergeHeap struct {
+	lists   []types.ScoredDocuments
+	options types.RankOptions
+}
+
+func (h *mergeHeap) Len() int      { return len(h.lists) }
+func (h *mergeHeap) Swap(i, j int) { h.lists[i], h.lists[j] = h.lists[j], h.lists[i] }
+func (h *mergeHeap) Less(i, j int) bool {
+	return types.CompareScoredDocuments(h.lists[i][0], h.lists[j][0], h.options) < 0
+}
+func (h *mergeHeap) Push(x interface{}) { h.lists = append(h.lists, x.(types.ScoredDocuments)) }
+func (h *mergeHeap) Pop() interface{} {
+	list := h.lists[len(h.lists)-1]
+	h.lists = h.lists[:len(h.lists)-1]
+	return list
+}
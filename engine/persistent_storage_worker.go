@@ -0,0 +1,103 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"sync/atomic"
+
+	"github.com/pickjunk/wuneng/storage"
+	"github.com/pickjunk/wuneng/types"
+)
+
+type persistentStorageRequest struct {
+	docID  uint64
+	data   types.DocumentIndexData
+	remove bool
+	ack    *writeAck
+}
+
+// 一次事务最多写入的请求数
+const persistentStorageBatchSize = 256
+
+func (engine *Engine) persistentStorageWorker(shard int) {
+	for {
+		select {
+		case <-engine.shutdownChannel:
+			return
+		case request := <-engine.persistentStorageChannels[shard]:
+			// 连同通道中已有的请求在一个事务中写入，避免每个文档同步一次磁盘
+			requests := []persistentStorageRequest{request}
+		collect:
+			for len(requests) < persistentStorageBatchSize {
+				select {
+				case request := <-engine.persistentStorageChannels[shard]:
+					requests = append(requests, request)
+				default:
+					break collect
+				}
+			}
+			engine.persistRequests(shard, requests)
+		}
+	}
+}
+
+func (engine *Engine) persistRequests(shard int, requests []persistentStorageRequest) {
+	mutations := make([]storage.Mutation, 0, len(requests))
+	batched := make([]persistentStorageRequest, 0, len(requests))
+	for _, request := range requests {
+		mutation := storage.Mutation{Key: encodeStorageKey(request.docID), Delete: request.remove}
+		if !request.remove {
+			var buf bytes.Buffer
+			if err := gob.NewEncoder(&buf).Encode(request.data); err != nil {
+				// 通常是 Fields 的具体类型没有用 gob.Register 注册
+				log.Error().Err(err).Uint64("docID", request.docID).Msg("文档序列化失败")
+				atomic.AddUint64(&engine.numDocumentsStored, 1)
+				request.ack.finish(err)
+				continue
+			}
+			mutation.Value = buf.Bytes()
+		}
+		mutations = append(mutations, mutation)
+		batched = append(batched, request)
+	}
+
+	if len(mutations) == 0 {
+		return
+	}
+	err := engine.dbs[shard].Batch(mutations)
+	if err != nil {
+		log.Error().Err(err).Int("shard", shard).Int("documents", len(batched)).Msg("持久存储写入文档失败")
+	}
+	for _, request := range batched {
+		atomic.AddUint64(&engine.numDocumentsStored, 1)
+		request.ack.finish(err)
+	}
+}
+
+// 从持久存储中恢复一个裂分的全部文档，完成后向 done 发送信号
+func (engine *Engine) persistentStorageInitWorker(shard int, done chan<- bool) {
+	err := engine.dbs[shard].ForEach(func(k, v []byte) error {
+		docID, _ := binary.Uvarint(k)
+		var data types.DocumentIndexData
+		if err := gob.NewDecoder(bytes.NewReader(v)).Decode(&data); err != nil {
+			log.Error().Err(err).Uint64("docID", docID).Msg("文档反序列化失败")
+			return nil
+		}
+		// 文件中的文档存储已保存了这些文档，只有内存中的需要重新写入
+		if engine.initOptions.DocumentStoreFolder == "" {
+			engine.storeDocument(docID, data, false, nil)
+		}
+		engine.internalIndexDocument(docID, data, false, nil)
+		return nil
+	})
+	if err != nil {
+		log.Error().Err(err).Int("shard", shard).Msg("从持久存储恢复索引失败")
+	}
+	done <- true
+}
+
+func encodeStorageKey(docID uint64) []byte {
+	key := make([]byte, binary.MaxVarintLen64)
+	return key[:binary.PutUvarint(key, docID)]
+}
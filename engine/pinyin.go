@@ -0,0 +1,174 @@
+package engine
+
+import (
+	"bufio"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pickjunk/wuneng/types"
+	"github.com/pickjunk/wuneng/utils"
+)
+
+// 汉字到拼音的转换表
+type pinyinDictionary struct {
+	// 单字的拼音
+	chars map[rune]string
+
+	// 词的逐字拼音，优先于单字，longestWord为其中最长的词的字数
+	words       map[string][]string
+	longestWord int
+}
+
+// 载入拼音词典，格式见EngineInitOptions.PinyinDictionary
+func loadPinyinDictionary(file string) (*pinyinDictionary, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	dictionary := &pinyinDictionary{
+		chars: make(map[rune]string),
+		words: make(map[string][]string),
+	}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		text, readings := fields[0], fields[1:]
+		switch length := utf8.RuneCountInString(text); {
+		case length == 1:
+			r, _ := utf8.DecodeRuneInString(text)
+			if _, found := dictionary.chars[r]; !found {
+				dictionary.chars[r] = readings[0]
+			}
+		case length == len(readings):
+			dictionary.words[text] = readings
+			if length > dictionary.longestWord {
+				dictionary.longestWord = length
+			}
+		}
+	}
+	return dictionary, scanner.Err()
+}
+
+// 返回含汉字的关键词的全拼和首字母，其中的字母和数字原样保留（字母转为小写），
+// 不含汉字、含有其他字符或有词典中没有的汉字时返回false
+func (dictionary *pinyinDictionary) convert(token string) (full string, initials string, ok bool) {
+	runes := []rune(token)
+	var fullBuilder, initialsBuilder strings.Builder
+	hasHan := false
+	for i := 0; i < len(runes); {
+		// 词优先，从最长的开始匹配
+		matched := false
+		for length := utils.MinInt(dictionary.longestWord, len(runes)-i); length > 1; length-- {
+			if readings, found := dictionary.words[string(runes[i:i+length])]; found {
+				for _, reading := range readings {
+					fullBuilder.WriteString(reading)
+					initialsBuilder.WriteString(reading[:1])
+				}
+				i += length
+				hasHan, matched = true, true
+				break
+			}
+		}
+		if matched {
+			continue
+		}
+
+		r := runes[i]
+		i++
+		if reading, found := dictionary.chars[r]; found {
+			fullBuilder.WriteString(reading)
+			initialsBuilder.WriteString(reading[:1])
+			hasHan = true
+			continue
+		}
+		if r < utf8.RuneSelf && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			r = unicode.ToLower(r)
+			fullBuilder.WriteRune(r)
+			initialsBuilder.WriteRune(r)
+			continue
+		}
+		return "", "", false
+	}
+	if !hasHan {
+		return "", "", false
+	}
+	return fullBuilder.String(), initialsBuilder.String(), true
+}
+
+// 为tokensMap中含汉字的关键词加入拼音搜索键，位置与汉字关键词相同，单个字母的首字母不加入
+// 限定字段的关键词（textFields中字段的FieldKeyword）转换为限定同一字段的拼音搜索键
+func (dictionary *pinyinDictionary) addPinyinTokens(tokensMap map[string][]tokenSpan, textFields map[string]string) {
+	additions := make(map[string][]tokenSpan)
+	for token, spans := range tokensMap {
+		prefix, text := "", token
+		if field, fieldText, ok := types.SplitFieldKeyword(token); ok {
+			if _, found := textFields[field]; found {
+				prefix, text = types.FieldKeyword(field, ""), fieldText
+			}
+		}
+		full, initials, ok := dictionary.convert(text)
+		if !ok {
+			continue
+		}
+		additions[prefix+types.PinyinKeyword(full)] = append(additions[prefix+types.PinyinKeyword(full)], spans...)
+		if len(initials) > 1 && initials != full {
+			additions[prefix+types.PinyinKeyword(initials)] = append(
+				additions[prefix+types.PinyinKeyword(initials)], spans...)
+		}
+	}
+
+	// 多个关键词可能有相同的拼音，合并后位置须保持升序
+	for keyword, spans := range additions {
+		spans = append(tokensMap[keyword], spans...)
+		sort.Slice(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+		tokensMap[keyword] = spans
+	}
+}
+
+// 搜索时的拼音关键词：只含字母（不区分大小写），返回转为小写的拼音
+func pinyinQueryToken(token string) (string, bool) {
+	if token == "" {
+		return "", false
+	}
+	for i := 0; i < len(token); i++ {
+		if c := token[i]; !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z') {
+			return "", false
+		}
+	}
+	return strings.ToLower(token), true
+}
+
+// 把查询树中不在NOT子句下的拼音叶子节点扩展为该关键词与拼音搜索键的OR，短语节点不扩展
+func expandPinyinQuery(query types.Query) types.Query {
+	if query.IsLeaf() {
+		pinyin, ok := pinyinQueryToken(query.Token)
+		if !ok {
+			return query
+		}
+		pinyinQuery := query
+		pinyinQuery.Token = types.PinyinKeyword(pinyin)
+		return types.Query{Should: []types.Query{query, pinyinQuery}}
+	}
+	if query.IsPhrase() {
+		return query
+	}
+
+	expanded := query
+	expanded.Must = make([]types.Query, len(query.Must))
+	for i := range query.Must {
+		expanded.Must[i] = expandPinyinQuery(query.Must[i])
+	}
+	expanded.Should = make([]types.Query, len(query.Should))
+	for i := range query.Should {
+		expanded.Should[i] = expandPinyinQuery(query.Should[i])
+	}
+	return expanded
+}
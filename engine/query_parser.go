@@ -0,0 +1,272 @@
+package engine
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/pickjunk/wuneng/types"
+	"github.com/pickjunk/wuneng/utils"
+)
+
+// 布尔查询语句的解析器，语法（优先级 NOT > AND > OR）：
+//
+//	or    := and ("OR" and)*
+//	and   := unary ("AND"? unary)*
+//	unary := "NOT" unary | "(" or ")" | 字段? 短语 | 字段? 词
+//	短语  := '"' 词* '"' (("~" 间隔 | "@" 窗口) "t"?)?
+//	字段  := 字段名 ":"
+//
+// 短语的间隔和窗口默认以字节为单位，后缀"t"表示以关键词个数为单位，如 "北京 大学"~1t
+// 字段把词或短语限定在该具名文本字段中，如 title:手机，只有BM25FParameters.Fields中声明的字段名生效，
+// 其他"前缀:值"形式的词（如标签 category:phone）原样作为词
+//
+// 解析是宽松的：缺失的右括号和引号自动补齐，多余的右括号和悬空的运算符被忽略
+type queryParser struct {
+	lexemes []queryLexeme
+	pos     int
+
+	// 对词分词，返回的关键词之间为AND关系
+	segment func(string) []string
+
+	// 可以限定的字段名
+	fields map[string]types.FieldParameters
+}
+
+type queryLexeme struct {
+	text string
+
+	// 是否为引号中的短语，以及短语的位置约束
+	phrase   bool
+	slop     int
+	window   int
+	inTokens bool
+
+	// 短语限定的字段，词的字段在分词前拆出
+	field string
+}
+
+func parseQuery(text string, segment func(string) []string, fields map[string]types.FieldParameters) *types.Query {
+	parser := queryParser{segment: segment, fields: fields}
+	parser.lexemes = parser.lex(text)
+
+	query := &types.Query{}
+	for parser.pos < len(parser.lexemes) {
+		if q := parser.parseOr(); q != nil {
+			query.Must = append(query.Must, *q)
+		}
+		// 跳过多余的右括号
+		if parser.peek() == ")" {
+			parser.pos++
+		}
+	}
+	if len(query.Must) == 1 {
+		return &query.Must[0]
+	}
+	return query
+}
+
+// 按空白、括号和引号切分查询语句，全角括号和引号视同半角
+func (parser *queryParser) lex(text string) (lexemes []queryLexeme) {
+	runes := []rune(text)
+	var current []rune
+	flush := func() {
+		if len(current) > 0 {
+			lexemes = append(lexemes, queryLexeme{text: string(current)})
+			current = current[:0]
+		}
+	}
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case r == '(' || r == '（':
+			flush()
+			lexemes = append(lexemes, queryLexeme{text: "("})
+		case r == ')' || r == '）':
+			flush()
+			lexemes = append(lexemes, queryLexeme{text: ")"})
+		case r == '"' || r == '“' || r == '”':
+			// 紧接在"字段名:"之后的短语
+			field := ""
+			if n := len(current); n > 1 && current[n-1] == ':' && parser.isField(string(current[:n-1])) {
+				field = string(current[:n-1])
+				current = current[:0]
+			}
+			flush()
+			j := i + 1
+			for j < len(runes) && runes[j] != '"' && runes[j] != '“' && runes[j] != '”' {
+				j++
+			}
+			lexeme := queryLexeme{text: string(runes[i+1 : utils.MinInt(j, len(runes))]), phrase: true, field: field}
+			i = j
+
+			// 短语后紧跟的位置约束
+			if i+1 < len(runes) && (runes[i+1] == '~' || runes[i+1] == '@') {
+				k := i + 2
+				for k < len(runes) && unicode.IsDigit(runes[k]) {
+					k++
+				}
+				if n, err := strconv.Atoi(string(runes[i+2 : k])); err == nil {
+					if runes[i+1] == '~' {
+						lexeme.slop = n
+					} else {
+						lexeme.window = n
+					}
+					if k < len(runes) && runes[k] == 't' {
+						lexeme.inTokens = true
+						k++
+					}
+					i = k - 1
+				}
+			}
+			lexemes = append(lexemes, lexeme)
+		case unicode.IsSpace(r):
+			flush()
+		default:
+			current = append(current, r)
+		}
+	}
+	flush()
+	return
+}
+
+func (parser *queryParser) peek() string {
+	if parser.pos < len(parser.lexemes) && !parser.lexemes[parser.pos].phrase {
+		return parser.lexemes[parser.pos].text
+	}
+	return ""
+}
+
+func (parser *queryParser) done() bool {
+	return parser.pos >= len(parser.lexemes)
+}
+
+func (parser *queryParser) parseOr() *types.Query {
+	var clauses []types.Query
+	for {
+		if q := parser.parseAnd(); q != nil {
+			clauses = append(clauses, *q)
+		}
+		if parser.peek() != "OR" {
+			break
+		}
+		parser.pos++
+	}
+
+	switch len(clauses) {
+	case 0:
+		return nil
+	case 1:
+		return &clauses[0]
+	}
+	return &types.Query{Should: clauses}
+}
+
+func (parser *queryParser) parseAnd() *types.Query {
+	query := &types.Query{}
+	for !parser.done() {
+		lexeme := parser.peek()
+		if lexeme == ")" || lexeme == "OR" {
+			break
+		}
+		if lexeme == "AND" {
+			parser.pos++
+			continue
+		}
+		q, negated := parser.parseUnary()
+		if q == nil {
+			continue
+		}
+		if negated {
+			query.MustNot = append(query.MustNot, *q)
+		} else {
+			query.Must = append(query.Must, *q)
+		}
+	}
+
+	if len(query.Must) == 0 && len(query.MustNot) == 0 {
+		return nil
+	}
+	if len(query.Must) == 1 && len(query.MustNot) == 0 {
+		return &query.Must[0]
+	}
+	return query
+}
+
+// 第二个返回值表示该子句是否被NOT否定
+func (parser *queryParser) parseUnary() (*types.Query, bool) {
+	if parser.done() {
+		return nil, false
+	}
+	lexeme := parser.lexemes[parser.pos]
+	parser.pos++
+
+	if lexeme.phrase {
+		return parser.parsePhrase(lexeme), false
+	}
+
+	switch lexeme.text {
+	case "NOT":
+		// 后面没有可否定的子句时是悬空的运算符，不把运算符或右括号当作词
+		if next := parser.peek(); parser.done() || next == ")" || next == "OR" || next == "AND" {
+			return nil, false
+		}
+		q, negated := parser.parseUnary()
+		return q, !negated
+	case "(":
+		q := parser.parseOr()
+		if parser.peek() == ")" {
+			parser.pos++
+		}
+		return q, false
+	}
+
+	field, term := parser.splitField(lexeme.text)
+	var leaves []types.Query
+	for _, token := range parser.segment(term) {
+		if strings.TrimSpace(token) != "" {
+			leaves = append(leaves, types.Query{Token: token, Field: field})
+		}
+	}
+	switch len(leaves) {
+	case 0:
+		return nil, false
+	case 1:
+		return &leaves[0], false
+	}
+	return &types.Query{Must: leaves}, false
+}
+
+func (parser *queryParser) parsePhrase(lexeme queryLexeme) *types.Query {
+	var tokens []string
+	for _, word := range strings.Fields(lexeme.text) {
+		for _, token := range parser.segment(word) {
+			if strings.TrimSpace(token) != "" {
+				tokens = append(tokens, token)
+			}
+		}
+	}
+
+	switch len(tokens) {
+	case 0:
+		return nil
+	case 1:
+		return &types.Query{Token: tokens[0], Field: lexeme.field}
+	}
+	return &types.Query{Phrase: tokens, Slop: lexeme.slop, Window: lexeme.window, InTokens: lexeme.inTokens,
+		Field: lexeme.field}
+}
+
+// 拆出"字段名:词"中的字段名，不是这种形式或字段未声明时字段名为空
+func (parser *queryParser) splitField(text string) (field string, term string) {
+	i := strings.IndexByte(text, ':')
+	if i <= 0 || i == len(text)-1 || !parser.isField(text[:i]) {
+		return "", text
+	}
+	return text[:i], text[i+1:]
+}
+
+func (parser *queryParser) isField(name string) bool {
+	_, found := parser.fields[name]
+	return found
+}
@@ -1,28 +1,49 @@
 package engine
 
 import (
+	"context"
+
 	"github.com/pickjunk/wuneng/types"
 )
 
 type rankerAddDocRequest struct {
 	docID  uint64
 	fields interface{}
+	ack    *writeAck
 }
 
 type rankerRankRequest struct {
+	ctx                 context.Context
 	docs                []types.IndexedDocument
 	options             types.RankOptions
+	facets              []string
 	rankerReturnChannel chan rankerReturnRequest
 	countDocsOnly       bool
+
+	// 大于0时为索引器统计的匹配文档数
+	numDocs int
 }
 
 type rankerReturnRequest struct {
 	docs    types.ScoredDocuments
 	numDocs int
+
+	// 本shard的标签统计
+	facets map[string]int
+
+	// docs是否已按排序选项排好序
+	sorted bool
+
+	// 是否因ctx取消而只返回了部分结果
+	timeout bool
+
+	// ctx取消以外的错误，如索引器或排序器未初始化
+	err error
 }
 
 type rankerRemoveDocRequest struct {
 	docID uint64
+	ack   *writeAck
 }
 
 func (engine *Engine) rankerAddDocWorker(shard int) {
@@ -31,7 +52,8 @@ func (engine *Engine) rankerAddDocWorker(shard int) {
 		case <-engine.shutdownChannel:
 			return
 		case request := <-engine.rankerAddDocChannels[shard]:
-			engine.rankers[shard].AddDoc(request.docID, request.fields)
+			err := engine.rankers[shard].AddDoc(request.docID, request.fields)
+			request.ack.finish(engineError(err))
 		}
 	}
 }
@@ -46,8 +68,34 @@ func (engine *Engine) rankerRankWorker(shard int) {
 				request.options.MaxOutputs += request.options.OutputOffset
 			}
 			request.options.OutputOffset = 0
-			outputDocs, numDocs := engine.rankers[shard].Rank(request.docs, request.options, request.countDocsOnly)
-			request.rankerReturnChannel <- rankerReturnRequest{docs: outputDocs, numDocs: numDocs}
+
+			// 标签只统计排序器计入numDocs的文档，与numDocs一致
+			var counted []types.IndexedDocument
+			var collect func(doc types.IndexedDocument)
+			if len(request.facets) > 0 {
+				collect = func(doc types.IndexedDocument) {
+					counted = append(counted, doc)
+				}
+			}
+			outputDocs, numDocs, err := engine.rankers[shard].RankContextFunc(
+				request.ctx, request.docs, request.options, request.countDocsOnly, collect)
+			if err != nil && request.ctx.Err() == nil {
+				request.rankerReturnChannel <- rankerReturnRequest{err: err}
+				continue
+			}
+			var facets map[string]int
+			if len(request.facets) > 0 {
+				var facetErr error
+				if facets, facetErr = engine.indexers[shard].CountFacets(counted, request.facets); facetErr != nil {
+					request.rankerReturnChannel <- rankerReturnRequest{err: facetErr}
+					continue
+				}
+			}
+			if request.numDocs > 0 {
+				numDocs = request.numDocs
+			}
+			request.rankerReturnChannel <- rankerReturnRequest{
+				docs: outputDocs, numDocs: numDocs, facets: facets, sorted: true, timeout: err != nil}
 		}
 	}
 }
@@ -58,7 +106,8 @@ func (engine *Engine) rankerRemoveDocWorker(shard int) {
 		case <-engine.shutdownChannel:
 			return
 		case request := <-engine.rankerRemoveDocChannels[shard]:
-			engine.rankers[shard].RemoveDoc(request.docID)
+			err := engine.rankers[shard].RemoveDoc(request.docID)
+			request.ack.finish(engineError(err))
 		}
 	}
 }
@@ -1,14 +1,23 @@
 package engine
 
 import (
+	"sort"
+
 	"github.com/pickjunk/wuneng/types"
+	"github.com/pickjunk/wuneng/utils"
 )
 
+// 关键词在文档中的一次出现，[start, end)为原文中的字节位置
+type tokenSpan struct {
+	start, end int
+}
+
 type segmenterRequest struct {
 	docID       uint64
 	hash        uint32
 	data        types.DocumentIndexData
 	forceUpdate bool
+	ack         *writeAck
 }
 
 func (engine *Engine) segmenterWorker() {
@@ -27,47 +36,63 @@ func (engine *Engine) segmenterWorker() {
 			}
 
 			shard := engine.getShard(request.hash)
-			tokensMap := make(map[string][]int)
+			tokensMap := make(map[string][]tokenSpan)
 			numTokens := 0
 			if !engine.initOptions.NotUsingSegmenter && request.data.Content != "" {
 				// 当文档正文不为空时，优先从内容分词中得到关键词
-				segments := engine.segmenter.FullSegment([]byte(request.data.Content))
-				for _, segment := range segments {
-					token := segment.Token().Text()
-					tokensMap[token] = append(tokensMap[token], segment.Start())
+				tokens := engine.initOptions.IndexAnalyzer.Analyze(request.data.Content)
+				for _, token := range tokens {
+					tokensMap[token.Text] = append(tokensMap[token.Text], tokenSpan{token.Start, token.End})
 				}
-				numTokens = len(segments)
+				numTokens = len(tokens)
 			} else {
 				// 否则载入用户输入的关键词
 				for _, t := range request.data.Tokens {
-					tokensMap[t.Text] = t.Locations
+					spans := make([]tokenSpan, len(t.Locations))
+					for i, location := range t.Locations {
+						spans[i] = tokenSpan{location, location + len(t.Text)}
+					}
+					tokensMap[t.Text] = spans
 				}
 				numTokens = len(request.data.Tokens)
 			}
 
+			// 具名文本字段依次排在正文之后分词
+			var fieldLengths map[string]float32
+			if !engine.initOptions.NotUsingSegmenter && len(request.data.TextFields) > 0 {
+				fieldLengths = engine.segmentTextFields(request.data, tokensMap)
+				for _, length := range fieldLengths {
+					numTokens += int(length)
+				}
+			}
+
+			// 含汉字的关键词加入拼音，不计入文档长度
+			if engine.pinyin != nil {
+				engine.pinyin.addPinyinTokens(tokensMap, request.data.TextFields)
+			}
+
 			// 加入非分词的文档标签
 			for _, label := range request.data.Labels {
 				//当正文中已存在关键字时，若不判断，位置信息将会丢失
 				if _, ok := tokensMap[label]; !ok {
-					tokensMap[label] = []int{}
+					tokensMap[label] = []tokenSpan{}
 				}
 			}
 
 			indexerRequest := indexerAddDocumentRequest{
 				document: &types.DocumentIndex{
-					DocID:       request.docID,
-					TokenLength: float32(numTokens),
-					Keywords:    make([]types.KeywordIndex, len(tokensMap)),
+					DocID:        request.docID,
+					TokenLength:  float32(numTokens),
+					FieldLengths: fieldLengths,
+					Keywords:     make([]types.KeywordIndex, len(tokensMap)),
+					Attributes:   request.data.Attributes,
 				},
 				forceUpdate: request.forceUpdate,
+				ack:         request.ack,
 			}
 			iTokens := 0
 			for k, v := range tokensMap {
-				indexerRequest.document.Keywords[iTokens] = types.KeywordIndex{
-					Text: k,
-					// 非分词标注的词频设置为0，不参与tf-idf计算
-					Frequency: float32(len(v)),
-					Starts:    v}
+				indexerRequest.document.Keywords[iTokens] = keywordIndex(k, v)
 				iTokens++
 			}
 
@@ -81,8 +106,79 @@ func (engine *Engine) segmenterWorker() {
 				}
 			}
 			rankerRequest := rankerAddDocRequest{
-				docID: request.docID, fields: request.data.Fields}
+				docID: request.docID, fields: request.data.Fields, ack: request.ack}
 			engine.rankerAddDocChannels[shard] <- rankerRequest
 		}
 	}
 }
+
+// 由关键词的全部出现得到反向索引项，原文长度都与关键词相同时不记录结束位置
+func keywordIndex(text string, spans []tokenSpan) types.KeywordIndex {
+	keyword := types.KeywordIndex{
+		Text: text,
+		// 非分词标注的词频设置为0，不参与tf-idf计算
+		Frequency: float32(len(spans)),
+		Starts:    make([]int, len(spans)),
+	}
+	sameLength := true
+	for i, span := range spans {
+		keyword.Starts[i] = span.start
+		sameLength = sameLength && span.end-span.start == len(text)
+	}
+	if !sameLength {
+		keyword.Ends = make([]int, len(spans))
+		for i, span := range spans {
+			keyword.Ends[i] = span.end
+		}
+	}
+	return keyword
+}
+
+// 对各具名文本字段分词，关键词同时以原文和FieldKeyword(字段名, 关键词)加入tokensMap，返回各字段的关键词长
+// 字段在全文中的位置见textFieldsLayout，因此关键词位置仍是升序且不重叠
+func (engine *Engine) segmentTextFields(data types.DocumentIndexData, tokensMap map[string][]tokenSpan) map[string]float32 {
+	fields, starts := textFieldsLayout(indexedContentLength(data), data.TextFields)
+	fieldLengths := make(map[string]float32, len(fields))
+	for i, field := range fields {
+		tokens := engine.initOptions.IndexAnalyzer.Analyze(data.TextFields[field])
+		for _, token := range tokens {
+			span := tokenSpan{starts[i] + token.Start, starts[i] + token.End}
+			tokensMap[token.Text] = append(tokensMap[token.Text], span)
+			keyword := types.FieldKeyword(field, token.Text)
+			tokensMap[keyword] = append(tokensMap[keyword], span)
+		}
+		fieldLengths[field] = float32(len(tokens))
+	}
+	return fieldLengths
+}
+
+// 全文中正文所占的长度，正文为空时用户输入的关键词没有对应的原文，为其最后位置之后
+func indexedContentLength(data types.DocumentIndexData) int {
+	length := len(data.Content)
+	if data.Content == "" {
+		for _, t := range data.Tokens {
+			for _, location := range t.Locations {
+				length = utils.MaxInt(length, location+len(t.Text))
+			}
+		}
+	}
+	return length
+}
+
+// 分词和高亮共用的全文排布：各具名文本字段按字段名排序，依次接在长为contentLength的正文之后，以一个换行分隔
+// 返回排序后的字段名和各字段在全文中的起始位置
+func textFieldsLayout(contentLength int, textFields map[string]string) (fields []string, starts []int) {
+	fields = make([]string, 0, len(textFields))
+	for field := range textFields {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	starts = make([]int, len(fields))
+	offset := contentLength
+	for i, field := range fields {
+		starts[i] = offset + 1
+		offset = starts[i] + len(textFields[field])
+	}
+	return
+}
@@ -0,0 +1,112 @@
+package engine
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/pickjunk/wuneng/core"
+)
+
+const (
+	snapshotMagic   = "WUNENG-SNAPSHOT"
+	snapshotVersion = 5
+)
+
+// ErrSnapshotFormat 快照格式错误或版本不支持
+var ErrSnapshotFormat = errors.New("wuneng: 快照格式错误")
+
+// Snapshot 将各裂分的索引器和排序器写入w
+// 快照期间的写入请求会阻塞到快照完成，写入前等待此前的请求全部加入索引，因此各裂分处于同一时刻
+// 评分字段用gob序列化，其具体类型需先用gob.Register注册
+//
+// 快照格式：魔数、版本号、NumShards和IndexType，之后依次为每个裂分的索引器和排序器
+func (engine *Engine) Snapshot(w io.Writer) error {
+	if err := engine.checkState(); err != nil {
+		return err
+	}
+	engine.writeLock.Lock()
+	defer engine.writeLock.Unlock()
+	engine.flushIndex()
+	return engine.writeSnapshot(w)
+}
+
+func (engine *Engine) writeSnapshot(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	header := make([]byte, 0, len(snapshotMagic)+3*binary.MaxVarintLen64)
+	header = append(header, snapshotMagic...)
+	header = appendUvarint(header, snapshotVersion)
+	header = appendUvarint(header, uint64(engine.initOptions.NumShards))
+	header = appendUvarint(header, uint64(engine.initOptions.IndexerInitOptions.IndexType))
+	if _, err := bw.Write(header); err != nil {
+		return err
+	}
+
+	for shard := 0; shard < engine.initOptions.NumShards; shard++ {
+		if err := engine.indexers[shard].Snapshot(bw); err != nil {
+			return engineError(err)
+		}
+		if err := engine.rankers[shard].Snapshot(bw); err != nil {
+			return engineError(err)
+		}
+	}
+	return bw.Flush()
+}
+
+// Restore 用Snapshot写入的数据替换引擎中的全部索引和评分字段
+// 快照的NumShards和IndexType须与引擎一致，调用期间不应有其它写入请求
+// 恢复的文档不会写入持久存储
+func (engine *Engine) Restore(r io.Reader) error {
+	if err := engine.checkState(); err != nil {
+		return err
+	}
+	return engine.restoreSnapshot(r)
+}
+
+func (engine *Engine) restoreSnapshot(r io.Reader) error {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(snapshotMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != snapshotMagic {
+		return ErrSnapshotFormat
+	}
+	version, err := binary.ReadUvarint(br)
+	if err != nil || version != snapshotVersion {
+		return ErrSnapshotFormat
+	}
+	numShards, err := binary.ReadUvarint(br)
+	if err != nil {
+		return ErrSnapshotFormat
+	}
+	indexType, err := binary.ReadUvarint(br)
+	if err != nil {
+		return ErrSnapshotFormat
+	}
+	if int(numShards) != engine.initOptions.NumShards ||
+		int(indexType) != engine.initOptions.IndexerInitOptions.IndexType {
+		return fmt.Errorf("%w: 快照NumShards=%d IndexType=%d与引擎不一致",
+			ErrSnapshotFormat, numShards, indexType)
+	}
+
+	for shard := 0; shard < engine.initOptions.NumShards; shard++ {
+		if err := engine.indexers[shard].Restore(br); err != nil {
+			if errors.Is(err, core.ErrNotInitialized) {
+				return ErrNotInitialized
+			}
+			return fmt.Errorf("%w: %v", ErrSnapshotFormat, err)
+		}
+		if err := engine.rankers[shard].Restore(br); err != nil {
+			if errors.Is(err, core.ErrNotInitialized) {
+				return ErrNotInitialized
+			}
+			return fmt.Errorf("%w: %v", ErrSnapshotFormat, err)
+		}
+	}
+	return nil
+}
+
+func appendUvarint(buf []byte, x uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	return append(buf, tmp[:binary.PutUvarint(tmp[:], x)]...)
+}
@@ -0,0 +1,55 @@
+package engine
+
+import (
+	"github.com/pickjunk/wuneng/types"
+)
+
+// Suggest 返回以prefix开头的关键词及包含它的文档数，按文档数从大到小排列，最多n个，用于搜索框的自动补全
+//
+// labels不为空时只统计同时带有全部标签的文档。关键词也包括标签，但不包括拼音和限定字段的搜索键，
+// 除非prefix本身就是这样的搜索键，如Suggest(types.FieldKeyword("title", "手"), ...)只补全标题中的关键词。
+// 尚在索引器缓存中的文档不计入，需要时先调用FlushIndex
+func (engine *Engine) Suggest(prefix string, n int, labels []string) ([]types.Suggestion, error) {
+	if err := engine.checkState(); err != nil {
+		return nil, err
+	}
+	if n <= 0 {
+		return nil, nil
+	}
+
+	// 先取各shard的前n个作为候选，再统计候选在全部shard中的文档数，
+	// 保证只出现在部分shard前n个中的关键词也按总数排序
+	candidates := make(map[string]bool)
+	for shard := range engine.indexers {
+		shardSuggestions, err := engine.indexers[shard].Suggest(prefix, labels, n)
+		if err != nil {
+			return nil, engineError(err)
+		}
+		for _, suggestion := range shardSuggestions {
+			candidates[suggestion.Text] = true
+		}
+	}
+	terms := make([]string, 0, len(candidates))
+	for term := range candidates {
+		terms = append(terms, term)
+	}
+
+	suggestions := make([]types.Suggestion, len(terms))
+	for i, term := range terms {
+		suggestions[i].Text = term
+	}
+	for shard := range engine.indexers {
+		counts, err := engine.indexers[shard].TermCounts(terms, labels)
+		if err != nil {
+			return nil, engineError(err)
+		}
+		for i, count := range counts {
+			suggestions[i].Frequency += count
+		}
+	}
+	types.SortSuggestions(suggestions)
+	if len(suggestions) > n {
+		suggestions = suggestions[:n]
+	}
+	return suggestions, nil
+}
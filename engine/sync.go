@@ -0,0 +1,142 @@
+package engine
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pickjunk/wuneng/types"
+)
+
+// 同步写请求的回执，全部子任务回执后关闭done
+// 为nil时所有方法都是空操作，以便异步写请求共用同一套worker
+type writeAck struct {
+	remaining int64
+	done      chan struct{}
+
+	lock sync.Mutex
+	err  error
+}
+
+func newWriteAck(n int) *writeAck {
+	ack := &writeAck{remaining: int64(n), done: make(chan struct{})}
+	if n == 0 {
+		close(ack.done)
+	}
+	return ack
+}
+
+// 一个子任务完成，err不为nil时记录第一个错误
+func (ack *writeAck) finish(err error) {
+	if ack == nil {
+		return
+	}
+	if err != nil {
+		ack.lock.Lock()
+		if ack.err == nil {
+			ack.err = err
+		}
+		ack.lock.Unlock()
+	}
+	if atomic.AddInt64(&ack.remaining, -1) == 0 {
+		close(ack.done)
+	}
+}
+
+// 等待全部子任务完成或ctx取消
+func (ack *writeAck) wait(ctx context.Context) error {
+	select {
+	case <-ack.done:
+		ack.lock.Lock()
+		defer ack.lock.Unlock()
+		return ack.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// IndexDocumentSync 将文档加入索引，阻塞直到文档可以被搜索到
+//
+// 与IndexDocument不同，函数返回nil时文档已进入所在shard的反向索引表和排序器，
+// 使用持久存储或保存文档时也已写入持久存储和文档存储。ctx取消时返回ctx.Err()，但已提交的文档仍会被索引。
+func (engine *Engine) IndexDocumentSync(ctx context.Context, docID uint64, data types.DocumentIndexData) error {
+	return engine.IndexDocumentsSync(ctx, []types.BatchDocument{{DocID: docID, Data: data}})
+}
+
+// IndexDocumentsSync 批量将文档加入索引，阻塞直到全部文档可以被搜索到
+//
+// 整批文档只在最后强制刷新一次索引器缓存，比逐个调用IndexDocumentSync高效得多
+func (engine *Engine) IndexDocumentsSync(ctx context.Context, documents []types.BatchDocument) error {
+	if err := engine.checkState(); err != nil {
+		return err
+	}
+	for _, document := range documents {
+		if document.DocID == 0 {
+			return ErrInvalidDocID
+		}
+		if err := document.Data.Validate(); err != nil {
+			return err
+		}
+	}
+
+	// 每个文档需要索引器和排序器各回执一次，使用持久存储和保存文档时各再加一次
+	n := 2
+	if engine.initOptions.UsePersistentStorage {
+		n++
+	}
+	if engine.documents != nil {
+		n++
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	records := make([]walRecord, len(documents))
+	for i, document := range documents {
+		records[i] = walRecord{docID: document.DocID, data: document.Data}
+	}
+	ack := newWriteAck(n * len(documents))
+	if err := engine.submitWrites(records, ack); err != nil {
+		return err
+	}
+	if err := ack.wait(ctx); err != nil {
+		return err
+	}
+
+	// 此时文档都已进入各shard的缓存，强制刷新请求排在其后
+	return engine.flushIndexerCaches(ctx)
+}
+
+// RemoveDocumentSync 将文档从索引中删除，阻塞直到文档不再能被搜索到
+// ctx取消时返回ctx.Err()，但删除请求仍会被执行
+func (engine *Engine) RemoveDocumentSync(ctx context.Context, docID uint64) error {
+	if err := engine.checkState(); err != nil {
+		return err
+	}
+	if docID == 0 {
+		return ErrInvalidDocID
+	}
+
+	// 文档在索引器中被标记为待删除后即不会被搜索到，无需强制刷新
+	n := 2 * engine.initOptions.NumShards
+	if engine.initOptions.UsePersistentStorage {
+		n++
+	}
+	if engine.documents != nil {
+		n++
+	}
+	ack := newWriteAck(n)
+	if err := engine.submitWrites([]walRecord{{docID: docID, remove: true}}, ack); err != nil {
+		return err
+	}
+	return ack.wait(ctx)
+}
+
+// 强制所有shard的索引器将缓存合并到反向索引表，并等待完成
+func (engine *Engine) flushIndexerCaches(ctx context.Context) error {
+	ack := newWriteAck(engine.initOptions.NumShards)
+	atomic.AddUint64(&engine.numForceUpdatingRequests, 1)
+	for shard := 0; shard < engine.initOptions.NumShards; shard++ {
+		engine.indexerAddDocChannels[shard] <- indexerAddDocumentRequest{forceUpdate: true, ack: ack}
+	}
+	return ack.wait(ctx)
+}
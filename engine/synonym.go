@@ -0,0 +1,168 @@
+package engine
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/pickjunk/wuneng/types"
+)
+
+// 同义词词典，关键词 -> 同义词切分后的关键词序列
+type synonymDictionary struct {
+	expansions map[string][][]string
+}
+
+// SetSynonyms 替换全部同义词规则，立即对之后的搜索生效，无需重建索引
+//
+// 同义词在搜索时展开：不在NOT子句下的关键词与其同义词为OR关系，同义词与原词得分相同，
+// 切分为多个关键词的同义词作为短语匹配。规则中的词先用QueryAnalyzer切分，
+// 只有切分为一个关键词的词才会被展开，rules为空时清除同义词
+func (engine *Engine) SetSynonyms(rules []types.SynonymRule) error {
+	if err := engine.checkState(); err != nil {
+		return err
+	}
+	engine.setSynonyms(rules)
+	return nil
+}
+
+// LoadSynonyms 从文件载入同义词规则并替换现有的规则，文件格式见EngineInitOptions.SynonymFile
+func (engine *Engine) LoadSynonyms(file string) error {
+	if err := engine.checkState(); err != nil {
+		return err
+	}
+	rules, err := readSynonymFile(file)
+	if err != nil {
+		return err
+	}
+	engine.setSynonyms(rules)
+	return nil
+}
+
+func (engine *Engine) setSynonyms(rules []types.SynonymRule) {
+	dictionary := &synonymDictionary{expansions: make(map[string][][]string)}
+	seen := make(map[string]map[string]bool)
+	add := func(word string, synonyms []string) {
+		keys := engine.analyzeQuery(word)
+		if len(keys) != 1 {
+			return
+		}
+		key := keys[0]
+		for _, synonym := range synonyms {
+			tokens := engine.analyzeQuery(synonym)
+			text := strings.Join(tokens, " ")
+			if len(tokens) == 0 || (len(tokens) == 1 && tokens[0] == key) || seen[key][text] {
+				continue
+			}
+			if seen[key] == nil {
+				seen[key] = make(map[string]bool)
+			}
+			seen[key][text] = true
+			dictionary.expansions[key] = append(dictionary.expansions[key], tokens)
+		}
+	}
+	for _, rule := range rules {
+		synonyms := rule.Synonyms
+		if len(synonyms) == 0 {
+			synonyms = rule.Words
+		}
+		for _, word := range rule.Words {
+			add(word, synonyms)
+		}
+	}
+	engine.synonyms.Store(dictionary)
+}
+
+func readSynonymFile(file string) ([]types.SynonymRule, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDictionaryNotFound, err)
+	}
+	defer f.Close()
+	return parseSynonyms(f)
+}
+
+// 解析同义词文件，格式见EngineInitOptions.SynonymFile
+func parseSynonyms(r io.Reader) ([]types.SynonymRule, error) {
+	var rules []types.SynonymRule
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		var rule types.SynonymRule
+		if i := strings.Index(text, "=>"); i >= 0 {
+			rule.Words = splitSynonyms(text[:i])
+			rule.Synonyms = splitSynonyms(text[i+2:])
+			if len(rule.Words) == 0 || len(rule.Synonyms) == 0 {
+				return nil, fmt.Errorf("%w: 第%d行 %q", ErrInvalidSynonym, line, text)
+			}
+		} else {
+			rule.Words = splitSynonyms(text)
+			if len(rule.Words) < 2 {
+				return nil, fmt.Errorf("%w: 第%d行 %q", ErrInvalidSynonym, line, text)
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules, scanner.Err()
+}
+
+// 以半角或全角逗号分隔的词，忽略空白和空词
+func splitSynonyms(text string) []string {
+	var words []string
+	for _, word := range strings.FieldsFunc(text, func(r rune) bool { return r == ',' || r == '，' }) {
+		if word = strings.TrimSpace(word); word != "" {
+			words = append(words, word)
+		}
+	}
+	return words
+}
+
+// 当前的同义词词典，没有同义词时返回nil
+func (engine *Engine) synonymDictionary() *synonymDictionary {
+	dictionary, _ := engine.synonyms.Load().(*synonymDictionary)
+	if dictionary == nil || len(dictionary.expansions) == 0 {
+		return nil
+	}
+	return dictionary
+}
+
+// 把查询树中不在NOT子句下、有同义词的叶子节点扩展为该关键词与其同义词的OR，短语节点不扩展
+func (dictionary *synonymDictionary) expandQuery(query types.Query) types.Query {
+	if query.IsLeaf() {
+		expansions := dictionary.expansions[query.Token]
+		if len(expansions) == 0 {
+			return query
+		}
+		expanded := types.Query{Should: []types.Query{query}}
+		for _, tokens := range expansions {
+			synonym := types.Query{Field: query.Field, Boost: query.Boost}
+			if len(tokens) == 1 {
+				synonym.Token = tokens[0]
+			} else {
+				synonym.Phrase = tokens
+			}
+			expanded.Should = append(expanded.Should, synonym)
+		}
+		return expanded
+	}
+	if query.IsPhrase() {
+		return query
+	}
+
+	expanded := query
+	expanded.Must = make([]types.Query, len(query.Must))
+	for i := range query.Must {
+		expanded.Must[i] = dictionary.expandQuery(query.Must[i])
+	}
+	expanded.Should = make([]types.Query, len(query.Should))
+	for i := range query.Should {
+		expanded.Should[i] = dictionary.expandQuery(query.Should[i])
+	}
+	return expanded
+}
@@ -0,0 +1,357 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pickjunk/wuneng/types"
+)
+
+const (
+	// 预写日志和检查点快照的文件名，保存在PersistentStorageFolder中
+	WALFileName      = PersistentStorageFilePrefix + ".wal"
+	SnapshotFileName = PersistentStorageFilePrefix + ".snapshot"
+
+	walOpIndex  = 1
+	walOpRemove = 2
+)
+
+// 预写日志中的一条写请求
+type walRecord struct {
+	docID       uint64
+	data        types.DocumentIndexData
+	remove      bool
+	forceUpdate bool
+}
+
+// 预写日志
+// 每条记录为：uvarint记录长度、crc32校验和、操作类型、forceUpdate、uvarint DocID，添加文档时再加gob序列化的文档
+type writeAheadLog struct {
+	// 日志文件长度，放在首位保证64位对齐
+	size int64
+
+	// 写请求持有读锁直到请求发往worker，检查点持有写锁，
+	// 保证检查点清空日志时不存在已写入日志但尚未发往worker的请求
+	sync.RWMutex
+	closed bool
+
+	fileLock sync.Mutex
+	file     *os.File
+
+	checkpointing int32
+}
+
+// 打开或创建预写日志，读出其中的全部记录，末尾不完整的记录会被截断
+func openWAL(path string) (*writeAheadLog, []walRecord, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	records, size, err := readWAL(bufio.NewReader(file))
+	if err != nil {
+		log.Error().Err(err).Str("path", path).Int64("offset", size).Msg("预写日志末尾不完整，已截断")
+	}
+	if err := file.Truncate(size); err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	if _, err := file.Seek(size, io.SeekStart); err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	return &writeAheadLog{file: file, size: size}, records, nil
+}
+
+// 顺序读出记录，返回完整记录的总长度，遇到不完整或校验失败的记录时返回错误
+func readWAL(r *bufio.Reader) (records []walRecord, size int64, err error) {
+	for {
+		length, err := binary.ReadUvarint(r)
+		if err == io.EOF {
+			return records, size, nil
+		}
+		if err != nil {
+			return records, size, err
+		}
+		// 记录长度远超单个文档的合理大小，视为损坏
+		if length > 1<<30 {
+			return records, size, errors.New("记录长度不合法")
+		}
+		buf := make([]byte, 4+length)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return records, size, err
+		}
+		payload := buf[4:]
+		if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(buf[:4]) {
+			return records, size, errors.New("校验和不一致")
+		}
+		size += int64(uvarintLen(length)) + int64(len(buf))
+
+		record, err := decodeWALRecord(payload)
+		if err != nil {
+			// 通常是 Fields 的具体类型没有用 gob.Register 注册
+			log.Error().Err(err).Uint64("docID", record.docID).Msg("预写日志记录反序列化失败")
+			continue
+		}
+		records = append(records, record)
+	}
+}
+
+// 追加记录并同步到磁盘，DocID为0的记录被忽略
+func (wal *writeAheadLog) append(records []walRecord) error {
+	var buf bytes.Buffer
+	var header [binary.MaxVarintLen64 + 4]byte
+	for _, record := range records {
+		if record.docID == 0 {
+			continue
+		}
+		payload, err := encodeWALRecord(record)
+		if err != nil {
+			return err
+		}
+		n := binary.PutUvarint(header[:], uint64(len(payload)))
+		binary.LittleEndian.PutUint32(header[n:], crc32.ChecksumIEEE(payload))
+		buf.Write(header[:n+4])
+		buf.Write(payload)
+	}
+	if buf.Len() == 0 {
+		return nil
+	}
+
+	wal.fileLock.Lock()
+	defer wal.fileLock.Unlock()
+	if _, err := wal.file.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	if err := wal.file.Sync(); err != nil {
+		return err
+	}
+	atomic.AddInt64(&wal.size, int64(buf.Len()))
+	return nil
+}
+
+// 清空日志，调用者须持有写锁
+func (wal *writeAheadLog) truncate() error {
+	wal.fileLock.Lock()
+	defer wal.fileLock.Unlock()
+	if err := wal.file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := wal.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	atomic.StoreInt64(&wal.size, 0)
+	return wal.file.Sync()
+}
+
+func encodeWALRecord(record walRecord) ([]byte, error) {
+	var buf bytes.Buffer
+	op := byte(walOpIndex)
+	if record.remove {
+		op = walOpRemove
+	}
+	forceUpdate := byte(0)
+	if record.forceUpdate {
+		forceUpdate = 1
+	}
+	buf.WriteByte(op)
+	buf.WriteByte(forceUpdate)
+	buf.Write(encodeStorageKey(record.docID))
+	if !record.remove {
+		if err := gob.NewEncoder(&buf).Encode(record.data); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeWALRecord(payload []byte) (record walRecord, err error) {
+	if len(payload) < 3 {
+		return record, errors.New("记录过短")
+	}
+	record.remove = payload[0] == walOpRemove
+	record.forceUpdate = payload[1] == 1
+	docID, n := binary.Uvarint(payload[2:])
+	if n <= 0 {
+		return record, errors.New("DocID不合法")
+	}
+	record.docID = docID
+	if !record.remove {
+		err = gob.NewDecoder(bytes.NewReader(payload[2+n:])).Decode(&record.data)
+	}
+	return
+}
+
+func uvarintLen(x uint64) int {
+	var buf [binary.MaxVarintLen64]byte
+	return binary.PutUvarint(buf[:], x)
+}
+
+// 将写请求记入预写日志后发往各worker，不使用预写日志时直接发往worker
+// ack的回执规则同storeDocument、internalIndexDocument、internalRemoveDocument和persistDocument
+func (engine *Engine) submitWrites(records []walRecord, ack *writeAck) error {
+	engine.writeLock.RLock()
+	defer engine.writeLock.RUnlock()
+	if engine.wal != nil {
+		engine.wal.RLock()
+		defer engine.wal.RUnlock()
+		// 检查点或关闭引擎期间等待的请求需重新检查状态
+		if err := engine.checkState(); err != nil {
+			return err
+		}
+		if err := engine.wal.append(records); err != nil {
+			return fmt.Errorf("%w: %v", ErrStorage, err)
+		}
+		if atomic.LoadInt64(&engine.wal.size) > engine.initOptions.WALCheckpointSize &&
+			atomic.CompareAndSwapInt32(&engine.wal.checkpointing, 0, 1) {
+			go engine.autoCheckpoint()
+		}
+	}
+	engine.dispatchWrites(records, ack)
+	return nil
+}
+
+func (engine *Engine) dispatchWrites(records []walRecord, ack *writeAck) {
+	for _, record := range records {
+		if record.remove {
+			engine.storeDocument(record.docID, types.DocumentIndexData{}, true, ack)
+			engine.internalRemoveDocument(record.docID, record.forceUpdate, ack)
+			engine.persistDocument(record.docID, types.DocumentIndexData{}, true, ack)
+		} else {
+			engine.storeDocument(record.docID, record.data, false, ack)
+			engine.internalIndexDocument(record.docID, record.data, record.forceUpdate, ack)
+			engine.persistDocument(record.docID, record.data, false, ack)
+		}
+	}
+}
+
+func (engine *Engine) autoCheckpoint() {
+	defer atomic.StoreInt32(&engine.wal.checkpointing, 0)
+	engine.wal.Lock()
+	defer engine.wal.Unlock()
+	if engine.wal.closed {
+		return
+	}
+	if err := engine.checkpoint(); err != nil {
+		log.Error().Err(err).Msg("预写日志检查点失败")
+	}
+}
+
+// Checkpoint 将预写日志中的请求全部落盘后清空日志
+// 使用持久存储时等待全部文档写入存储，否则将索引快照写入PersistentStorageFolder
+// 未使用预写日志时不做任何事
+func (engine *Engine) Checkpoint() error {
+	if err := engine.checkState(); err != nil {
+		return err
+	}
+	if engine.wal == nil {
+		return nil
+	}
+	engine.wal.Lock()
+	defer engine.wal.Unlock()
+	if engine.wal.closed {
+		return ErrShutdown
+	}
+	return engine.checkpoint()
+}
+
+// 调用者须持有预写日志的写锁
+func (engine *Engine) checkpoint() error {
+	engine.flushIndex()
+	if !engine.initOptions.UsePersistentStorage {
+		if err := engine.writeSnapshotFile(); err != nil {
+			return fmt.Errorf("%w: %v", ErrStorage, err)
+		}
+	}
+	if err := engine.wal.truncate(); err != nil {
+		return fmt.Errorf("%w: %v", ErrStorage, err)
+	}
+	return nil
+}
+
+// 先写入临时文件再改名，保证快照文件总是完整的
+func (engine *Engine) writeSnapshotFile() error {
+	folder := engine.initOptions.PersistentStorageFolder
+	path := filepath.Join(folder, SnapshotFileName)
+	file, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	if err := engine.writeSnapshot(file); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+
+	// 同步目录使改名落盘，部分系统不支持，忽略错误
+	if dir, err := os.Open(folder); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return nil
+}
+
+// 打开预写日志并读出待重放的记录，不使用持久存储时先从检查点快照恢复索引
+// 在拉起worker之前调用
+func (engine *Engine) openWAL() ([]walRecord, error) {
+	folder := engine.initOptions.PersistentStorageFolder
+	if err := os.MkdirAll(folder, 0700); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrStorage, err)
+	}
+
+	if !engine.initOptions.UsePersistentStorage {
+		file, err := os.Open(filepath.Join(folder, SnapshotFileName))
+		if err == nil {
+			err = engine.restoreSnapshot(file)
+			file.Close()
+			if err != nil {
+				return nil, err
+			}
+		} else if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("%w: %v", ErrStorage, err)
+		}
+	}
+
+	wal, records, err := openWAL(filepath.Join(folder, WALFileName))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrStorage, err)
+	}
+	engine.wal = wal
+	return records, nil
+}
+
+// 重放预写日志中的记录，重放的请求不会再次写入日志
+func (engine *Engine) replayWAL(records []walRecord) {
+	engine.dispatchWrites(records, nil)
+	engine.flushIndex()
+}
+
+// 关闭引擎时做最后一次检查点并关闭日志文件，之后的写请求返回ErrShutdown
+func (engine *Engine) closeWAL() {
+	engine.wal.Lock()
+	defer engine.wal.Unlock()
+	if err := engine.checkpoint(); err != nil {
+		log.Error().Err(err).Msg("预写日志检查点失败")
+	}
+	engine.wal.closed = true
+	engine.wal.file.Close()
+}
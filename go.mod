@@ -14,4 +14,5 @@ require (
 	github.com/pickjunk/sego v1.2.0
 	github.com/rs/zerolog v1.19.0 // indirect
 	github.com/shirou/gopsutil v2.19.11+incompatible
+	go.etcd.io/bbolt v1.3.5
 )
@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var wunengBucket = []byte("wuneng")
+
+type boltStorage struct {
+	db *bolt.DB
+}
+
+func openBoltStorage(path string) (Storage, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 3 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(wunengBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &boltStorage{db}, nil
+}
+
+func (s *boltStorage) Set(k, v []byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(wunengBucket).Put(k, v)
+	})
+}
+
+func (s *boltStorage) Get(k []byte) (v []byte, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		// bolt 返回的切片只在事务内有效，需复制一份
+		if value := tx.Bucket(wunengBucket).Get(k); value != nil {
+			v = append([]byte{}, value...)
+		}
+		return nil
+	})
+	return
+}
+
+func (s *boltStorage) Delete(k []byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(wunengBucket).Delete(k)
+	})
+}
+
+func (s *boltStorage) Batch(mutations []Mutation) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(wunengBucket)
+		for _, m := range mutations {
+			var err error
+			if m.Delete {
+				err = bucket.Delete(m.Key)
+			} else {
+				err = bucket.Put(m.Key, m.Value)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *boltStorage) ForEach(fn func(k, v []byte) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(wunengBucket).ForEach(fn)
+	})
+}
+
+func (s *boltStorage) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pickjunk/wuneng/utils"
+)
+
+func TestBoltStorage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wuneng")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "bolt_test")
+
+	s, err := OpenStorage(path)
+	utils.Expect(t, "<nil>", err)
+	s.Set([]byte("key1"), []byte("value1"))
+	s.Set([]byte("key2"), []byte("value2"))
+	s.Set([]byte("key3"), []byte("value3"))
+	s.Delete([]byte("key2"))
+	// 批量写入按顺序执行
+	utils.Expect(t, "<nil>", s.Batch([]Mutation{
+		{Key: []byte("key4"), Value: []byte("value4")},
+		{Key: []byte("key3"), Delete: true},
+		{Key: []byte("key3"), Value: []byte("value3'")},
+	}))
+	s.Close()
+
+	// 重新打开后数据仍在
+	s, err = OpenStorage(path)
+	utils.Expect(t, "<nil>", err)
+	defer s.Close()
+
+	v, err := s.Get([]byte("key1"))
+	utils.Expect(t, "<nil>", err)
+	utils.Expect(t, "value1", string(v))
+	v, _ = s.Get([]byte("key2"))
+	utils.Expect(t, "0", len(v))
+
+	output := ""
+	s.ForEach(func(k, v []byte) error {
+		output += string(k) + "=" + string(v) + " "
+		return nil
+	})
+	utils.Expect(t, "key1=value1 key3=value3' key4=value4 ", output)
+}
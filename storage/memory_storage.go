@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"sort"
+	"sync"
+)
+
+type memoryStorage struct {
+	sync.RWMutex
+	data map[string][]byte
+}
+
+// NewMemoryStorage 创建保存在内存中的存储，关闭后数据即丢失
+func NewMemoryStorage() Storage {
+	return &memoryStorage{data: make(map[string][]byte)}
+}
+
+func (s *memoryStorage) Set(k, v []byte) error {
+	s.Lock()
+	s.data[string(k)] = append([]byte{}, v...)
+	s.Unlock()
+	return nil
+}
+
+func (s *memoryStorage) Get(k []byte) ([]byte, error) {
+	s.RLock()
+	defer s.RUnlock()
+	// 与 bolt 一致，返回一份复制
+	if v, found := s.data[string(k)]; found {
+		return append([]byte{}, v...), nil
+	}
+	return nil, nil
+}
+
+func (s *memoryStorage) Delete(k []byte) error {
+	s.Lock()
+	delete(s.data, string(k))
+	s.Unlock()
+	return nil
+}
+
+func (s *memoryStorage) Batch(mutations []Mutation) error {
+	s.Lock()
+	for _, m := range mutations {
+		if m.Delete {
+			delete(s.data, string(m.Key))
+		} else {
+			s.data[string(m.Key)] = append([]byte{}, m.Value...)
+		}
+	}
+	s.Unlock()
+	return nil
+}
+
+func (s *memoryStorage) ForEach(fn func(k, v []byte) error) error {
+	// 遍历一份键的快照，fn 中可以修改存储
+	s.RLock()
+	keys := make([]string, 0, len(s.data))
+	for k := range s.data {
+		keys = append(keys, k)
+	}
+	s.RUnlock()
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		s.RLock()
+		v, found := s.data[k]
+		s.RUnlock()
+		if !found {
+			continue
+		}
+		if err := fn([]byte(k), v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *memoryStorage) Close() error {
+	s.Lock()
+	s.data = make(map[string][]byte)
+	s.Unlock()
+	return nil
+}
@@ -0,0 +1,36 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/pickjunk/wuneng/utils"
+)
+
+func TestMemoryStorage(t *testing.T) {
+	s := NewMemoryStorage()
+	s.Set([]byte("key2"), []byte("value2"))
+	s.Set([]byte("key1"), []byte("value1"))
+	s.Set([]byte("key3"), []byte("value3"))
+	s.Delete([]byte("key2"))
+	utils.Expect(t, "<nil>", s.Batch([]Mutation{
+		{Key: []byte("key4"), Value: []byte("value4")},
+		{Key: []byte("key3"), Delete: true},
+	}))
+
+	v, err := s.Get([]byte("key1"))
+	utils.Expect(t, "<nil>", err)
+	utils.Expect(t, "value1", string(v))
+	v, _ = s.Get([]byte("key2"))
+	utils.Expect(t, "0", len(v))
+
+	output := ""
+	s.ForEach(func(k, v []byte) error {
+		output += string(k) + "=" + string(v) + " "
+		return nil
+	})
+	utils.Expect(t, "key1=value1 key4=value4 ", output)
+
+	s.Close()
+	v, _ = s.Get([]byte("key1"))
+	utils.Expect(t, "0", len(v))
+}
@@ -0,0 +1,35 @@
+package storage
+
+// Storage 持久化存储的通用接口，键值均为任意字节串
+type Storage interface {
+	// 写入一个键值对，键已存在时覆盖
+	Set(k, v []byte) error
+
+	// 读取键对应的值，键不存在时返回 nil
+	Get(k []byte) ([]byte, error)
+
+	// 删除一个键，键不存在时不报错
+	Delete(k []byte) error
+
+	// 在一个事务中依次执行一批写入和删除，全部成功或全部失败，磁盘存储只同步一次
+	Batch(mutations []Mutation) error
+
+	// 按键的字节序遍历全部键值对，fn 返回错误时中止遍历
+	// 注意：k 和 v 仅在 fn 调用期间有效
+	ForEach(fn func(k, v []byte) error) error
+
+	// 关闭存储
+	Close() error
+}
+
+// Mutation 批量写入中的一项，Delete 为 true 时删除 Key，否则写入 Key 和 Value
+type Mutation struct {
+	Key    []byte
+	Value  []byte
+	Delete bool
+}
+
+// OpenStorage 打开或创建 path 处的存储
+func OpenStorage(path string) (Storage, error) {
+	return openBoltStorage(path)
+}
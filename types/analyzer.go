@@ -0,0 +1,26 @@
+package types
+
+// AnalyzedToken 分析器切分出的一个关键词
+type AnalyzedToken struct {
+	// 关键词（必须是UTF-8格式）
+	Text string
+
+	// 关键词在原文中的起止字节位置[Start, End)
+	Start int
+	End   int
+}
+
+// Analyzer 分析器，把文本切分为关键词，见EngineInitOptions.IndexAnalyzer和QueryAnalyzer
+//
+// 返回的关键词按在原文中的顺序排列，位置可以重叠（如索引时的全切分）。实现须是线程安全的
+type Analyzer interface {
+	Analyze(text string) []AnalyzedToken
+}
+
+// TokenFilter 关键词过滤器，对分析器切分出的关键词做转换或删除，见EngineInitOptions.TokenFilters
+//
+// 过滤器可以修改关键词的Text，但不应修改Start和End，Text的长度改变时短语和紧邻距离仍按原文的[Start, End)计算。
+// 实现须是线程安全的
+type TokenFilter interface {
+	Filter(tokens []AnalyzedToken) []AnalyzedToken
+}
@@ -0,0 +1,148 @@
+package types
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+const (
+	cursorVersion = 1
+
+	cursorNil    = 0
+	cursorInt    = 1
+	cursorFloat  = 2
+	cursorString = 3
+)
+
+// EncodeCursor 将文档的DocID、Scores和SortValues编码为翻页游标
+func EncodeCursor(doc ScoredDocument) string {
+	buf := []byte{cursorVersion}
+	buf = appendUvarint(buf, doc.DocID)
+	buf = appendUvarint(buf, uint64(len(doc.Scores)))
+	for _, score := range doc.Scores {
+		buf = appendUvarint(buf, uint64(math.Float32bits(score)))
+	}
+	buf = appendUvarint(buf, uint64(len(doc.SortValues)))
+	for _, value := range doc.SortValues {
+		switch v := value.(type) {
+		case int64:
+			buf = append(buf, cursorInt)
+			buf = appendVarint(buf, v)
+		case float64:
+			buf = append(buf, cursorFloat)
+			buf = appendUvarint(buf, math.Float64bits(v))
+		case string:
+			buf = append(buf, cursorString)
+			buf = appendUvarint(buf, uint64(len(v)))
+			buf = append(buf, v...)
+		default:
+			buf = append(buf, cursorNil)
+		}
+	}
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+// DecodeCursor 解码EncodeCursor生成的翻页游标，得到的文档只有DocID、Scores和SortValues
+func DecodeCursor(cursor string) (doc ScoredDocument, err error) {
+	buf, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || len(buf) == 0 || buf[0] != cursorVersion {
+		return doc, fmt.Errorf("%w: %q", ErrInvalidCursor, cursor)
+	}
+	d := cursorDecoder{buf: buf[1:]}
+
+	doc.DocID = d.uvarint()
+	if n := d.length(); n > 0 {
+		doc.Scores = make([]float32, n)
+		for i := range doc.Scores {
+			doc.Scores[i] = math.Float32frombits(uint32(d.uvarint()))
+		}
+	}
+	if n := d.length(); n > 0 {
+		doc.SortValues = make([]interface{}, n)
+		for i := range doc.SortValues {
+			switch d.byte() {
+			case cursorInt:
+				doc.SortValues[i] = d.varint()
+			case cursorFloat:
+				doc.SortValues[i] = math.Float64frombits(d.uvarint())
+			case cursorString:
+				doc.SortValues[i] = string(d.bytes(d.length()))
+			case cursorNil:
+			default:
+				d.err = true
+			}
+		}
+	}
+	if d.err || len(d.buf) != 0 {
+		return ScoredDocument{}, fmt.Errorf("%w: %q", ErrInvalidCursor, cursor)
+	}
+	return doc, nil
+}
+
+func appendUvarint(buf []byte, x uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	return append(buf, tmp[:binary.PutUvarint(tmp[:], x)]...)
+}
+
+func appendVarint(buf []byte, x int64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	return append(buf, tmp[:binary.PutVarint(tmp[:], x)]...)
+}
+
+// 出错后读到的都是零值
+type cursorDecoder struct {
+	buf []byte
+	err bool
+}
+
+func (d *cursorDecoder) uvarint() uint64 {
+	x, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.err = true
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return x
+}
+
+func (d *cursorDecoder) varint() int64 {
+	x, n := binary.Varint(d.buf)
+	if n <= 0 {
+		d.err = true
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return x
+}
+
+// 读取长度并检查不超过剩余字节数，防止恶意游标导致大量分配
+func (d *cursorDecoder) length() int {
+	n := d.uvarint()
+	if n > uint64(len(d.buf)) {
+		d.err = true
+		return 0
+	}
+	return int(n)
+}
+
+func (d *cursorDecoder) byte() byte {
+	if len(d.buf) == 0 {
+		d.err = true
+		return 0
+	}
+	b := d.buf[0]
+	d.buf = d.buf[1:]
+	return b
+}
+
+func (d *cursorDecoder) bytes(n int) []byte {
+	if len(d.buf) < n {
+		d.err = true
+		return nil
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
@@ -1,5 +1,10 @@
 package types
 
+import (
+	"fmt"
+	"unicode"
+)
+
 // DocumentIndexData struct
 type DocumentIndexData struct {
 	// 文档全文（必须是UTF-8格式），用于生成待索引的关键词
@@ -11,9 +16,19 @@ type DocumentIndexData struct {
 	// 进行分词和预处理。
 	Tokens []TokenData
 
+	// 文档的具名文本字段（必须是UTF-8格式），字段名 -> 文本，比如title、body、tags
+	// 各字段分别分词，其中的关键词既作为普通关键词加入索引，也以FieldKeyword(字段名, 关键词)为搜索键加入，
+	// 后者用于把查询限定在某个字段和计算BM25F。字段名只能包含字母、数字和下划线，不使用分词器时被忽略
+	TextFields map[string]string
+
 	// 文档标签（必须是UTF-8格式），比如文档的类别属性等，这些标签并不出现在文档文本中
+	// 标签不能以"field#"或"pinyin#"开头，这两个前缀保留给FieldKeyword和PinyinKeyword
 	Labels []string
 
+	// 文档的数值属性，比如价格、发布时间等，可用SearchRequest.Filters按范围过滤
+	// 整数值须在±2^53以内才能精确表示
+	Attributes map[string]float64
+
 	// 文档的评分字段，可以接纳任何类型的结构体
 	Fields interface{}
 }
@@ -26,3 +41,40 @@ type TokenData struct {
 	// 关键词的首字节在文档中出现的位置
 	Locations []int
 }
+
+// BatchDocument 批量索引中的一个文档
+type BatchDocument struct {
+	// 标识文档编号，必须唯一且不为0
+	DocID uint64
+
+	// 见DocumentIndexData注释
+	Data DocumentIndexData
+}
+
+// Validate 检查字段名和标签，字段名不合法时返回ErrInvalidFieldName，标签以保留的前缀开头时返回ErrReservedLabel
+func (data *DocumentIndexData) Validate() error {
+	for field := range data.TextFields {
+		if !ValidFieldName(field) {
+			return fmt.Errorf("%w: %q", ErrInvalidFieldName, field)
+		}
+	}
+	for _, label := range data.Labels {
+		if IsReservedKeyword(label) {
+			return fmt.Errorf("%w: %q", ErrReservedLabel, label)
+		}
+	}
+	return nil
+}
+
+// ValidFieldName 字段名是否只包含字母、数字和下划线
+func ValidFieldName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return true
+}
@@ -1,6 +1,7 @@
 package types
 
 import (
+	"fmt"
 	"runtime"
 )
 
@@ -24,20 +25,52 @@ var (
 		B:  0.75,
 	}
 	defaultPersistentStorageShards = 8
+	defaultWALCheckpointSize       = int64(64 << 20)
 )
 
 // EngineInitOptions 初始化引擎选项
 type EngineInitOptions struct {
 	// 是否使用分词器
-	// 默认使用，否则在启动阶段跳过SegmenterDictionaries和StopTokenFile设置
+	// 默认使用，否则在启动阶段跳过SegmenterDictionaries和StopTokenFile设置，也不使用下面的分析器
 	// 如果你不需要在引擎内分词，可以将这个选项设为true
 	// 注意，如果你不用分词器，那么在调用IndexDocument时DocumentIndexData中的Content会被忽略
 	NotUsingSegmenter bool
 
 	// 半角逗号分隔的字典文件，具体用法见
 	// sego.Segmenter.LoadDictionary函数的注释
+	// 同时设置了IndexAnalyzer和QueryAnalyzer时可以为空
 	SegmenterDictionaries string
 
+	// 索引时切分Content和TextFields的分析器，为nil时使用sego全切分（analyzer.SegoAnalyzer{Full: true}）
+	IndexAnalyzer Analyzer
+
+	// 搜索时切分Text和QueryText的分析器，为nil时使用sego普通切分（analyzer.SegoAnalyzer{Full: false}）
+	// 通常与IndexAnalyzer切分方式一致，否则搜索的关键词可能不在索引中
+	QueryAnalyzer Analyzer
+
+	// 关键词过滤器，索引和搜索时依次作用于IndexAnalyzer和QueryAnalyzer的输出，保证两者一致，
+	// 如analyzer.LowercaseFilter、WidthFilter、TraditionalFilter、MinLengthFilter和PunctuationFilter。
+	// 用户提供的DocumentIndexData.Tokens和SearchRequest.Tokens不经过过滤器
+	TokenFilters []TokenFilter
+
+	// 停用词文件，每行一个词，不为空时在TokenFilters之后去掉这些词（见analyzer.StopFilter），
+	// 停用词既不加入索引也不作为搜索条件。短语中去掉停用词后，两侧的词可能需要Slop才能匹配
+	StopTokenFile string
+
+	// 拼音词典文件，不为空时含汉字的关键词还以其全拼和首字母（如"百度"的"baidu"和"bd"）加入索引，
+	// 搜索时只含字母的关键词也匹配拼音相同的汉字关键词。搜索键见PinyinKeyword
+	//
+	// 每行为一个汉字及其不带声调的小写拼音，ü写作v，多音字可列出多个读音，只用第一个；
+	// 也可以是一个词及其逐字的拼音（以空格分隔），用于纠正多音字，如"重庆 chong qing"。
+	// 以#开头的行为注释
+	PinyinDictionary string
+
+	// 同义词文件，搜索时关键词也匹配其同义词，运行时可用Engine.LoadSynonyms或SetSynonyms替换，无需重建索引
+	//
+	// 每行一条规则，以#开头的行为注释。"土豆, 马铃薯, 洋芋"表示这些词互为同义词；
+	// "番茄 => 西红柿, 圣女果"表示搜索番茄时也搜索后两个词，反之不然。逗号可以是全角的
+	SynonymFile string
+
 	// 分词器线程数
 	NumSegmenterThreads int
 
@@ -64,17 +97,50 @@ type EngineInitOptions struct {
 	DefaultRankOptions *RankOptions
 
 	// 是否使用持久数据库，以及数据库文件保存的目录和裂分数目
+	// 引擎启动时会从数据库中恢复全部文档，Fields的具体类型需先用gob.Register注册
 	UsePersistentStorage    bool
 	PersistentStorageFolder string
 	PersistentStorageShards int
+
+	// 是否使用预写日志，日志文件保存在PersistentStorageFolder中
+	// IndexDocument和RemoveDocument返回前请求已写入日志并同步到磁盘，引擎重新初始化时会重放日志，
+	// 因此已返回的写请求不会因进程崩溃而丢失。文档会被gob序列化，Fields的具体类型需先用gob.Register注册
+	// 日志超过WALCheckpointSize字节时自动做检查点：不使用持久存储时将索引快照写入同一目录，然后清空日志
+	UseWAL            bool
+	WALCheckpointSize int64
+
+	// 是否保存文档原文（Content、TextFields和Fields），保存后可用SearchRequest.ReturnContent和
+	// ReturnFields随搜索结果返回。文档压缩后保存，DocumentStoreFolder为空时保存在内存中，
+	// 否则保存在该目录下的文件中，裂分数目同PersistentStorageShards。Fields的具体类型需先用gob.Register注册
+	// 内存中的文档不随快照保存，使用持久存储时引擎启动后从持久存储中恢复
+	StoreDocuments      bool
+	DocumentStoreFolder string
+}
+
+// Validate 检查EngineInitOptions中必须由用户设定的选项
+func (options *EngineInitOptions) Validate() error {
+	if !options.NotUsingSegmenter && options.SegmenterDictionaries == "" &&
+		(options.IndexAnalyzer == nil || options.QueryAnalyzer == nil) {
+		return ErrEmptyDictionaries
+	}
+	if (options.UsePersistentStorage || options.UseWAL) && options.PersistentStorageFolder == "" {
+		return ErrEmptyStorageFolder
+	}
+	if options.IndexerInitOptions != nil && options.IndexerInitOptions.BM25FParameters != nil {
+		for field := range options.IndexerInitOptions.BM25FParameters.Fields {
+			if !ValidFieldName(field) {
+				return fmt.Errorf("%w: %q", ErrInvalidFieldName, field)
+			}
+		}
+	}
+	return nil
 }
 
 // Init 初始化EngineInitOptions，当用户未设定某个选项的值时用默认值取代
+// 选项不合法时panic，见Validate
 func (options *EngineInitOptions) Init() {
-	if !options.NotUsingSegmenter {
-		if options.SegmenterDictionaries == "" {
-			log.Panic().Msg("字典文件不能为空")
-		}
+	if err := options.Validate(); err != nil {
+		log.Panic().Err(err).Msg("引擎初始化选项不合法")
 	}
 
 	if options.NumSegmenterThreads == 0 {
@@ -120,4 +186,8 @@ func (options *EngineInitOptions) Init() {
 	if options.PersistentStorageShards == 0 {
 		options.PersistentStorageShards = defaultPersistentStorageShards
 	}
+
+	if options.WALCheckpointSize == 0 {
+		options.WALCheckpointSize = defaultWALCheckpointSize
+	}
 }
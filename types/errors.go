@@ -0,0 +1,25 @@
+package types
+
+import (
+	"errors"
+)
+
+var (
+	// ErrEmptyDictionaries 使用分词器时未指定词典文件
+	ErrEmptyDictionaries = errors.New("wuneng: 字典文件不能为空")
+
+	// ErrEmptyStorageFolder 使用持久存储或预写日志时未指定存储目录
+	ErrEmptyStorageFolder = errors.New("wuneng: 存储目录不能为空")
+
+	// ErrInvalidFilter 无法解析的范围过滤条件
+	ErrInvalidFilter = errors.New("wuneng: 范围过滤条件不合法")
+
+	// ErrInvalidCursor 无法解码的翻页游标
+	ErrInvalidCursor = errors.New("wuneng: 翻页游标不合法")
+
+	// ErrInvalidFieldName 具名文本字段的字段名含有字母、数字和下划线以外的字符
+	ErrInvalidFieldName = errors.New("wuneng: 字段名只能包含字母、数字和下划线")
+
+	// ErrReservedLabel 标签以FieldKeyword或PinyinKeyword保留的前缀开头
+	ErrReservedLabel = errors.New("wuneng: 标签不能以\"field#\"或\"pinyin#\"开头")
+)
@@ -0,0 +1,105 @@
+package types
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// RangeFilter 数值属性的范围过滤条件，文档没有该属性时不满足条件
+// 零值的Min和Max都为0，建议用Between、AtLeast等函数构造
+type RangeFilter struct {
+	// 属性名，见DocumentIndexData.Attributes
+	Attribute string
+
+	// 取值范围，默认包含两个端点，无界时为math.Inf
+	Min, Max float64
+
+	// 是否不包含端点
+	ExcludeMin, ExcludeMax bool
+}
+
+// Match 判断属性值是否满足条件
+func (filter RangeFilter) Match(value float64) bool {
+	if math.IsNaN(value) {
+		return false
+	}
+	if value < filter.Min || (filter.ExcludeMin && value == filter.Min) {
+		return false
+	}
+	if value > filter.Max || (filter.ExcludeMax && value == filter.Max) {
+		return false
+	}
+	return true
+}
+
+// Between 属性值在[min, max]之间
+func Between(attribute string, min, max float64) RangeFilter {
+	return RangeFilter{Attribute: attribute, Min: min, Max: max}
+}
+
+// Equal 属性值等于value
+func Equal(attribute string, value float64) RangeFilter {
+	return RangeFilter{Attribute: attribute, Min: value, Max: value}
+}
+
+// AtLeast 属性值大于等于min
+func AtLeast(attribute string, min float64) RangeFilter {
+	return RangeFilter{Attribute: attribute, Min: min, Max: math.Inf(1)}
+}
+
+// GreaterThan 属性值大于min
+func GreaterThan(attribute string, min float64) RangeFilter {
+	return RangeFilter{Attribute: attribute, Min: min, Max: math.Inf(1), ExcludeMin: true}
+}
+
+// AtMost 属性值小于等于max
+func AtMost(attribute string, max float64) RangeFilter {
+	return RangeFilter{Attribute: attribute, Min: math.Inf(-1), Max: max}
+}
+
+// LessThan 属性值小于max
+func LessThan(attribute string, max float64) RangeFilter {
+	return RangeFilter{Attribute: attribute, Min: math.Inf(-1), Max: max, ExcludeMax: true}
+}
+
+var (
+	betweenPattern    = regexp.MustCompile(`^(?i)\s*(\S+)\s+BETWEEN\s+(\S+)\s+AND\s+(\S+)\s*$`)
+	comparisonPattern = regexp.MustCompile(`^\s*([^\s<>=]+)\s*(>=|<=|==|=|>|<)\s*(\S+)\s*$`)
+)
+
+// ParseRangeFilter 解析 "price BETWEEN 10 AND 100"、"ts >= 1577836800" 形式的过滤条件
+// 比较运算符支持 >、>=、<、<=、= 和 ==，BETWEEN包含两个端点
+func ParseRangeFilter(text string) (RangeFilter, error) {
+	if match := betweenPattern.FindStringSubmatch(text); match != nil {
+		min, err1 := strconv.ParseFloat(match[2], 64)
+		max, err2 := strconv.ParseFloat(match[3], 64)
+		if err1 != nil || err2 != nil {
+			return RangeFilter{}, fmt.Errorf("%w: %q", ErrInvalidFilter, text)
+		}
+		return Between(match[1], min, max), nil
+	}
+
+	match := comparisonPattern.FindStringSubmatch(text)
+	if match == nil || strings.EqualFold(match[1], "BETWEEN") {
+		return RangeFilter{}, fmt.Errorf("%w: %q", ErrInvalidFilter, text)
+	}
+	value, err := strconv.ParseFloat(match[3], 64)
+	if err != nil {
+		return RangeFilter{}, fmt.Errorf("%w: %q", ErrInvalidFilter, text)
+	}
+	switch match[2] {
+	case ">":
+		return GreaterThan(match[1], value), nil
+	case ">=":
+		return AtLeast(match[1], value), nil
+	case "<":
+		return LessThan(match[1], value), nil
+	case "<=":
+		return AtMost(match[1], value), nil
+	default:
+		return Equal(match[1], value), nil
+	}
+}
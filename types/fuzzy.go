@@ -0,0 +1,85 @@
+package types
+
+import (
+	"sort"
+	"unicode/utf8"
+)
+
+// FuzzyOptions 模糊匹配选项，见SearchRequest.Fuzzy
+type FuzzyOptions struct {
+	// 允许的最大编辑距离（按字符计的Levenshtein距离），小于等于0时按关键词长度自动选择：
+	// 1至2个字符为0，3至5个字符为1，更长的为2
+	MaxEdits int
+
+	// 开头必须完全相同的字符数，默认为0，增大可以明显减少需要比较的搜索键
+	PrefixLength int
+
+	// 每个关键词最多扩展出的搜索键数（不含关键词本身），默认为50，
+	// 超出时保留编辑距离小、文档数多的
+	MaxExpansions int
+
+	// 每一次编辑的得分系数，扩展出的搜索键在BM25和BM25F中的得分乘以EditBoost的编辑距离次方，
+	// 取值(0, 1]，默认为0.5
+	EditBoost float32
+}
+
+// Init 当用户未设定某个选项的值时用默认值取代
+func (options *FuzzyOptions) Init() {
+	if options.PrefixLength < 0 {
+		options.PrefixLength = 0
+	}
+	if options.MaxExpansions <= 0 {
+		options.MaxExpansions = 50
+	}
+	if options.EditBoost <= 0 || options.EditBoost > 1 {
+		options.EditBoost = 0.5
+	}
+}
+
+// Edits 返回关键词token允许的最大编辑距离
+func (options *FuzzyOptions) Edits(token string) int {
+	if options.MaxEdits > 0 {
+		return options.MaxEdits
+	}
+	switch length := utf8.RuneCountInString(token); {
+	case length <= 2:
+		return 0
+	case length <= 5:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// Boost 返回编辑距离为distance的搜索键的得分系数
+func (options *FuzzyOptions) Boost(distance int) float32 {
+	boost := float32(1)
+	for i := 0; i < distance; i++ {
+		boost *= options.EditBoost
+	}
+	return boost
+}
+
+// FuzzyTerm 模糊匹配扩展出的一个搜索键
+type FuzzyTerm struct {
+	Text string
+
+	// 与关键词的编辑距离
+	Distance int
+
+	// 包含该搜索键的文档数
+	Frequency int
+}
+
+// SortFuzzyTerms 按编辑距离从小到大排序，距离相同时按文档数从大到小，再按Text升序
+func SortFuzzyTerms(terms []FuzzyTerm) {
+	sort.Slice(terms, func(i, j int) bool {
+		if terms[i].Distance != terms[j].Distance {
+			return terms[i].Distance < terms[j].Distance
+		}
+		if terms[i].Frequency != terms[j].Frequency {
+			return terms[i].Frequency > terms[j].Frequency
+		}
+		return terms[i].Text < terms[j].Text
+	})
+}
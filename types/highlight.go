@@ -0,0 +1,27 @@
+package types
+
+// HighlightOptions 高亮选项，见SearchRequest.Highlight
+type HighlightOptions struct {
+	// 返回的片段数上限，默认为1
+	NumFragments int
+
+	// 每个片段的字节数上限，默认为100，片段总在UTF-8字符边界处截断
+	FragmentSize int
+
+	// 包围关键词的标签，默认为"<em>"和"</em>"，原文不做转义
+	PreTag  string
+	PostTag string
+}
+
+// Init 当用户未设定某个选项的值时用默认值取代
+func (options *HighlightOptions) Init() {
+	if options.NumFragments <= 0 {
+		options.NumFragments = 1
+	}
+	if options.FragmentSize <= 0 {
+		options.FragmentSize = 100
+	}
+	if options.PreTag == "" && options.PostTag == "" {
+		options.PreTag, options.PostTag = "<em>", "</em>"
+	}
+}
@@ -1,5 +1,7 @@
 package types
 
+import "strings"
+
 // DocumentIndex struct
 type DocumentIndex struct {
 	// 文本的DocID
@@ -8,8 +10,14 @@ type DocumentIndex struct {
 	// 文本的关键词长
 	TokenLength float32
 
+	// 各具名文本字段的关键词长，已计入TokenLength
+	FieldLengths map[string]float32
+
 	// 加入的索引键
 	Keywords []KeywordIndex
+
+	// 数值属性
+	Attributes map[string]float64
 }
 
 // KeywordIndex 反向索引项，这实际上标注了一个（搜索键，文档）对。
@@ -22,6 +30,46 @@ type KeywordIndex struct {
 
 	// 搜索键在文档中的起始字节位置，按照升序排列
 	Starts []int
+
+	// 与Starts一一对应的结束字节位置，为空时结束位置为起始位置加上Text的长度
+	// 过滤器改变了关键词长度（如全角转半角）或搜索键与原文不同（如拼音）时，短语和紧邻距离按原文的结束位置计算
+	Ends []int
+}
+
+// 字段和拼音搜索键的前缀，这两个命名空间是保留的，标签不能以它们开头
+const (
+	fieldKeywordPrefix  = "field#"
+	pinyinKeywordPrefix = "pinyin#"
+)
+
+// FieldKeyword 具名文本字段中关键词的搜索键，形如"field#title:手机"
+// 保留的前缀使其不与"category:phone"这类标签冲突
+func FieldKeyword(field string, text string) string {
+	return fieldKeywordPrefix + field + ":" + text
+}
+
+// SplitFieldKeyword 拆出FieldKeyword中的字段名和关键词，keyword不是FieldKeyword时ok为false
+func SplitFieldKeyword(keyword string) (field string, text string, ok bool) {
+	if !strings.HasPrefix(keyword, fieldKeywordPrefix) {
+		return "", keyword, false
+	}
+	rest := keyword[len(fieldKeywordPrefix):]
+	i := strings.IndexByte(rest, ':')
+	if i <= 0 {
+		return "", keyword, false
+	}
+	return rest[:i], rest[i+1:], true
+}
+
+// IsReservedKeyword keyword是否在字段或拼音搜索键保留的命名空间中
+func IsReservedKeyword(keyword string) bool {
+	return strings.HasPrefix(keyword, fieldKeywordPrefix) || strings.HasPrefix(keyword, pinyinKeywordPrefix)
+}
+
+// PinyinKeyword 含汉字的关键词的拼音（全拼或首字母）的搜索键，见EngineInitOptions.PinyinDictionary
+// 限定字段时为FieldKeyword(字段名, PinyinKeyword(拼音))
+func PinyinKeyword(pinyin string) string {
+	return pinyinKeywordPrefix + pinyin
 }
 
 // IndexedDocument 索引器返回结果
@@ -31,17 +79,25 @@ type IndexedDocument struct {
 	// BM25，仅当索引类型为FrequenciesIndex或者LocationsIndex时返回有效值
 	BM25 float32
 
+	// BM25F，仅当设置了IndexerInitOptions.BM25FParameters且索引类型为FrequenciesIndex或者LocationsIndex时返回有效值
+	BM25F float32
+
 	// 关键词在文档中的紧邻距离，紧邻距离的含义见computeTokenProximity的注释。
 	// 仅当索引类型为LocationsIndex时返回有效值。
 	TokenProximity int32
 
 	// 紧邻距离计算得到的关键词位置，和Lookup函数输入tokens的长度一样且一一对应。
 	// 仅当索引类型为LocationsIndex时返回有效值。
+	// 布尔查询中未出现在文档里的关键词位置为-1。
 	TokenSnippetLocations []int
 
 	// 关键词在文本中的具体位置。
 	// 仅当索引类型为LocationsIndex时返回有效值。
 	TokenLocations [][]int
+
+	// 与TokenLocations一一对应的结束位置，见KeywordIndex.Ends，为空时结束位置为起始位置加上关键词的长度
+	// 仅当索引类型为LocationsIndex时返回有效值。
+	TokenEnds [][]int
 }
 
 // DocumentsIndex 方便批量加入文档索引
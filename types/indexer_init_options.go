@@ -26,6 +26,13 @@ type IndexerInitOptions struct {
 
 	// BM25参数
 	BM25Parameters *BM25Parameters
+
+	// BM25F参数，不为nil时查找结果中同时计算BM25F，用于RankByBM25F
+	BM25FParameters *BM25FParameters
+
+	// 是否压缩反向索引表，压缩后DocID、词频和位置按块做差值和变长编码，
+	// 可大幅减少内存占用，代价是查找时需要解码，建立段和合并段时需要编码
+	CompressPostings bool
 }
 
 // BM25Parameters 见http://en.wikipedia.org/wiki/Okapi_BM25
@@ -35,9 +42,37 @@ type BM25Parameters struct {
 	B  float32
 }
 
+// BM25FParameters 按字段加权和归一化的BM25，见
+// Robertson et al. "Simple BM25 Extension to Multiple Weighted Fields"
+// 不属于Fields中任何字段的关键词（Content和未列出的字段）视作一个权重为1、归一化参数为B的字段
+// K1不大于0时使用与BM25相同的默认值
+type BM25FParameters struct {
+	K1 float32
+	B  float32
+
+	// 字段名 -> 字段参数
+	Fields map[string]FieldParameters
+}
+
+// FieldParameters 一个字段的BM25F参数
+type FieldParameters struct {
+	// 字段权重，词频乘以权重后再合并
+	Boost float32
+
+	// 字段长度归一化参数，0为不归一化，1为完全按字段长度归一化
+	B float32
+}
+
 // Init 初始化IndexerInitOptions，当用户未设定某个选项的值时用默认值取代
 func (options *IndexerInitOptions) Init() {
 	if options.DocCacheSize == 0 {
 		options.DocCacheSize = defaultDocCacheSize
 	}
+
+	// K1为0时BM25F退化为idf之和，复制一份再设默认值以免修改用户的参数
+	if options.BM25FParameters != nil && options.BM25FParameters.K1 <= 0 {
+		parameters := *options.BM25FParameters
+		parameters.K1 = defaultBM25Parameters.K1
+		options.BM25FParameters = &parameters
+	}
 }
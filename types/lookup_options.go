@@ -0,0 +1,22 @@
+package types
+
+// LookupOptions 索引器查找文档的选项，零值表示在全部文档中查找且不剪枝
+type LookupOptions struct {
+	// 不为nil时仅从这些文档中查找
+	DocIDs map[uint64]bool
+
+	// 不为空时只返回满足全部范围过滤条件的文档
+	Filters []RangeFilter
+
+	// 大于0时只需要BM25最高的TopK个文档，跳过不可能进入其中的文档（NumDocs仍计入），
+	// 仅对LocationsIndex和FrequenciesIndex有效
+	TopK int
+
+	// 剪枝时只有Ranked返回true的文档计入前TopK，为nil时全部计入
+	// 排序器会剔除的文档（如尚无评分字段的文档）不应抬高剪枝的阈值，否则结果可能不足TopK个；
+	// 剪枝时返回的文档数也只计入Ranked返回true的文档，与排序器统计的文档数一致
+	Ranked func(docID uint64) bool
+
+	// 只统计文档数，不返回文档
+	CountDocsOnly bool
+}
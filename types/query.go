@@ -0,0 +1,167 @@
+package types
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Query 布尔查询树
+//
+// 叶子节点只设置Token，表示包含该搜索键（关键词或标签）的文档；
+// 非叶子节点的匹配文档为
+//
+//	(Must 全部满足) ∩ (Should 至少满足一个) - (MustNot 任意一个满足)
+//
+// 其中空的Must或Should不参与运算，只有MustNot的节点表示全部文档中排除这些文档。
+//
+// 短语节点只设置Phrase（以及Slop或Window、InTokens），要求其中的搜索键在文档中满足位置约束，
+// 位置约束仅对LocationsIndex生效，其他索引类型下退化为AND。
+type Query struct {
+	// 叶子节点的搜索键（必须是UTF-8格式），不为空时忽略下面的子句
+	Token string
+
+	// 短语，不为空时忽略下面的子句
+	// 默认要求这些搜索键在文档中按顺序紧邻出现
+	Phrase []string
+
+	// 短语中相邻搜索键之间允许的最大间隔，单位字节（前一个的末字节到后一个的首字节）
+	Slop int
+
+	// 大于0时不要求顺序，只要求短语中全部搜索键出现在跨度不超过Window字节的范围内，此时忽略Slop
+	Window int
+
+	// 为true时Slop和Window以关键词个数而不是字节为单位：Slop为相邻搜索键之间允许夹杂的关键词数，
+	// Window为跨度内允许的关键词数（含短语本身的搜索键）。中英文混排时字节数不能反映词距，应使用此方式
+	InTokens bool
+
+	// 不为空时把Token或Phrase限定在该具名文本字段中，见DocumentIndexData.TextFields
+	Field string
+
+	// Token或Phrase中搜索键的得分系数，BM25和BM25F中这些搜索键的得分乘以此值，小于等于0时视为1
+	// 模糊匹配扩展出的搜索键用它降低得分，见SearchRequest.Fuzzy
+	Boost float32
+
+	// AND子句
+	Must []Query
+
+	// OR子句
+	Should []Query
+
+	// NOT子句
+	MustNot []Query
+}
+
+// IsLeaf 是否为叶子节点
+func (query *Query) IsLeaf() bool {
+	return query.Token != ""
+}
+
+// IsPhrase 是否为短语节点
+func (query *Query) IsPhrase() bool {
+	return !query.IsLeaf() && len(query.Phrase) > 0
+}
+
+// Keyword 返回搜索键在索引中的形式，限定字段时为FieldKeyword(Field, token)
+func (query *Query) Keyword(token string) string {
+	if query.Field == "" {
+		return token
+	}
+	return FieldKeyword(query.Field, token)
+}
+
+// ScoringTokens 返回查询树中所有不在NOT子句下的搜索键（已去重，保持出现顺序）
+// 这些搜索键用于计算BM25和紧邻距离，限定字段的搜索键见Keyword
+func (query *Query) ScoringTokens() []string {
+	tokens, _ := query.scoringTokens()
+	return tokens
+}
+
+// ScoringBoosts 返回与ScoringTokens一一对应的得分系数，同一搜索键出现多次时取最大的
+func (query *Query) ScoringBoosts() []float32 {
+	_, boosts := query.scoringTokens()
+	return boosts
+}
+
+func (query *Query) scoringTokens() ([]string, []float32) {
+	tokens := []string{}
+	boosts := []float32{}
+	seen := make(map[string]int)
+	add := func(token string, boost float32) {
+		if boost <= 0 {
+			boost = 1
+		}
+		if i, found := seen[token]; found {
+			if boost > boosts[i] {
+				boosts[i] = boost
+			}
+			return
+		}
+		seen[token] = len(tokens)
+		tokens = append(tokens, token)
+		boosts = append(boosts, boost)
+	}
+	var collect func(q *Query)
+	collect = func(q *Query) {
+		if q.IsLeaf() {
+			add(q.Keyword(q.Token), q.Boost)
+			return
+		}
+		if q.IsPhrase() {
+			for _, token := range q.Phrase {
+				add(q.Keyword(token), q.Boost)
+			}
+			return
+		}
+		for i := range q.Must {
+			collect(&q.Must[i])
+		}
+		for i := range q.Should {
+			collect(&q.Should[i])
+		}
+	}
+	collect(query)
+	return tokens, boosts
+}
+
+// String 返回查询树的可读形式，与布尔查询语句的语法一致
+func (query Query) String() string {
+	field := ""
+	if query.Field != "" {
+		field = query.Field + ":"
+	}
+	if query.IsLeaf() {
+		return field + query.Token
+	}
+	if query.IsPhrase() {
+		phrase := field + `"` + strings.Join(query.Phrase, " ") + `"`
+		unit := ""
+		if query.InTokens {
+			unit = "t"
+		}
+		if query.Window > 0 {
+			phrase += "@" + strconv.Itoa(query.Window) + unit
+		} else if query.Slop > 0 || query.InTokens {
+			phrase += "~" + strconv.Itoa(query.Slop) + unit
+		}
+		return phrase
+	}
+
+	var clauses []string
+	for _, q := range query.Must {
+		clauses = append(clauses, q.String())
+	}
+	if len(query.Should) > 0 {
+		var should []string
+		for _, q := range query.Should {
+			should = append(should, q.String())
+		}
+		if len(clauses) == 0 && len(query.MustNot) == 0 {
+			return "(" + strings.Join(should, " OR ") + ")"
+		}
+		clauses = append(clauses, "("+strings.Join(should, " OR ")+")")
+	}
+	for _, q := range query.MustNot {
+		clauses = append(clauses, "NOT "+q.String())
+	}
+	return "(" + strings.Join(clauses, " AND ") + ")"
+}
@@ -16,3 +16,12 @@ type RankByBM25 struct {
 func (rule RankByBM25) Score(doc IndexedDocument, fields interface{}) []float32 {
 	return []float32{doc.BM25}
 }
+
+// RankByBM25F 文档分数为BM25F，需设置IndexerInitOptions.BM25FParameters
+type RankByBM25F struct {
+}
+
+// Score 获取文档评分
+func (rule RankByBM25F) Score(doc IndexedDocument, fields interface{}) []float32 {
+	return []float32{doc.BM25F}
+}
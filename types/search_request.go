@@ -13,16 +13,57 @@ type SearchRequest struct {
 	// 文档标签（必须是UTF-8格式），标签不存在文档文本中，但也属于搜索键的一种
 	Labels []string
 
+	// 布尔查询树，不为nil时忽略Text、Tokens和QueryText，Labels仍作为AND条件生效
+	Query *Query
+
+	// 布尔查询语句，例如 "手机 AND (苹果 OR 华为) NOT 二手"
+	// 支持AND、OR、NOT（须大写）和括号，优先级NOT > AND > OR，相邻的词之间默认为AND，
+	// 每个词会被分词，分词结果之间为AND关系。不为空时忽略Text和Tokens
+	QueryText string
+
+	// 不为nil时开启模糊匹配，每个关键词还匹配索引中编辑距离之内的搜索键，如"baidou"匹配"baidu"，
+	// 这些搜索键的得分低于关键词本身，并出现在SearchResponse.Tokens中。
+	// 对Query和QueryText只扩展不在NOT子句下的词，短语和Labels不扩展
+	Fuzzy *FuzzyOptions
+
+	// 数值属性的范围过滤条件，多个条件之间为AND关系，在索引器查找时生效
+	Filters []RangeFilter
+
+	// 需要统计的标签前缀，例如 "category:"，各标签的命中文档数见SearchResponse.Facets
+	Facets []string
+
 	// 当不为nil时，仅从这些DocIDs包含的键中搜索（忽略值）
 	DocIDs map[uint64]bool
 
 	// 排序选项
 	RankOptions *RankOptions
 
+	// 翻页游标，取自上一页的SearchResponse.Cursor，排序选项须与上一页相同
+	// 不为空时忽略RankOptions.OutputOffset，返回排在上一页最后一个文档之后的MaxOutputs个文档
+	// Orderless时无效
+	Cursor string
+
 	// 超时，单位毫秒（千分之一秒）。此值小于等于零时不设超时。
 	// 搜索超时的情况下仍有可能返回部分排序结果。
+	// 需要随调用方取消搜索时请使用Engine.SearchContext。
 	Timeout int
 
+	// 设为true时索引器记录已找到的最高BM25，跳过不可能进入前OutputOffset+MaxOutputs的文档，
+	// 多关键词搜索时可以明显减少评分的文档数，返回的文档与不剪枝时相同，NumDocs仍为全部匹配文档数
+	// 仅当评分规则为RankByBM25、SortBy为空、ReverseOrder为false、MaxOutputs大于0
+	// 且未使用Cursor、Orderless和CountDocsOnly时生效，否则忽略
+	Prune bool
+
+	// 设为true时在ScoredDocument中返回保存的Content和TextFields，需设置EngineInitOptions.StoreDocuments
+	ReturnContent bool
+
+	// 设为true时在ScoredDocument中返回保存的Fields，需设置EngineInitOptions.StoreDocuments
+	ReturnFields bool
+
+	// 不为nil时在ScoredDocument.Highlights中返回保存的原文中高亮了关键词的片段
+	// 需设置EngineInitOptions.StoreDocuments且索引类型为LocationsIndex，对其他文本高亮请用engine.Highlight
+	Highlight *HighlightOptions
+
 	// 设为true时仅统计搜索到的文档个数，不返回具体的文档
 	CountDocsOnly bool
 
@@ -39,6 +80,14 @@ type RankOptions struct {
 	// 默认情况下（ReverseOrder=false）按照分数从大到小排序，否则从小到大排序
 	ReverseOrder bool
 
+	// 按评分字段排序的键，不为空时先依次按这些键排序，键值都相同时再按分数排序
+	// 评分规则返回空切片的文档仍会被剔除
+	SortBy []SortField
+
+	// 不为nil时只输出排在该文档之后的文档，只用到DocID、Scores和SortValues，
+	// 通常由SearchRequest.Cursor解码得到
+	SearchAfter *ScoredDocument
+
 	// 从第几条结果开始输出
 	OutputOffset int
 
@@ -17,6 +17,14 @@ type SearchResponse struct {
 
 	// 搜索到的文档个数。注意这是全部文档中满足条件的个数，可能比返回的文档数要大
 	NumDocs int
+
+	// 下一页的翻页游标，见SearchRequest.Cursor
+	// 仅当设置了MaxOutputs且本页文档数达到MaxOutputs时不为空
+	Cursor string
+
+	// 以SearchRequest.Facets中的前缀开头的标签及其命中的文档数，不包含计数为0的标签
+	// 与NumDocs在同一组文档上统计，评分规则剔除的文档不计入
+	Facets map[string]int
 }
 
 // ScoredDocument 已评分文档
@@ -34,6 +42,24 @@ type ScoredDocument struct {
 	// 关键词出现的位置
 	// 只有当IndexType == LocationsIndex时不为空
 	TokenLocations [][]int
+
+	// 与TokenLocations一一对应的结束位置，为空时结束位置为起始位置加上SearchResponse.Tokens中对应关键词的长度
+	// 拼音和经过过滤器的关键词与原文不同，以此得到原文中的范围。只有当IndexType == LocationsIndex时不为空
+	TokenEnds [][]int
+
+	// 按RankOptions.SortBy取得的排序键的值，与SortBy一一对应
+	// 值为int64、float64、string，文档没有该键时为nil
+	SortValues []interface{}
+
+	// 保存的文档原文，仅当设置了SearchRequest.ReturnContent时返回
+	Content    string
+	TextFields map[string]string
+
+	// 保存的评分字段，仅当设置了SearchRequest.ReturnFields时返回
+	Fields interface{}
+
+	// 高亮了关键词的原文片段，按片段的好坏排列，仅当设置了SearchRequest.Highlight时返回
+	Highlights []string
 }
 
 // 为了方便排序
@@ -0,0 +1,157 @@
+package types
+
+import (
+	"sort"
+)
+
+// SortOrder 排序方向
+type SortOrder int
+
+const (
+	// Descending 从大到小，默认值
+	Descending SortOrder = iota
+
+	// Ascending 从小到大
+	Ascending
+)
+
+// SortField 按文档评分字段排序的一个键
+type SortField struct {
+	// 评分字段（DocumentIndexData.Fields）中的结构体字段名或map的键，
+	// 值须为整数、浮点数、字符串或time.Time。评分字段实现了SortValuer时改用其返回值
+	Field string
+
+	// 排序方向
+	Order SortOrder
+}
+
+// SortValuer 评分字段可以实现此接口，按名字返回排序键的值
+// 返回值须为int64、float64、string或nil，nil表示文档没有该键
+type SortValuer interface {
+	SortValue(field string) interface{}
+}
+
+// CompareSortValues 比较两个排序键的值，a小于、等于、大于b时分别返回-1、0、1
+// int64和float64之间按数值比较，数值总是小于字符串，a和b都不能为nil
+func CompareSortValues(a, b interface{}) int {
+	switch x := a.(type) {
+	case int64:
+		switch y := b.(type) {
+		case int64:
+			return compareInt64(x, y)
+		case float64:
+			return compareFloat64(float64(x), y)
+		}
+		return -1
+	case float64:
+		switch y := b.(type) {
+		case int64:
+			return compareFloat64(x, float64(y))
+		case float64:
+			return compareFloat64(x, y)
+		}
+		return -1
+	case string:
+		if y, ok := b.(string); ok {
+			if x < y {
+				return -1
+			} else if x > y {
+				return 1
+			}
+			return 0
+		}
+		return 1
+	}
+	return 0
+}
+
+func compareInt64(x, y int64) int {
+	if x < y {
+		return -1
+	} else if x > y {
+		return 1
+	}
+	return 0
+}
+
+func compareFloat64(x, y float64) int {
+	if x < y {
+		return -1
+	} else if x > y {
+		return 1
+	}
+	return 0
+}
+
+// SortScoredDocuments 按排序选项排列文档，顺序见CompareScoredDocuments
+func SortScoredDocuments(docs ScoredDocuments, options RankOptions) {
+	sort.Sort(sortedDocuments{docs, options})
+}
+
+// CompareScoredDocuments 按排序选项比较两个文档，a排在b之前、相同、之后时分别返回-1、0、1
+// SortBy不为空时先依次按各排序键比较，没有该键的文档排在最后；
+// 然后按评分比较，默认从大到小，ReverseOrder时从小到大；评分相同时按DocID，方向同评分
+func CompareScoredDocuments(a, b ScoredDocument, options RankOptions) int {
+	for k, field := range options.SortBy {
+		var x, y interface{}
+		if k < len(a.SortValues) {
+			x = a.SortValues[k]
+		}
+		if k < len(b.SortValues) {
+			y = b.SortValues[k]
+		}
+		if x == nil || y == nil {
+			if x != nil {
+				return -1
+			}
+			if y != nil {
+				return 1
+			}
+			continue
+		}
+		if c := CompareSortValues(x, y); c != 0 {
+			if field.Order == Ascending {
+				return c
+			}
+			return -c
+		}
+	}
+
+	c := compareScores(a, b)
+	if options.ReverseOrder {
+		return -c
+	}
+	return c
+}
+
+// 按评分从大到小、DocID从大到小比较
+func compareScores(a, b ScoredDocument) int {
+	for i := 0; i < len(a.Scores) && i < len(b.Scores); i++ {
+		if a.Scores[i] > b.Scores[i] {
+			return -1
+		} else if a.Scores[i] < b.Scores[i] {
+			return 1
+		}
+	}
+	if len(a.Scores) != len(b.Scores) {
+		if len(a.Scores) > len(b.Scores) {
+			return -1
+		}
+		return 1
+	}
+	if a.DocID > b.DocID {
+		return -1
+	} else if a.DocID < b.DocID {
+		return 1
+	}
+	return 0
+}
+
+type sortedDocuments struct {
+	ScoredDocuments
+	options RankOptions
+}
+
+func (docs sortedDocuments) Less(i, j int) bool {
+	return CompareScoredDocuments(docs.ScoredDocuments[i], docs.ScoredDocuments[j], docs.options) < 0
+}
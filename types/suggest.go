@@ -0,0 +1,24 @@
+package types
+
+import (
+	"sort"
+)
+
+// Suggestion 一个自动补全建议，见Engine.Suggest
+type Suggestion struct {
+	// 以前缀开头的搜索键
+	Text string
+
+	// 包含该搜索键的文档数
+	Frequency int
+}
+
+// SortSuggestions 按文档数从大到小排序，文档数相同时按Text升序
+func SortSuggestions(suggestions []Suggestion) {
+	sort.Slice(suggestions, func(i, j int) bool {
+		if suggestions[i].Frequency != suggestions[j].Frequency {
+			return suggestions[i].Frequency > suggestions[j].Frequency
+		}
+		return suggestions[i].Text < suggestions[j].Text
+	})
+}
@@ -0,0 +1,10 @@
+package types
+
+// SynonymRule 一条同义词规则，见Engine.SetSynonyms
+//
+// Synonyms为空时Words中的词互为同义词（双向），搜索其中任何一个词时也搜索其他词；
+// 否则为单向，搜索Words中的词时也搜索Synonyms中的词，反之不然
+type SynonymRule struct {
+	Words    []string
+	Synonyms []string
+}